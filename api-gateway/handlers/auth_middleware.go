@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"ecommerce/shared/auth"
+	"ecommerce/shared/models"
+)
+
+// Identity headers the gateway injects for backends after validating the JWT
+// Client-supplied values are always stripped so they can't be spoofed
+const (
+	HeaderUserID   = "X-User-ID"
+	HeaderUserRole = "X-User-Role"
+)
+
+// AuthMiddleware validates the Bearer token before a request is proxied
+// publicRoutes lists "METHOD /route/path" entries (gin route paths, e.g.
+// "GET /api/v1/products/:id") that skip validation
+func AuthMiddleware(keys *auth.Keyring, allowedAlgs []string, denylist *auth.Denylist, publicRoutes []string) gin.HandlerFunc {
+	public := make(map[string]bool, len(publicRoutes))
+	for _, route := range publicRoutes {
+		public[route] = true
+	}
+
+	return func(c *gin.Context) {
+		c.Request.Header.Del(HeaderUserID)
+		c.Request.Header.Del(HeaderUserRole)
+
+		if c.Request.Method == http.MethodOptions || public[c.Request.Method+" "+c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		// Format: "Bearer <token>"
+		parts := strings.Split(c.GetHeader("Authorization"), " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			c.JSON(http.StatusUnauthorized, models.APIResponse{
+				Success: false,
+				Error:   "Authorization header required",
+			})
+			c.Abort()
+			return
+		}
+
+		claims, err := auth.ParseToken(parts[1], keys, allowedAlgs)
+		if err != nil || denylist.IsDenied(c.Request.Context(), claims.ID) {
+			c.JSON(http.StatusUnauthorized, models.APIResponse{
+				Success: false,
+				Error:   "Invalid or expired token",
+			})
+			c.Abort()
+			return
+		}
+
+		// Backends trust these headers because only the gateway sets them
+		c.Request.Header.Set(HeaderUserID, claims.UserID)
+		c.Request.Header.Set(HeaderUserRole, claims.Role)
+		c.Set(auth.ContextUserID, claims.UserID)
+		c.Set(auth.ContextRole, claims.Role)
+
+		c.Next()
+	}
+}
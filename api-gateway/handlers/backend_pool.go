@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// backendInstance is one base URL of a service, with its own circuit breaker
+type backendInstance struct {
+	url     string
+	breaker *CircuitBreaker
+}
+
+// BackendPool round-robins requests across a service's instances, skipping
+// those whose circuit breaker is open
+type BackendPool struct {
+	instances []*backendInstance
+	next      uint64
+}
+
+// NewBackendPool creates a pool over urls; each instance's breaker opens after
+// breakerThreshold consecutive failures, for breakerCooldown
+func NewBackendPool(urls []string, breakerThreshold int, breakerCooldown time.Duration) *BackendPool {
+	pool := &BackendPool{}
+	for _, url := range urls {
+		pool.instances = append(pool.instances, &backendInstance{
+			url:     strings.TrimRight(url, "/"),
+			breaker: NewCircuitBreaker(breakerThreshold, breakerCooldown),
+		})
+	}
+	return pool
+}
+
+// pick returns the next instance whose breaker admits a request
+// If none does, it returns nil and the shortest wait until one might
+func (p *BackendPool) pick() (*backendInstance, time.Duration) {
+	if len(p.instances) == 0 {
+		return nil, 0
+	}
+
+	start := atomic.AddUint64(&p.next, 1) - 1
+	var wait time.Duration
+	for i := range p.instances {
+		instance := p.instances[(start+uint64(i))%uint64(len(p.instances))]
+		allowed, retryAfter := instance.breaker.Allow()
+		if allowed {
+			return instance, 0
+		}
+		if wait == 0 || retryAfter < wait {
+			wait = retryAfter
+		}
+	}
+	return nil, wait
+}
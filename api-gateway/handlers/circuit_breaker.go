@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker states, as reported by the health check
+const (
+	BreakerClosed   = "closed"    // Requests flow normally
+	BreakerOpen     = "open"      // Requests fail fast until the cooldown ends
+	BreakerHalfOpen = "half-open" // One probe request decides whether to close again
+)
+
+// CircuitBreaker stops the gateway forwarding to a backend that keeps failing
+// After threshold consecutive failures it opens for cooldown; then a single
+// probe is let through, which closes it on success or reopens it on failure
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker creates a closed breaker; a threshold below 1 disables it
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     BreakerClosed,
+	}
+}
+
+// Allow reports whether a request may be sent, and if not, how long until the
+// breaker will let a probe through
+func (b *CircuitBreaker) Allow() (bool, time.Duration) {
+	if b.threshold < 1 {
+		return true, 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		remaining := b.cooldown - time.Since(b.openedAt)
+		if remaining > 0 {
+			return false, remaining
+		}
+		b.state = BreakerHalfOpen // This request is the probe
+		return true, 0
+	case BreakerHalfOpen:
+		return false, b.cooldown // Wait for the probe's outcome
+	default:
+		return true, 0
+	}
+}
+
+// Success records a request that reached the backend and closes the breaker
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = BreakerClosed
+	b.failures = 0
+}
+
+// Failure records a failed request, opening the breaker once the threshold
+// is reached or when the half-open probe fails
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// State returns the breaker's current state
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
@@ -2,103 +2,160 @@ package handlers
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"math"
+	"math/rand"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
+	"ecommerce/shared/middleware"
 	"ecommerce/shared/models"
 )
 
+// maxHealthBodyBytes caps how much of a backend health response is read
+const maxHealthBodyBytes = 4 << 10
+
+// healthCacheTTL is how long an aggregated health result is reused, so
+// frequent or hostile probes don't each fan out to every backend
+const healthCacheTTL = 3 * time.Second
+
 type ProxyHandler struct {
-	userServiceURL    string
-	productServiceURL string
-	orderServiceURL   string
-	logger            *zap.Logger
-	httpClient        *http.Client
+	pools          map[string]*BackendPool // Service name -> instances requests are balanced across
+	healthTimeout  time.Duration           // Shared deadline for the health fan-out
+	retries        int                     // Extra attempts for requests that are safe to repeat
+	retryBaseDelay time.Duration           // Backoff before the first retry; doubles per retry
+	logger         *zap.Logger
+	httpClient     *http.Client
+	healthClient   *http.Client // Times out after healthTimeout, whatever context it is given
+
+	// healthMu guards the cached health result; it is held through a fan-out
+	// so concurrent probes wait for one check instead of starting their own
+	healthMu      sync.Mutex
+	health        models.HealthCheckResponse
+	healthHealthy bool
 }
 
-func NewProxyHandler(userURL, productURL, orderURL string, logger *zap.Logger) *ProxyHandler {
+// NewProxyHandler creates the gateway proxy over backends (service name ->
+// instance base URLs); each instance gets a circuit breaker that opens after
+// breakerThreshold consecutive failures, for breakerCooldown
+// Safe requests are retried up to retries times, starting retryBaseDelay apart
+func NewProxyHandler(backends map[string][]string, healthTimeout time.Duration, breakerThreshold int, breakerCooldown time.Duration, retries int, retryBaseDelay time.Duration, logger *zap.Logger) *ProxyHandler {
+	pools := make(map[string]*BackendPool, len(backends))
+	for name, urls := range backends {
+		pools[name] = NewBackendPool(urls, breakerThreshold, breakerCooldown)
+	}
+
 	return &ProxyHandler{
-		userServiceURL:    userURL,
-		productServiceURL: productURL,
-		orderServiceURL:   orderURL,
-		logger:            logger,
+		pools:          pools,
+		healthTimeout:  healthTimeout,
+		retries:        retries,
+		retryBaseDelay: retryBaseDelay,
+		logger:         logger,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		healthClient: &http.Client{
+			Timeout: healthTimeout,
+		},
 	}
 }
 
 // ProxyToUserService forwards requests to User Service
 func (h *ProxyHandler) ProxyToUserService(c *gin.Context) {
-	h.proxyRequest(c, h.userServiceURL, "user-service")
+	h.proxyRequest(c, "user-service")
 }
 
 // ProxyToProductService forwards requests to Product Service
 func (h *ProxyHandler) ProxyToProductService(c *gin.Context) {
-	h.proxyRequest(c, h.productServiceURL, "product-service")
+	h.proxyRequest(c, "product-service")
 }
 
 // ProxyToOrderService forwards requests to Order Service
 func (h *ProxyHandler) ProxyToOrderService(c *gin.Context) {
-	h.proxyRequest(c, h.orderServiceURL, "order-service")
+	h.proxyRequest(c, "order-service")
+}
+
+// ProxyToNotificationService forwards requests to Notification Service
+func (h *ProxyHandler) ProxyToNotificationService(c *gin.Context) {
+	h.proxyRequest(c, "notification-service")
+}
+
+// GetUserStats serves the account order summary from Order Service
+// GET /api/v1/users/me/stats -> order-service GET /api/v1/orders/stats
+func (h *ProxyHandler) GetUserStats(c *gin.Context) {
+	h.proxyRequestToPath(c, "/api/v1/orders/stats", "order-service")
+}
+
+// proxyRequest forwards the request to the same path on the target service
+func (h *ProxyHandler) proxyRequest(c *gin.Context, serviceName string) {
+	h.proxyRequestToPath(c, c.Request.URL.Path, serviceName)
 }
 
-// proxyRequest is the core proxy logic
-func (h *ProxyHandler) proxyRequest(c *gin.Context, targetBaseURL, serviceName string) {
+// proxyRequestToPath is the core proxy logic
+func (h *ProxyHandler) proxyRequestToPath(c *gin.Context, targetPath, serviceName string) {
 	startTime := time.Now()
 
-	// Build target URL
-	targetURL := targetBaseURL + c.Request.URL.Path
+	// Build target path; the instance's base URL is chosen per attempt
+	target := targetPath
 	if c.Request.URL.RawQuery != "" {
-		targetURL += "?" + c.Request.URL.RawQuery
+		target += "?" + c.Request.URL.RawQuery
 	}
 
 	h.logger.Info("Proxying request",
 		zap.String("method", c.Request.Method),
 		zap.String("path", c.Request.URL.Path),
 		zap.String("target_service", serviceName),
-		zap.String("target_url", targetURL),
+		zap.String("target_path", target),
 	)
 
 	// Read request body
 	var bodyBytes []byte
 	if c.Request.Body != nil {
-		bodyBytes, _ = io.ReadAll(c.Request.Body)
+		var err error
+		bodyBytes, err = io.ReadAll(c.Request.Body)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.JSON(http.StatusRequestEntityTooLarge, models.APIResponse{
+					Success: false,
+					Error:   fmt.Sprintf("request body must not exceed %d bytes", tooLarge.Limit),
+				})
+				return
+			}
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   "Failed to read request body",
+			})
+			return
+		}
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 	}
 
-	// Create proxy request
-	proxyReq, err := http.NewRequest(c.Request.Method, targetURL, bytes.NewBuffer(bodyBytes))
-	if err != nil {
-		h.logger.Error("Failed to create proxy request", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   "Failed to proxy request",
-		})
-		return
-	}
-
 	// Copy headers (important for authentication)
-	for key, values := range c.Request.Header {
-		for _, value := range values {
-			proxyReq.Header.Add(key, value)
-		}
-	}
+	header := c.Request.Header.Clone()
 
 	// Add request tracking header
-	proxyReq.Header.Set("X-Gateway-Request-ID", c.GetString("request_id"))
+	header.Set("X-Gateway-Request-ID", c.GetString(middleware.ContextRequestID))
 
 	// Execute proxy request
-	resp, err := h.httpClient.Do(proxyReq)
+	resp, err := h.doWithRetry(c.Request.Context(), c.Request.Method, target, header, bodyBytes, serviceName)
 	if err != nil {
 		h.logger.Error("Proxy request failed",
 			zap.Error(err),
 			zap.String("service", serviceName),
 		)
+		var unavailable *unavailableError
+		if errors.As(err, &unavailable) && unavailable.retryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(unavailable.retryAfter.Seconds()))))
+		}
 		c.JSON(http.StatusServiceUnavailable, models.APIResponse{
 			Success: false,
 			Error:   "Service unavailable: " + serviceName,
@@ -137,8 +194,139 @@ func (h *ProxyHandler) proxyRequest(c *gin.Context, targetBaseURL, serviceName s
 	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), respBody)
 }
 
-// HealthCheck checks gateway and all backend services
+// unavailableError means no instance of a service could take the request,
+// because none is configured or every circuit breaker is open
+type unavailableError struct {
+	service    string
+	retryAfter time.Duration // Until an open breaker lets a probe through; 0 if unknown
+}
+
+func (e *unavailableError) Error() string {
+	return "no available instance of " + e.service
+}
+
+// doWithRetry sends a request to one of the service's instances, retrying
+// transport errors and outage statuses up to h.retries times when the request
+// is safe to repeat; each retry goes to the next available instance
+// Every attempt is recorded on its instance's circuit breaker
+func (h *ProxyHandler) doWithRetry(ctx context.Context, method, target string, header http.Header, body []byte, serviceName string) (*http.Response, error) {
+	pool := h.pools[serviceName]
+	if pool == nil {
+		return nil, &unavailableError{service: serviceName}
+	}
+
+	attempts := 1
+	if isRetryable(method, header) {
+		attempts += h.retries
+	}
+
+	for attempt := 1; ; attempt++ {
+		instance, wait := pool.pick()
+		if instance == nil {
+			return nil, &unavailableError{service: serviceName, retryAfter: wait}
+		}
+
+		var reqBody io.Reader = http.NoBody
+		if len(body) > 0 {
+			reqBody = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, instance.url+target, reqBody)
+		if err != nil {
+			return nil, err
+		}
+		req.Header = header.Clone()
+
+		resp, err := h.httpClient.Do(req)
+		// Only outages count against the instance, not ordinary 500s from one endpoint
+		failed := err != nil || isOutageStatus(resp.StatusCode)
+		if failed {
+			instance.breaker.Failure()
+		} else {
+			instance.breaker.Success()
+		}
+		if !failed || attempt >= attempts {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, maxHealthBodyBytes))
+			resp.Body.Close()
+		}
+
+		delay := h.retryDelay(attempt)
+		h.logger.Warn("Retrying proxied request",
+			zap.String("service", serviceName),
+			zap.String("instance", instance.url),
+			zap.Int("attempt", attempt),
+			zap.Duration("delay", delay),
+			zap.Error(err),
+		)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// isRetryable reports whether a request can safely be sent twice: safe
+// methods, and requests the client marked with an Idempotency-Key
+// PUT and DELETE aren't assumed idempotent, since e.g. stock updates apply a delta
+func isRetryable(method string, header http.Header) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return header.Get("Idempotency-Key") != ""
+	}
+}
+
+// retryDelay returns the backoff before retry number attempt: the base delay
+// doubled per attempt, with jitter so retries from many clients spread out
+func (h *ProxyHandler) retryDelay(attempt int) time.Duration {
+	delay := h.retryBaseDelay << (attempt - 1)
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// isOutageStatus reports whether a backend status means it is down or overloaded
+func isOutageStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// HealthCheck checks gateway and every backend instance
+// Each instance's circuit breaker state is reported under its key plus "_circuit"
+// The result is cached for healthCacheTTL; its timestamp says when it was taken
 func (h *ProxyHandler) HealthCheck(c *gin.Context) {
+	response, healthy := h.cachedHealth()
+	if !healthy {
+		c.JSON(http.StatusServiceUnavailable, response)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// cachedHealth returns the last health result if it is recent enough, and
+// checks the backends again otherwise
+func (h *ProxyHandler) cachedHealth() (models.HealthCheckResponse, bool) {
+	h.healthMu.Lock()
+	defer h.healthMu.Unlock()
+
+	if time.Since(h.health.Timestamp) < healthCacheTTL {
+		return h.health, h.healthHealthy
+	}
+
+	// Not tied to the probe's request: the result is shared with other callers
+	h.health, h.healthHealthy = h.checkBackends(context.Background())
+	return h.health, h.healthHealthy
+}
+
+// checkBackends checks every backend instance and reports whether all are healthy
+func (h *ProxyHandler) checkBackends(ctx context.Context) (models.HealthCheckResponse, bool) {
 	response := models.HealthCheckResponse{
 		Status:    "healthy",
 		Service:   "api-gateway",
@@ -146,34 +334,66 @@ func (h *ProxyHandler) HealthCheck(c *gin.Context) {
 		Checks:    make(map[string]string),
 	}
 
-	// Check all backend services
-	services := map[string]string{
-		"user-service":    h.userServiceURL + "/health",
-		"product-service": h.productServiceURL + "/health",
-		"order-service":   h.orderServiceURL + "/health",
-	}
+	// Check concurrently under one short deadline, so the probe takes as long
+	// as the slowest backend and a hung one can't stall it past healthTimeout
+	ctx, cancel := context.WithTimeout(ctx, h.healthTimeout)
+	defer cancel()
 
+	var mu sync.Mutex
+	var wg sync.WaitGroup
 	allHealthy := true
-	for name, url := range services {
-		resp, err := h.httpClient.Get(url)
-		if err != nil || resp.StatusCode != http.StatusOK {
-			response.Checks[name] = "unhealthy"
-			allHealthy = false
-		} else {
-			response.Checks[name] = "healthy"
-		}
-		if resp != nil {
-			resp.Body.Close()
+	for name, pool := range h.pools {
+		for _, instance := range pool.instances {
+			// Services with several instances report each under "<service> <url>"
+			key := name
+			if len(pool.instances) > 1 {
+				key = name + " " + instance.url
+			}
+
+			wg.Add(1)
+			go func(key string, instance *backendInstance) {
+				defer wg.Done()
+				healthy := h.checkHealth(ctx, instance.url+"/health")
+
+				mu.Lock()
+				defer mu.Unlock()
+				response.Checks[key+"_circuit"] = instance.breaker.State()
+				if healthy {
+					response.Checks[key] = "healthy"
+				} else {
+					response.Checks[key] = "unhealthy"
+					allHealthy = false
+				}
+			}(key, instance)
 		}
 	}
+	wg.Wait()
 
 	if !allHealthy {
 		response.Status = "degraded"
-		c.JSON(http.StatusServiceUnavailable, response)
-		return
 	}
+	return response, allHealthy
+}
 
-	c.JSON(http.StatusOK, response)
+// checkHealth reports whether a backend health endpoint answers 200 before ctx
+// expires; a refused connection or any other transport error is unhealthy
+func (h *ProxyHandler) checkHealth(ctx context.Context, url string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false
+	}
+
+	// Do returns a response, with a non-nil body, only when err is nil
+	resp, err := h.healthClient.Do(req)
+	if err != nil || resp == nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	// Drain a bounded amount so the connection can be reused without trusting the body size
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxHealthBodyBytes))
+
+	return resp.StatusCode == http.StatusOK
 }
 
 // ReadinessCheck checks if gateway is ready
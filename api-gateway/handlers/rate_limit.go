@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+
+	"ecommerce/shared/auth"
+	"ecommerce/shared/cache"
+	"ecommerce/shared/models"
+)
+
+// RateLimiter caps requests per client with a sliding window counter
+// Counts live in Redis so the limit is shared by every gateway replica. The
+// previous window's count is weighted by how much of it still overlaps the
+// sliding window, which smooths out bursts at window boundaries
+type RateLimiter struct {
+	redis  *redis.Client
+	keys   cache.Keyer
+	limit  int
+	window time.Duration
+	logger *zap.Logger
+}
+
+// NewRateLimiter allows limit requests per window for each client
+func NewRateLimiter(redisClient *redis.Client, keys cache.Keyer, limit int, window time.Duration, logger *zap.Logger) *RateLimiter {
+	return &RateLimiter{
+		redis:  redisClient,
+		keys:   keys,
+		limit:  limit,
+		window: window,
+		logger: logger,
+	}
+}
+
+// Middleware rejects requests over the limit with 429 and a Retry-After header
+// Authenticated clients are limited by user ID, others by IP, so it must run
+// after AuthMiddleware. Requests are let through if Redis is unavailable
+func (l *RateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l.limit <= 0 || c.Request.Method == http.MethodOptions {
+			c.Next()
+			return
+		}
+
+		client := "ip:" + c.ClientIP()
+		if userID := c.GetString(auth.ContextUserID); userID != "" {
+			client = "user:" + userID
+		}
+
+		allowed, retryAfter, err := l.allow(c, client)
+		if err != nil {
+			l.logger.Warn("Rate limiter unavailable; allowing request", zap.Error(err))
+			c.Next()
+			return
+		}
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, models.APIResponse{
+				Success: false,
+				Error:   "Too many requests",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// allow counts a request from client and reports whether it is within the
+// limit, and if not, how long until the client should retry
+func (l *RateLimiter) allow(c *gin.Context, client string) (bool, time.Duration, error) {
+	ctx := c.Request.Context()
+	now := time.Now()
+	window := now.UnixNano() / int64(l.window)
+	currentKey := l.keys.Key("ratelimit:%s:%d", client, window)
+	previousKey := l.keys.Key("ratelimit:%s:%d", client, window-1)
+
+	pipe := l.redis.TxPipeline()
+	current := pipe.Incr(ctx, currentKey)
+	pipe.Expire(ctx, currentKey, 2*l.window) // Kept for one window as the next one's previous
+	previous := pipe.Get(ctx, previousKey)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return false, 0, err
+	}
+	previousCount, _ := previous.Int64() // Missing key: no requests last window
+
+	elapsed := time.Duration(now.UnixNano() % int64(l.window))
+	overlap := 1 - float64(elapsed)/float64(l.window)
+	estimate := float64(previousCount)*overlap + float64(current.Val())
+	if estimate <= float64(l.limit) {
+		return true, 0, nil
+	}
+
+	// By the end of this window the previous one no longer counts
+	retryAfter := l.window - elapsed
+	if retryAfter < time.Second {
+		retryAfter = time.Second
+	}
+	return false, retryAfter, nil
+}
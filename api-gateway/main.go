@@ -11,11 +11,15 @@ import (
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 	"go.uber.org/zap"
 
 	"ecommerce/api-gateway/handlers"
+	"ecommerce/shared/auth"
+	"ecommerce/shared/cache"
 	"ecommerce/shared/config"
 	"ecommerce/shared/logger"
+	"ecommerce/shared/middleware"
 )
 
 func main() {
@@ -27,33 +31,85 @@ func main() {
 	}
 	defer log.Sync()
 
+	// Refuse to start in production with insecure or missing settings
+	warnings, err := cfg.Validate()
+	if err != nil {
+		log.Fatal("Invalid configuration", zap.Error(err))
+	}
+	for _, warning := range warnings {
+		log.Warn("Configuration warning", zap.String("problem", warning))
+	}
+
 	log.Info("Starting API Gateway",
 		zap.String("environment", cfg.Environment),
 		zap.String("port", cfg.Port),
 	)
 
+	// Redis holds the rate limiter's counters
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     cfg.GetRedisURL(),
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDB,
+	})
+	defer redisClient.Close()
+	keys := cache.NewKeyer(cfg.RedisKeyPrefix)
+
+	// The token denylist written by user-service on logout lives on REDIS_SHARED_DB
+	sharedRedis := redisClient
+	if cfg.RedisSharedDB != cfg.RedisDB {
+		sharedRedis = redis.NewClient(&redis.Options{
+			Addr:     cfg.GetRedisURL(),
+			Password: cfg.RedisPassword,
+			DB:       cfg.RedisSharedDB,
+		})
+		defer sharedRedis.Close()
+	}
+	denylist := auth.NewDenylist(sharedRedis, keys)
+
 	proxyHandler := handlers.NewProxyHandler(
-		cfg.UserServiceURL,
-		cfg.ProductServiceURL,
-		cfg.OrderServiceURL,
+		cfg.BackendServices(),
+		cfg.HealthCheckTimeout,
+		cfg.CircuitBreakerThreshold,
+		cfg.CircuitBreakerCooldown,
+		cfg.ProxyRetries,
+		cfg.ProxyRetryBaseDelay,
 		log.Logger,
 	)
 
 	if cfg.IsProduction() {
 		gin.SetMode(gin.ReleaseMode)
 	}
-	router := gin.Default()
+	// gin.New instead of gin.Default: requests are logged as structured JSON and
+	// panics answered with the usual APIResponse body
+	router := gin.New()
+	router.Use(middleware.RequestID(), middleware.AccessLog(log.Logger), middleware.Recovery(log.Logger))
+
+	// The proxy buffers each request body, so cap it before anything reads it
+	router.Use(middleware.BodyLimit(int64(cfg.MaxBodyBytes), map[string]int64{
+		"/api/v1/admin/products": int64(cfg.MaxBulkBodyBytes),
+	}))
 
 	// ADD CORS MIDDLEWARE HERE
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:5173", "http://localhost:3000"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-User-ID"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Idempotency-Key"},
+		ExposeHeaders:    []string{"Content-Length", "Retry-After"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
+	// Validate JWTs at the edge; everything not listed here needs a token
+	jwtKeys, err := auth.NewKeyring(cfg.JWTKeyID, cfg.JWTSecret, cfg.JWTPreviousSecrets)
+	if err != nil {
+		log.Fatal("Invalid JWT configuration", zap.Error(err))
+	}
+	router.Use(handlers.AuthMiddleware(jwtKeys, cfg.JWTAlgorithms, denylist, publicRoutes))
+
+	// Limits are counted in Redis so they hold across gateway replicas
+	rateLimiter := handlers.NewRateLimiter(redisClient, keys, cfg.RateLimitRequests, cfg.RateLimitWindow, log.Logger)
+	router.Use(rateLimiter.Middleware())
+
 	setupRoutes(router, proxyHandler)
 
 	srv := &http.Server{
@@ -74,7 +130,7 @@ func main() {
 
 	log.Info("Shutting down API Gateway...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
@@ -84,6 +140,24 @@ func main() {
 	log.Info("API Gateway exited")
 }
 
+// publicRoutes are served without a token
+var publicRoutes = []string{
+	"GET /health",
+	"GET /ready",
+	"POST /api/v1/auth/register",
+	"POST /api/v1/auth/login",
+	"POST /api/v1/auth/refresh",
+	"POST /api/v1/auth/forgot-password",
+	"POST /api/v1/auth/reset-password",
+	"GET /api/v1/products",
+	"GET /api/v1/products/:id",
+	"GET /api/v1/products/category/:category",
+	"GET /api/v1/products/categories",
+	"GET /api/v1/products/search",
+	"GET /api/v1/categories",
+	"GET /api/v1/categories/:id",
+}
+
 func setupRoutes(router *gin.Engine, handler *handlers.ProxyHandler) {
 	router.GET("/health", handler.HealthCheck)
 	router.GET("/ready", handler.ReadinessCheck)
@@ -94,12 +168,18 @@ func setupRoutes(router *gin.Engine, handler *handlers.ProxyHandler) {
 		{
 			auth.POST("/register", handler.ProxyToUserService)
 			auth.POST("/login", handler.ProxyToUserService)
+			auth.POST("/refresh", handler.ProxyToUserService)
+			auth.POST("/forgot-password", handler.ProxyToUserService)
+			auth.POST("/reset-password", handler.ProxyToUserService)
+			auth.POST("/logout", handler.ProxyToUserService)
 		}
 
 		users := api.Group("/users")
 		{
 			users.GET("/me", handler.ProxyToUserService)
 			users.PUT("/me", handler.ProxyToUserService)
+			users.GET("/me/stats", handler.GetUserStats) // Aggregated from order-service
+			users.GET("/me/permissions", handler.ProxyToUserService)
 			users.GET("/:id", handler.ProxyToUserService)
 		}
 
@@ -108,20 +188,64 @@ func setupRoutes(router *gin.Engine, handler *handlers.ProxyHandler) {
 			products.GET("", handler.ProxyToProductService)
 			products.GET("/:id", handler.ProxyToProductService)
 			products.GET("/category/:category", handler.ProxyToProductService)
+			products.GET("/categories", handler.ProxyToProductService)
 			products.GET("/search", handler.ProxyToProductService)
 			products.POST("", handler.ProxyToProductService)
 			products.PUT("/:id", handler.ProxyToProductService)
 			products.DELETE("/:id", handler.ProxyToProductService)
 			products.PUT("/:id/stock", handler.ProxyToProductService)
+			products.PUT("/:id/stock/set", handler.ProxyToProductService)
+			products.POST("/:id/images", handler.ProxyToProductService)
+			products.DELETE("/:id/images/:imageId", handler.ProxyToProductService)
+			products.GET("/:id/stock-history", handler.ProxyToProductService)
+		}
+
+		categories := api.Group("/categories")
+		{
+			categories.GET("", handler.ProxyToProductService)
+			categories.GET("/:id", handler.ProxyToProductService)
+			categories.POST("", handler.ProxyToProductService)
+			categories.PUT("/:id", handler.ProxyToProductService)
+			categories.DELETE("/:id", handler.ProxyToProductService)
+		}
+
+		notifications := api.Group("/notifications")
+		{
+			notifications.GET("/preferences", handler.ProxyToNotificationService)
+			notifications.PUT("/preferences", handler.ProxyToNotificationService)
+		}
+
+		admin := api.Group("/admin")
+		{
+			admin.GET("/users", handler.ProxyToUserService)
+			admin.GET("/users/search", handler.ProxyToUserService)
+			admin.DELETE("/users/:id", handler.ProxyToUserService)
+			admin.PUT("/users/:id/role", handler.ProxyToUserService)
+			admin.POST("/users/:id/deactivate", handler.ProxyToUserService)
+			admin.POST("/users/:id/reactivate", handler.ProxyToUserService)
+			admin.DELETE("/products", handler.ProxyToProductService)
+			admin.POST("/orders/:id/replay-events", handler.ProxyToOrderService)
+			admin.GET("/maintenance", handler.ProxyToUserService)
+			admin.PUT("/maintenance", handler.ProxyToUserService)
+			admin.GET("/notification-templates", handler.ProxyToNotificationService)
+			admin.POST("/notification-templates", handler.ProxyToNotificationService)
+			admin.POST("/notification-templates/preview", handler.ProxyToNotificationService)
+			admin.GET("/notification-templates/:id", handler.ProxyToNotificationService)
+			admin.PUT("/notification-templates/:id", handler.ProxyToNotificationService)
+			admin.DELETE("/notification-templates/:id", handler.ProxyToNotificationService)
 		}
 
 		orders := api.Group("/orders")
 		{
 			orders.POST("", handler.ProxyToOrderService)
 			orders.GET("", handler.ProxyToOrderService)
+			orders.POST("/batch", handler.ProxyToOrderService)
 			orders.GET("/:id", handler.ProxyToOrderService)
 			orders.PUT("/:id/cancel", handler.ProxyToOrderService)
+			orders.PUT("/:id/ship", handler.ProxyToOrderService)
 			orders.GET("/:id/status", handler.ProxyToOrderService)
+			orders.GET("/:id/history", handler.ProxyToOrderService)
+			orders.PUT("/:id/status", handler.ProxyToOrderService)
 		}
 	}
 }
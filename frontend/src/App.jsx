@@ -90,7 +90,7 @@ const App = () => {
     try {
       const response = await fetch(`${API_BASE}/products`);
       const data = await response.json();
-      if (data.success) setProducts(data.data || []);
+      if (data.success) setProducts(data.data?.items || []);
     } catch (error) {
       console.error('Failed to fetch products:', error);
     }
@@ -170,7 +170,7 @@ const App = () => {
         }
       });
       const data = await response.json();
-      if (data.success) setOrders(data.data || []);
+      if (data.success) setOrders(data.data?.items || []);
     } catch (error) {
       console.error('Failed to fetch orders:', error);
     }
@@ -1,36 +1,46 @@
 package handlers
 
 import (
+	"crypto/subtle"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
 	"ecommerce/notification-service/service"
+	"ecommerce/shared/auth"
+	"ecommerce/shared/binding"
 	"ecommerce/shared/models"
+	"ecommerce/shared/pagination"
 )
 
+// EventMetrics exposes counters from the event consumer
+type EventMetrics interface {
+	UnhandledCount() uint64
+}
+
 type NotificationHandler struct {
 	service *service.NotificationService
+	events  EventMetrics
+	paging  pagination.Limits
 	logger  *zap.Logger
 }
 
-func NewNotificationHandler(svc *service.NotificationService, log *zap.Logger) *NotificationHandler {
+func NewNotificationHandler(svc *service.NotificationService, events EventMetrics, paging pagination.Limits, log *zap.Logger) *NotificationHandler {
 	return &NotificationHandler{
 		service: svc,
+		events:  events,
+		paging:  paging,
 		logger:  log,
 	}
 }
 
 func (h *NotificationHandler) GetUserNotifications(c *gin.Context) {
 	userID := c.Param("user_id")
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	page := h.paging.FromQuery(c)
 
-	offset := (page - 1) * pageSize
-	notifications, err := h.service.GetUserNotifications(c.Request.Context(), userID, pageSize, offset)
+	notifications, err := h.service.GetUserNotifications(c.Request.Context(), userID, page.Size, page.Offset())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -62,6 +72,122 @@ func (h *NotificationHandler) MarkAsRead(c *gin.Context) {
 	})
 }
 
+// MarkAllAsRead clears a user's unread notifications in one call
+// PUT /api/v1/notifications/user/:user_id/read-all
+func (h *NotificationHandler) MarkAllAsRead(c *gin.Context) {
+	updated, err := h.service.MarkAllAsRead(c.Request.Context(), c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Notifications marked as read",
+		Data:    map[string]interface{}{"updated": updated},
+	})
+}
+
+// GetPreferences returns the current user's notification preferences
+// GET /api/v1/notifications/preferences
+func (h *NotificationHandler) GetPreferences(c *gin.Context) {
+	prefs, err := h.service.GetPreferences(c.Request.Context(), c.GetString(auth.ContextUserID))
+	if err != nil {
+		h.logger.Error("Failed to load preferences", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to load preferences",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    prefs,
+	})
+}
+
+// UpdatePreferences opts the current user in or out of notification channels
+// and promotional messages
+// PUT /api/v1/notifications/preferences
+func (h *NotificationHandler) UpdatePreferences(c *gin.Context) {
+	var req service.PreferencesUpdate
+	if !binding.BindJSON(c, &req) {
+		return
+	}
+
+	prefs, err := h.service.UpdatePreferences(c.Request.Context(), c.GetString(auth.ContextUserID), req)
+	if err != nil {
+		h.logger.Error("Failed to update preferences", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to update preferences",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Preferences updated",
+		Data:    prefs,
+	})
+}
+
+// UpdateDeliveryStatus records a delivery callback from the email provider
+// POST /api/v1/notifications/:id/delivery-status
+func (h *NotificationHandler) UpdateDeliveryStatus(c *gin.Context) {
+	var req struct {
+		Status            string `json:"status" binding:"required"`
+		ProviderMessageID string `json:"provider_message_id"`
+	}
+
+	if !binding.BindJSON(c, &req) {
+		return
+	}
+
+	err := h.service.UpdateDeliveryStatus(c.Request.Context(), c.Param("id"), req.Status, req.ProviderMessageID)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		switch err {
+		case service.ErrInvalidDeliveryStatus:
+			statusCode = http.StatusBadRequest
+		case service.ErrNotificationNotFound:
+			statusCode = http.StatusNotFound
+		default:
+			h.logger.Error("Failed to update delivery status", zap.Error(err))
+		}
+		c.JSON(statusCode, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Delivery status updated",
+	})
+}
+
+// RequireWebhookSecret rejects provider callbacks without the shared secret in
+// X-Webhook-Secret; every request is rejected while no secret is configured
+func RequireWebhookSecret(secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provided := c.GetHeader("X-Webhook-Secret")
+		if secret == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(secret)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, models.APIResponse{
+				Success: false,
+				Error:   "Invalid webhook secret",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
 func (h *NotificationHandler) HealthCheck(c *gin.Context) {
 	response := models.HealthCheckResponse{
 		Status:    "healthy",
@@ -78,9 +204,30 @@ func (h *NotificationHandler) HealthCheck(c *gin.Context) {
 	}
 
 	response.Checks["database"] = "connected"
+
+	// Redis only backs caches and shared flags, so losing it degrades the
+	// service without taking it out of rotation
+	if err := h.service.CacheHealthCheck(c.Request.Context()); err != nil {
+		response.Status = "degraded"
+		response.Checks["redis"] = "disconnected"
+	} else {
+		response.Checks["redis"] = "connected"
+	}
+
 	c.JSON(http.StatusOK, response)
 }
 
 func (h *NotificationHandler) ReadinessCheck(c *gin.Context) {
 	h.HealthCheck(c)
 }
+
+// GetEventMetrics reports consumer counters, e.g. events with no registered handler
+// GET /metrics/events
+func (h *NotificationHandler) GetEventMetrics(c *gin.Context) {
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]uint64{
+			"unhandled_events": h.events.UnhandledCount(),
+		},
+	})
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"ecommerce/notification-service/service"
+	"ecommerce/shared/binding"
+	"ecommerce/shared/models"
+)
+
+// templateRequest is the editable part of a notification template
+type templateRequest struct {
+	Name            string `json:"name" binding:"required"`
+	Channel         string `json:"channel" binding:"required"`
+	Locale          string `json:"locale"` // Defaults to "en"
+	SubjectTemplate string `json:"subject_template"`
+	BodyTemplate    string `json:"body_template" binding:"required"`
+	Active          *bool  `json:"active"` // Defaults to true
+}
+
+func (r templateRequest) toTemplate() *models.NotificationTemplate {
+	active := true
+	if r.Active != nil {
+		active = *r.Active
+	}
+	return &models.NotificationTemplate{
+		Name:            r.Name,
+		Channel:         r.Channel,
+		Locale:          r.Locale,
+		SubjectTemplate: r.SubjectTemplate,
+		BodyTemplate:    r.BodyTemplate,
+		Active:          active,
+	}
+}
+
+// templateErrorStatus maps template errors to HTTP status codes
+func (h *NotificationHandler) templateErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidTemplate):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrTemplateNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrDuplicateTemplate):
+		return http.StatusConflict
+	}
+	h.logger.Error("Notification template request failed", zap.Error(err))
+	return http.StatusInternalServerError
+}
+
+// ListTemplates returns every notification template
+// GET /api/v1/admin/notification-templates
+func (h *NotificationHandler) ListTemplates(c *gin.Context) {
+	templates, err := h.service.ListTemplates(c.Request.Context())
+	if err != nil {
+		c.JSON(h.templateErrorStatus(err), models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    templates,
+	})
+}
+
+// GetTemplate returns one notification template
+// GET /api/v1/admin/notification-templates/:id
+func (h *NotificationHandler) GetTemplate(c *gin.Context) {
+	template, err := h.service.GetTemplate(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(h.templateErrorStatus(err), models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    template,
+	})
+}
+
+// PreviewTemplate renders a template with sample data without saving or sending it
+// POST /api/v1/admin/notification-templates/preview
+// {"subject_template": "...", "body_template": "...", "data": {"order_id": "...", "total_price": 10, "tracking_number": "..."}}
+func (h *NotificationHandler) PreviewTemplate(c *gin.Context) {
+	var req struct {
+		SubjectTemplate string                `json:"subject_template"`
+		BodyTemplate    string                `json:"body_template" binding:"required"`
+		Data            *service.TemplateData `json:"data"` // Optional; sample values are used if omitted
+	}
+	if !binding.BindJSON(c, &req) {
+		return
+	}
+
+	preview, err := h.service.PreviewTemplate(req.SubjectTemplate, req.BodyTemplate, req.Data)
+	if err != nil {
+		c.JSON(h.templateErrorStatus(err), models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    preview,
+	})
+}
+
+// CreateTemplate adds a notification template
+// POST /api/v1/admin/notification-templates
+func (h *NotificationHandler) CreateTemplate(c *gin.Context) {
+	var req templateRequest
+	if !binding.BindJSON(c, &req) {
+		return
+	}
+
+	template := req.toTemplate()
+	if err := h.service.CreateTemplate(c.Request.Context(), template); err != nil {
+		c.JSON(h.templateErrorStatus(err), models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Message: "Template created successfully",
+		Data:    template,
+	})
+}
+
+// UpdateTemplate replaces a notification template's copy and settings
+// PUT /api/v1/admin/notification-templates/:id
+func (h *NotificationHandler) UpdateTemplate(c *gin.Context) {
+	var req templateRequest
+	if !binding.BindJSON(c, &req) {
+		return
+	}
+
+	template := req.toTemplate()
+	template.ID = c.Param("id")
+	if err := h.service.UpdateTemplate(c.Request.Context(), template); err != nil {
+		c.JSON(h.templateErrorStatus(err), models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Template updated successfully",
+		Data:    template,
+	})
+}
+
+// DeleteTemplate removes a notification template
+// DELETE /api/v1/admin/notification-templates/:id
+func (h *NotificationHandler) DeleteTemplate(c *gin.Context) {
+	if err := h.service.DeleteTemplate(c.Request.Context(), c.Param("id")); err != nil {
+		c.JSON(h.templateErrorStatus(err), models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Template deleted successfully",
+	})
+}
@@ -16,8 +16,14 @@ import (
 	"ecommerce/notification-service/messaging"
 	"ecommerce/notification-service/repository"
 	"ecommerce/notification-service/service"
+	"ecommerce/shared/auth"
+	"ecommerce/shared/cache"
 	"ecommerce/shared/config"
+	"ecommerce/shared/database"
 	"ecommerce/shared/logger"
+	sharedmessaging "ecommerce/shared/messaging"
+	"ecommerce/shared/middleware"
+	"ecommerce/shared/pagination"
 )
 
 func main() {
@@ -31,13 +37,22 @@ func main() {
 	}
 	defer log.Sync()
 
+	// Refuse to start in production with insecure or missing settings
+	warnings, err := cfg.Validate()
+	if err != nil {
+		log.Fatal("Invalid configuration", zap.Error(err))
+	}
+	for _, warning := range warnings {
+		log.Warn("Configuration warning", zap.String("problem", warning))
+	}
+
 	log.Info("Starting Notification Service",
 		zap.String("environment", cfg.Environment),
 		zap.String("port", cfg.Port),
 	)
 
 	// 3. Initialize database
-	db, err := repository.NewPostgresDB(cfg.GetDatabaseURL())
+	db, err := repository.NewPostgresDB(cfg.GetDatabaseURL(), cfg.DBConnectTimeout)
 	if err != nil {
 		log.Fatal("Failed to connect to database", zap.Error(err))
 	}
@@ -50,12 +65,59 @@ func main() {
 		log.Fatal("Failed to run migrations", zap.Error(err))
 	}
 
-	// 5. Initialize repository and service
+	if cfg.CheckIndexes {
+		database.WarnMissingIndexes(db, repository.ExpectedIndexes, log.Logger)
+	}
+
+	// Redis holds the shared maintenance mode flag, the token denylist and cached templates
+	redisClient := repository.NewRedisClient(cfg.GetRedisURL(), cfg.RedisPassword, cfg.RedisDB)
+	defer redisClient.Close()
+
+	// The token denylist and maintenance flag live on REDIS_SHARED_DB so every
+	// service sees them, whatever its own REDIS_DB
+	sharedRedis := redisClient
+	if cfg.RedisSharedDB != cfg.RedisDB {
+		sharedRedis = repository.NewRedisClient(cfg.GetRedisURL(), cfg.RedisPassword, cfg.RedisSharedDB)
+		defer sharedRedis.Close()
+	}
+	keys := cache.NewKeyer(cfg.RedisKeyPrefix)
+
+	// 5. Initialize repositories and service
 	notificationRepo := repository.NewNotificationRepository(db)
-	notificationService := service.NewNotificationService(notificationRepo, log.Logger)
+	templateRepo := repository.NewTemplateRepository(db, redisClient, keys)
+	// Email is only logged in development, or when no SMTP server is configured
+	var emailSender service.EmailSender
+	if cfg.IsDevelopment() || cfg.SMTPHost == "" {
+		emailSender = service.NewNoopEmailSender(log.Logger)
+	} else {
+		emailSender, err = service.NewSMTPSender(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPFrom)
+		if err != nil {
+			log.Fatal("Invalid SMTP configuration", zap.Error(err))
+		}
+	}
+	userClient := service.NewUserClient(cfg.UserServiceURL, 10*time.Second)
+	retryPolicy := service.RetryPolicy{
+		MaxAttempts: cfg.NotificationMaxAttempts,
+		BaseDelay:   cfg.NotificationRetryBaseDelay,
+		MaxDelay:    time.Hour,
+	}
+	notificationService := service.NewNotificationService(notificationRepo, templateRepo, emailSender, userClient, retryPolicy, log.Logger)
+
+	// Re-send failed notifications in the background
+	workerCtx, stopWorker := context.WithCancel(context.Background())
+	defer stopWorker()
+	retryWorker := service.NewRetryWorker(notificationService, cfg.NotificationRetryInterval, cfg.NotificationRetryWindow, log.Logger)
+	go retryWorker.Run(workerCtx)
 
 	// 6. Initialize RabbitMQ consumer
-	consumer, err := messaging.NewRabbitMQConsumer(cfg.RabbitMQURL, notificationService, log.Logger)
+	// Each queue gets its own workers, so slow email sending can't stall fulfillment,
+	// and fulfillment is only routed the confirmed orders it acts on
+	queues := []messaging.QueueConfig{
+		{Name: cfg.RabbitMQQueue, Workers: cfg.RabbitMQWorkers, MaxDeliveries: cfg.RabbitMQMaxDeliveries, Handlers: []string{messaging.HandlerNotification}},
+		{Name: cfg.RabbitMQFulfillmentQueue, Workers: 1, MaxDeliveries: cfg.RabbitMQMaxDeliveries, Handlers: []string{messaging.HandlerFulfillment}, BindingKeys: []string{sharedmessaging.EventOrderConfirmed}},
+		{Name: cfg.RabbitMQUserQueue, Workers: 1, MaxDeliveries: cfg.RabbitMQMaxDeliveries, Handlers: []string{messaging.HandlerNotification}, Exchange: sharedmessaging.UsersExchange, BindingKeys: []string{sharedmessaging.UsersBindingAll}},
+	}
+	consumer, err := messaging.NewRabbitMQConsumer(cfg.RabbitMQURL, queues, notificationService, log.Logger)
 	if err != nil {
 		log.Fatal("Failed to connect to RabbitMQ", zap.Error(err))
 	}
@@ -65,21 +127,38 @@ func main() {
 
 	// 7. Start consuming messages in background
 	go func() {
-		log.Info("Starting to consume order events...")
+		log.Info("Starting to consume order and user events...")
 		if err := consumer.StartConsuming(); err != nil {
 			log.Error("Consumer error", zap.Error(err))
 		}
 	}()
 
 	// 8. Set up HTTP server for health checks
-	notificationHandler := handlers.NewNotificationHandler(notificationService, log.Logger)
+	paging := pagination.Limits{DefaultPageSize: cfg.DefaultPageSize, MaxPageSize: cfg.MaxPageSize}
+	notificationHandler := handlers.NewNotificationHandler(notificationService, consumer, paging, log.Logger)
 
 	if cfg.IsProduction() {
 		gin.SetMode(gin.ReleaseMode)
 	}
-	router := gin.Default()
+	// gin.New instead of gin.Default: requests are logged as structured JSON and
+	// panics answered with the usual APIResponse body
+	router := gin.New()
+	router.Use(middleware.RequestID(), middleware.AccessLog(log.Logger), middleware.Recovery(log.Logger))
+
+	// Cap request bodies so one oversized payload can't exhaust memory
+	router.Use(middleware.BodyLimit(int64(cfg.MaxBodyBytes), nil))
+
+	// Block writes while maintenance mode is on (toggled via the admin API)
+	maintenance := middleware.NewMaintenanceMode(sharedRedis, keys, cfg.MaintenanceMode)
+	router.Use(maintenance.Middleware())
+
+	jwtKeys, err := auth.NewKeyring(cfg.JWTKeyID, cfg.JWTSecret, cfg.JWTPreviousSecrets)
+	if err != nil {
+		log.Fatal("Invalid JWT configuration", zap.Error(err))
+	}
+	requireAuth := auth.Middleware(jwtKeys, cfg.JWTAlgorithms, auth.NewDenylist(sharedRedis, keys))
 
-	setupRoutes(router, notificationHandler)
+	setupRoutes(router, notificationHandler, cfg.NotificationWebhookSecret, requireAuth)
 
 	srv := &http.Server{
 		Addr:    ":" + cfg.Port,
@@ -100,11 +179,12 @@ func main() {
 
 	log.Info("Shutting down service...")
 
-	// Stop consumer
+	// Stop consumer and retry worker
 	consumer.Close()
+	stopWorker()
 
 	// Shutdown HTTP server
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
@@ -114,10 +194,11 @@ func main() {
 	log.Info("Service exited")
 }
 
-func setupRoutes(router *gin.Engine, handler *handlers.NotificationHandler) {
+func setupRoutes(router *gin.Engine, handler *handlers.NotificationHandler, webhookSecret string, requireAuth gin.HandlerFunc) {
 	// Health checks only - this service primarily consumes from RabbitMQ
 	router.GET("/health", handler.HealthCheck)
 	router.GET("/ready", handler.ReadinessCheck)
+	router.GET("/metrics/events", handler.GetEventMetrics)
 
 	// Optional API endpoints for viewing notifications
 	v1 := router.Group("/api/v1")
@@ -126,6 +207,27 @@ func setupRoutes(router *gin.Engine, handler *handlers.NotificationHandler) {
 		{
 			notifications.GET("/user/:user_id", handler.GetUserNotifications)
 			notifications.PUT("/:id/read", handler.MarkAsRead)
+			notifications.PUT("/user/:user_id/read-all", handler.MarkAllAsRead)
+
+			// The current user's opt-outs
+			notifications.GET("/preferences", requireAuth, handler.GetPreferences)
+			notifications.PUT("/preferences", requireAuth, handler.UpdatePreferences)
+
+			// Email provider callback, authenticated by shared secret
+			notifications.POST("/:id/delivery-status",
+				handlers.RequireWebhookSecret(webhookSecret), handler.UpdateDeliveryStatus)
+		}
+
+		// Template management, so copy can change without a deploy
+		admin := v1.Group("/admin")
+		admin.Use(requireAuth, auth.RequirePermission(auth.PermNotificationManage))
+		{
+			admin.GET("/notification-templates", handler.ListTemplates)
+			admin.POST("/notification-templates", handler.CreateTemplate)
+			admin.POST("/notification-templates/preview", handler.PreviewTemplate)
+			admin.GET("/notification-templates/:id", handler.GetTemplate)
+			admin.PUT("/notification-templates/:id", handler.UpdateTemplate)
+			admin.DELETE("/notification-templates/:id", handler.DeleteTemplate)
 		}
 	}
 }
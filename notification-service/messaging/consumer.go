@@ -1,184 +1,706 @@
 package messaging
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/streadway/amqp"
 	"go.uber.org/zap"
 
 	"ecommerce/notification-service/service"
+	"ecommerce/shared/messaging"
 )
 
 // OrderEvent represents an order event from the queue
 type OrderEvent struct {
-	OrderID    string    `json:"order_id"`
-	UserID     string    `json:"user_id"`
-	TotalPrice float64   `json:"total_price"`
-	Status     string    `json:"status"`
-	CreatedAt  time.Time `json:"created_at"`
+	EventID        string    `json:"event_id,omitempty"` // Set for outbox events; stable across redeliveries
+	Type           string    `json:"type"`
+	OrderID        string    `json:"order_id"`
+	UserID         string    `json:"user_id"`
+	TotalPrice     float64   `json:"total_price"`
+	Status         string    `json:"status"`
+	TrackingNumber string    `json:"tracking_number,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	Replay         bool      `json:"replay,omitempty"` // Re-emitted by an admin; may duplicate an earlier event
+
+	// Items is missing from events published before it existed, so
+	// handlers must cope with it being empty
+	Items []OrderItemEvent `json:"items,omitempty"`
+}
+
+// OrderItemEvent is one line of an order
+type OrderItemEvent struct {
+	ProductID string  `json:"product_id"`
+	Quantity  int     `json:"quantity"`
+	Price     float64 `json:"price"`
+}
+
+// UserEvent represents a user account event from the queue
+type UserEvent struct {
+	Type      string    `json:"type"`
+	UserID    string    `json:"user_id"`
+	Email     string    `json:"email"`
+	ResetURL  string    `json:"reset_url,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ErrMalformedEvent marks a body that can't be decoded; such messages are
+// rejected without requeue since retrying can never succeed
+var ErrMalformedEvent = errors.New("malformed event")
+
+// HandlerFunc processes the raw body of an event
+type HandlerFunc func(body []byte) error
+
+// Names of the default handlers, used to wire them to queues
+const (
+	HandlerNotification = "notification"
+	HandlerFulfillment  = "fulfillment"
+)
+
+// namedHandler is a handler registered under a name, so its outcome is
+// tracked separately from other handlers of the same event type
+type namedHandler struct {
+	name    string
+	handler HandlerFunc
+}
+
+// eventEnvelope holds the fields needed to route an event to its handler
+type eventEnvelope struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Replay bool   `json:"replay"`
+}
+
+// eventType returns the routing type, falling back to the order status
+// for events published before the type field existed
+func (e eventEnvelope) eventType() string {
+	if e.Type != "" {
+		return e.Type
+	}
+	if e.Status != "" {
+		return "order." + e.Status
+	}
+	return ""
+}
+
+// UserHandler adapts a typed user event handler to a HandlerFunc
+func UserHandler(handler func(event UserEvent) error) HandlerFunc {
+	return func(body []byte) error {
+		var event UserEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			return fmt.Errorf("%w: failed to decode user event: %v", ErrMalformedEvent, err)
+		}
+		return handler(event)
+	}
 }
 
+// OrderHandler adapts a typed order event handler to a HandlerFunc
+func OrderHandler(handler func(event OrderEvent) error) HandlerFunc {
+	return func(body []byte) error {
+		var event OrderEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			return fmt.Errorf("%w: failed to decode order event: %v", ErrMalformedEvent, err)
+		}
+		return handler(event)
+	}
+}
+
+// QueueConfig binds one durable queue to an exchange. Each queue has its own
+// channel and worker pool, so a backlog on one (e.g. slow email sending)
+// doesn't stall the others
+type QueueConfig struct {
+	Name          string
+	Exchange      string   // Exchange to bind to; empty means the orders exchange
+	BindingKeys   []string // Event types routed to this queue, e.g. "order.confirmed"; empty binds "order.*" on the orders exchange
+	Workers       int      // Deliveries processed concurrently; at least 1
+	MaxDeliveries int      // Attempts before a failing event is dead-lettered; at least 1
+	Handlers      []string // Names of the registered handlers this queue runs; empty runs all
+}
+
+// retryCountHeader counts how many times an event has been requeued
+// Classic queues don't count redeliveries, so failed events are republished
+// to the queue with this header incremented instead of being nacked with requeue
+const retryCountHeader = "x-retry-count"
+
+// queueConsumer is the runtime state of one configured queue
+type queueConsumer struct {
+	config  QueueConfig
+	channel *amqp.Channel
+
+	// completed records which handlers already succeeded for a requeued
+	// message (keyed by body digest), so a retry only re-runs the ones that failed
+	mu        sync.Mutex
+	completed map[string]map[string]bool
+}
+
+// runs reports whether this queue is wired to the named handler
+func (q *queueConsumer) runs(name string) bool {
+	if len(q.config.Handlers) == 0 {
+		return true
+	}
+	for _, h := range q.config.Handlers {
+		if h == name {
+			return true
+		}
+	}
+	return false
+}
+
+// errConsumerClosed stops a reconnect that raced with Close
+var errConsumerClosed = errors.New("consumer closed")
+
 // RabbitMQConsumer consumes messages from RabbitMQ
+// If the connection drops, StartConsuming redials with backoff, re-declares
+// the queues and resumes consuming
 type RabbitMQConsumer struct {
-	conn                *amqp.Connection
-	channel             *amqp.Channel
+	url    string
+	queues []*queueConsumer
+
+	mu         sync.Mutex // Guards conn, connClosed, closed and the queues' channels
+	conn       *amqp.Connection
+	connClosed chan *amqp.Error
+	closed     bool
+	done       chan struct{} // Closed by Close to stop reconnecting
+
 	notificationService *service.NotificationService
 	logger              *zap.Logger
+
+	handlers  map[string][]namedHandler
+	unhandled uint64 // deliveries acked because no handler was registered (once per queue)
+}
+
+// NewRabbitMQConsumer creates a RabbitMQ consumer with one durable queue per
+// QueueConfig, each bound to its exchange for its event types
+func NewRabbitMQConsumer(url string, queues []QueueConfig, notificationService *service.NotificationService, logger *zap.Logger) (*RabbitMQConsumer, error) {
+	if len(queues) == 0 {
+		return nil, errors.New("at least one queue must be configured")
+	}
+
+	consumer := &RabbitMQConsumer{
+		url:                 url,
+		done:                make(chan struct{}),
+		notificationService: notificationService,
+		logger:              logger,
+		handlers:            make(map[string][]namedHandler),
+	}
+
+	for _, config := range queues {
+		if config.Workers < 1 {
+			config.Workers = 1
+		}
+		if config.MaxDeliveries < 1 {
+			config.MaxDeliveries = 1
+		}
+		if config.Exchange == "" {
+			config.Exchange = messaging.OrdersExchange
+		}
+		if len(config.BindingKeys) == 0 {
+			if config.Exchange != messaging.OrdersExchange {
+				return nil, fmt.Errorf("queue %s: binding keys are required for exchange %s", config.Name, config.Exchange)
+			}
+			config.BindingKeys = []string{messaging.OrdersBindingAll}
+		}
+
+		consumer.queues = append(consumer.queues, &queueConsumer{
+			config:    config,
+			completed: make(map[string]map[string]bool),
+		})
+	}
+
+	if err := consumer.connect(); err != nil {
+		return nil, err
+	}
+
+	consumer.registerDefaultHandlers()
+
+	return consumer, nil
 }
 
-// NewRabbitMQConsumer creates a new RabbitMQ consumer
-func NewRabbitMQConsumer(url string, notificationService *service.NotificationService, logger *zap.Logger) (*RabbitMQConsumer, error) {
+// connect dials RabbitMQ and declares every configured queue on its own channel
+func (c *RabbitMQConsumer) connect() error {
 	// Connect to RabbitMQ
-	conn, err := amqp.Dial(url)
+	conn, err := amqp.Dial(c.url)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		conn.Close()
+		return errConsumerClosed
 	}
 
+	for _, q := range c.queues {
+		channel, err := declareQueue(conn, q.config)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		q.channel = channel
+
+		c.logger.Info("RabbitMQ queue bound",
+			zap.String("queue", q.config.Name),
+			zap.String("exchange", q.config.Exchange),
+			zap.Int("workers", q.config.Workers),
+			zap.Strings("bindings", q.config.BindingKeys),
+			zap.Strings("handlers", q.config.Handlers),
+		)
+	}
+	c.conn = conn
+	c.connClosed = conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	return nil
+}
+
+// declareQueue opens a channel for one queue and declares the exchange, queue and bindings
+func declareQueue(conn *amqp.Connection, config QueueConfig) (*amqp.Channel, error) {
 	// Create channel
 	channel, err := conn.Channel()
 	if err != nil {
-		conn.Close()
 		return nil, fmt.Errorf("failed to open channel: %w", err)
 	}
 
 	// Declare exchange (must match publisher's exchange)
 	err = channel.ExchangeDeclare(
-		"orders", // name
-		"fanout", // type
-		true,     // durable
-		false,    // auto-deleted
-		false,    // internal
-		false,    // no-wait
-		nil,      // arguments
+		config.Exchange,               // name
+		exchangeType(config.Exchange), // type
+		true,                          // durable
+		false,                         // auto-deleted
+		false,                         // internal
+		false,                         // no-wait
+		nil,                           // arguments
 	)
 	if err != nil {
 		channel.Close()
-		conn.Close()
 		return nil, fmt.Errorf("failed to declare exchange: %w", err)
 	}
 
-	// Declare queue for this service
-	queue, err := channel.QueueDeclare(
-		"notifications", // name
-		true,            // durable (survives broker restart)
-		false,           // delete when unused
-		false,           // exclusive
-		false,           // no-wait
-		nil,             // arguments
-	)
-	if err != nil {
+	if err := declareDeadLetterQueue(channel); err != nil {
 		channel.Close()
-		conn.Close()
-		return nil, fmt.Errorf("failed to declare queue: %w", err)
+		return nil, err
 	}
 
-	// Bind queue to exchange
-	err = channel.QueueBind(
-		queue.Name, // queue name
-		"",         // routing key (ignored for fanout)
-		"orders",   // exchange
-		false,      // no-wait
-		nil,        // arguments
+	// Declare queue; rejected messages go to the dead-letter exchange
+	// A queue declared before dead-lettering existed must be deleted first,
+	// as RabbitMQ refuses to change the arguments of an existing queue
+	queue, err := channel.QueueDeclare(
+		config.Name, // name
+		true,        // durable (survives broker restart)
+		false,       // delete when unused
+		false,       // exclusive
+		false,       // no-wait
+		amqp.Table{"x-dead-letter-exchange": messaging.DeadLetterExchange}, // arguments
 	)
 	if err != nil {
 		channel.Close()
-		conn.Close()
-		return nil, fmt.Errorf("failed to bind queue: %w", err)
+		return nil, fmt.Errorf("failed to declare queue %s: %w", config.Name, err)
 	}
 
-	// Set QoS - process one message at a time
+	// Bind queue to exchange, once per event type it wants
+	for _, key := range config.BindingKeys {
+		err = channel.QueueBind(
+			queue.Name,      // queue name
+			key,             // binding key, e.g. "order.*"
+			config.Exchange, // exchange
+			false,           // no-wait
+			nil,             // arguments
+		)
+		if err != nil {
+			channel.Close()
+			return nil, fmt.Errorf("failed to bind queue %s to %s: %w", config.Name, key, err)
+		}
+	}
+
+	// Set QoS - one unacked message per worker
 	err = channel.Qos(
-		1,     // prefetch count
-		0,     // prefetch size
-		false, // global
+		config.Workers, // prefetch count
+		0,              // prefetch size
+		false,          // global
 	)
 	if err != nil {
 		channel.Close()
-		conn.Close()
 		return nil, fmt.Errorf("failed to set QoS: %w", err)
 	}
 
-	logger.Info("RabbitMQ consumer initialized", zap.String("queue", queue.Name))
+	return channel, nil
+}
 
-	return &RabbitMQConsumer{
-		conn:                conn,
-		channel:             channel,
-		notificationService: notificationService,
-		logger:              logger,
-	}, nil
+// exchangeType returns the type an exchange is declared with by its publisher
+func exchangeType(exchange string) string {
+	switch exchange {
+	case messaging.UsersExchange:
+		return messaging.UsersExchangeType
+	default:
+		return messaging.OrdersExchangeType
+	}
 }
 
-// StartConsuming starts consuming messages from the queue
-func (c *RabbitMQConsumer) StartConsuming() error {
-	// Register consumer
-	messages, err := c.channel.Consume(
-		"notifications",        // queue
-		"notification-service", // consumer tag
-		false,                  // auto-ack (we'll manually ack after processing)
-		false,                  // exclusive
-		false,                  // no-local
-		false,                  // no-wait
-		nil,                    // args
+// declareDeadLetterQueue declares the dead-letter exchange and the queue
+// that holds its messages
+func declareDeadLetterQueue(channel *amqp.Channel) error {
+	err := channel.ExchangeDeclare(
+		messaging.DeadLetterExchange,     // name
+		messaging.DeadLetterExchangeType, // type
+		true,                             // durable
+		false,                            // auto-deleted
+		false,                            // internal
+		false,                            // no-wait
+		nil,                              // arguments
 	)
 	if err != nil {
-		return fmt.Errorf("failed to register consumer: %w", err)
+		return fmt.Errorf("failed to declare dead-letter exchange: %w", err)
 	}
 
-	c.logger.Info("Waiting for messages...")
+	queue, err := channel.QueueDeclare(
+		messaging.DeadLetterQueue, // name
+		true,                      // durable
+		false,                     // delete when unused
+		false,                     // exclusive
+		false,                     // no-wait
+		nil,                       // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare dead-letter queue: %w", err)
+	}
 
-	// Process messages
-	for msg := range messages {
-		c.processMessage(msg)
+	err = channel.QueueBind(
+		queue.Name,                   // queue name
+		"",                           // routing key (ignored for fanout)
+		messaging.DeadLetterExchange, // exchange
+		false,                        // no-wait
+		nil,                          // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to bind dead-letter queue: %w", err)
 	}
 
 	return nil
 }
 
-// processMessage processes a single message
-func (c *RabbitMQConsumer) processMessage(msg amqp.Delivery) {
+// RegisterHandler adds a named handler for an event type (e.g. "order.confirmed")
+// Several handlers may share an event type; each runs independently and a
+// failure in one doesn't undo or repeat the others' work
+// Must be called before StartConsuming
+func (c *RabbitMQConsumer) RegisterHandler(eventType, name string, handler HandlerFunc) {
+	c.handlers[eventType] = append(c.handlers[eventType], namedHandler{name: name, handler: handler})
+}
+
+// UnhandledCount returns how many events were acked without a registered handler
+func (c *RabbitMQConsumer) UnhandledCount() uint64 {
+	return atomic.LoadUint64(&c.unhandled)
+}
+
+// registerDefaultHandlers wires the event types this service notifies on
+func (c *RabbitMQConsumer) registerDefaultHandlers() {
+	c.RegisterHandler(messaging.EventOrderConfirmed, HandlerNotification, OrderHandler(func(event OrderEvent) error {
+		return c.notificationService.SendOrderConfirmation(event.UserID, event.OrderID, event.TotalPrice)
+	}))
+	c.RegisterHandler(messaging.EventOrderCancelled, HandlerNotification, OrderHandler(func(event OrderEvent) error {
+		return c.notificationService.SendOrderCancellation(event.UserID, event.OrderID)
+	}))
+	c.RegisterHandler(messaging.EventOrderShipped, HandlerNotification, OrderHandler(func(event OrderEvent) error {
+		return c.notificationService.SendShipmentNotification(event.UserID, event.OrderID, event.TrackingNumber)
+	}))
+	c.RegisterHandler(messaging.EventOrderDelivered, HandlerNotification, OrderHandler(func(event OrderEvent) error {
+		return c.notificationService.SendDeliveryNotification(event.UserID, event.OrderID)
+	}))
+
+	c.RegisterHandler(messaging.EventUserPasswordReset, HandlerNotification, UserHandler(func(event UserEvent) error {
+		return c.notificationService.SendPasswordReset(event.UserID, event.Email, event.ResetURL, event.ExpiresAt)
+	}))
+
+	// Fulfillment hook: a no-op until there is a fulfillment service (e.g. to
+	// reserve a picking slot); it runs alongside the confirmation email
+	c.RegisterHandler(messaging.EventOrderConfirmed, HandlerFulfillment, OrderHandler(func(event OrderEvent) error {
+		c.logger.Debug("Fulfillment hook",
+			zap.String("order_id", event.OrderID),
+			zap.Int("items", len(event.Items)),
+		)
+		return nil
+	}))
+}
+
+// StartConsuming consumes every configured queue with its own worker pool
+// It blocks until Close is called; if the connection drops in the meantime
+// it reconnects and resumes consuming
+func (c *RabbitMQConsumer) StartConsuming() error {
+	for {
+		err := c.consume()
+		if c.isClosed() {
+			return nil
+		}
+		if err != nil {
+			c.logger.Error("Consumer failed; reconnecting", zap.Error(err))
+		}
+
+		if !c.reconnect() {
+			return nil
+		}
+	}
+}
+
+// consume registers a consumer on every queue and processes deliveries
+// until the connection or one of the channels closes
+func (c *RabbitMQConsumer) consume() error {
+	var wg sync.WaitGroup
+
+	for _, q := range c.queues {
+		// Register consumer
+		messages, err := q.channel.Consume(
+			q.config.Name,                         // queue
+			"notification-service."+q.config.Name, // consumer tag
+			false,                                 // auto-ack (we'll manually ack after processing)
+			false,                                 // exclusive
+			false,                                 // no-local
+			false,                                 // no-wait
+			nil,                                   // args
+		)
+		if err != nil {
+			// Stop the queues already consuming so they reconnect together
+			c.conn.Close()
+			wg.Wait()
+			return fmt.Errorf("failed to register consumer for %s: %w", q.config.Name, err)
+		}
+
+		for i := 0; i < q.config.Workers; i++ {
+			wg.Add(1)
+			go func(q *queueConsumer) {
+				defer wg.Done()
+				// Process messages
+				for msg := range messages {
+					c.processMessage(q, msg)
+				}
+			}(q)
+		}
+	}
+
+	c.logger.Info("Waiting for messages...")
+	wg.Wait()
+
+	return nil
+}
+
+// reconnect closes what is left of the lost connection and redials with
+// exponential backoff; it returns false if Close is called first
+func (c *RabbitMQConsumer) reconnect() bool {
+	c.mu.Lock()
+	select {
+	case reason := <-c.connClosed:
+		c.logger.Warn("RabbitMQ connection lost; reconnecting", zap.Error(reason))
+	default:
+		// Only a channel closed; drop the connection so every queue starts afresh
+		c.logger.Warn("RabbitMQ channel closed; reconnecting")
+	}
+	c.conn.Close()
+	c.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-c.done:
+			return false
+		case <-time.After(messaging.ReconnectDelay(attempt)):
+		}
+
+		if err := c.connect(); err != nil {
+			if errors.Is(err, errConsumerClosed) {
+				return false
+			}
+			c.logger.Warn("Failed to reconnect to RabbitMQ", zap.Int("attempt", attempt+1), zap.Error(err))
+			continue
+		}
+
+		c.logger.Info("Reconnected to RabbitMQ", zap.Int("attempts", attempt+1))
+		return true
+	}
+}
+
+// isClosed reports whether Close has been called
+func (c *RabbitMQConsumer) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// processMessage processes a single message from queue q
+func (c *RabbitMQConsumer) processMessage(q *queueConsumer, msg amqp.Delivery) {
+	// The body is only logged at debug level: user events carry reset links
 	c.logger.Info("Received message",
-		zap.String("body", string(msg.Body)),
+		zap.String("queue", q.config.Name),
+		zap.String("routing_key", msg.RoutingKey),
 		zap.Time("timestamp", msg.Timestamp),
 	)
+	c.logger.Debug("Message body", zap.String("body", string(msg.Body)))
 
-	// Parse message
-	var event OrderEvent
-	if err := json.Unmarshal(msg.Body, &event); err != nil {
+	// Parse just enough of the message to route it
+	var envelope eventEnvelope
+	if err := json.Unmarshal(msg.Body, &envelope); err != nil {
 		c.logger.Error("Failed to parse message", zap.Error(err))
-		// Reject message (won't be requeued)
+		// Reject message (dead-lettered, not requeued)
 		msg.Nack(false, false)
 		return
 	}
+	eventType := envelope.eventType()
+	if envelope.Replay {
+		c.logger.Info("Processing replayed event", zap.String("event_type", eventType))
+	}
 
-	// Process event based on status
-	var err error
-	switch event.Status {
-	case "confirmed":
-		err = c.notificationService.SendOrderConfirmation(event.UserID, event.OrderID, event.TotalPrice)
-	case "cancelled":
-		err = c.notificationService.SendOrderCancellation(event.UserID, event.OrderID)
-	default:
-		c.logger.Warn("Unknown order status", zap.String("status", event.Status))
+	// Look up the handlers for this event type
+	registered, ok := c.handlers[eventType]
+	if !ok {
+		// Nothing to do for this type - ack so it doesn't pile up in the queue
+		count := atomic.AddUint64(&c.unhandled, 1)
+		c.logger.Warn("No handler registered for event type",
+			zap.String("event_type", eventType),
+			zap.Uint64("unhandled_total", count),
+		)
+		msg.Ack(false)
+		return
+	}
+
+	// A queue may be bound to more events than it handles; keep only the handlers wired to this one
+	var handlers []namedHandler
+	for _, h := range registered {
+		if q.runs(h.name) {
+			handlers = append(handlers, h)
+		}
+	}
+	if len(handlers) == 0 {
+		msg.Ack(false)
+		return
+	}
+
+	// Run each handler that hasn't already succeeded for this message
+	digest := messageDigest(msg.Body)
+	q.mu.Lock()
+	done := q.completed[digest]
+	q.mu.Unlock()
+	if done == nil {
+		done = make(map[string]bool)
+	}
+
+	malformed, retry := false, false
+	for _, h := range handlers {
+		if done[h.name] {
+			continue
+		}
+		if err := h.handler(msg.Body); err != nil {
+			c.logger.Error("Failed to process message",
+				zap.String("event_type", eventType),
+				zap.String("handler", h.name),
+				zap.Error(err),
+			)
+			if errors.Is(err, ErrMalformedEvent) {
+				malformed = true
+			} else {
+				retry = true
+			}
+			continue
+		}
+		done[h.name] = true
 	}
 
 	// Acknowledge or reject message
-	if err != nil {
-		c.logger.Error("Failed to process message", zap.Error(err))
-		// Nack with requeue - will retry later
-		msg.Nack(false, true)
-	} else {
-		c.logger.Info("Message processed successfully", zap.String("order_id", event.OrderID))
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	switch {
+	case malformed:
+		// Reject message (dead-lettered, not requeued)
+		delete(q.completed, digest)
+		msg.Nack(false, false)
+	case retry && retryCount(msg)+1 >= q.config.MaxDeliveries:
+		c.logger.Error("Event failed too many times; dead-lettering",
+			zap.String("event_type", eventType),
+			zap.Int("deliveries", retryCount(msg)+1),
+		)
+		delete(q.completed, digest)
+		msg.Nack(false, false)
+	case retry:
+		// Requeue - only the failed handlers run on redelivery
+		q.completed[digest] = done
+		c.requeue(q, msg)
+	default:
+		c.logger.Info("Message processed successfully", zap.String("event_type", eventType))
+		delete(q.completed, digest)
 		// Acknowledge message
 		msg.Ack(false)
 	}
 }
 
-// Close closes the RabbitMQ connection
+// retryCount reads how many times msg has already been requeued
+func retryCount(msg amqp.Delivery) int {
+	switch n := msg.Headers[retryCountHeader].(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	default:
+		return 0
+	}
+}
+
+// requeue republishes msg to the back of q with its retry count incremented,
+// then acks the original
+// If the republish fails, msg is nacked with requeue instead, which retries
+// it without counting the attempt
+func (c *RabbitMQConsumer) requeue(q *queueConsumer, msg amqp.Delivery) {
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[retryCountHeader] = int32(retryCount(msg) + 1)
+
+	err := q.channel.Publish(
+		"",            // default exchange routes straight to the queue
+		q.config.Name, // routing key
+		false,         // mandatory
+		false,         // immediate
+		amqp.Publishing{
+			Headers:      headers,
+			ContentType:  msg.ContentType,
+			DeliveryMode: msg.DeliveryMode,
+			MessageId:    msg.MessageId,
+			Timestamp:    msg.Timestamp,
+			Body:         msg.Body,
+		},
+	)
+	if err != nil {
+		c.logger.Error("Failed to requeue message", zap.Error(err))
+		msg.Nack(false, true)
+		return
+	}
+
+	msg.Ack(false)
+}
+
+// messageDigest identifies a message body across redeliveries
+func messageDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Close closes the RabbitMQ connection and stops reconnecting
 func (c *RabbitMQConsumer) Close() error {
-	if c.channel != nil {
-		c.channel.Close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+
+	for _, q := range c.queues {
+		if q.channel != nil {
+			q.channel.Close()
+		}
 	}
 	if c.conn != nil {
 		return c.conn.Close()
@@ -6,10 +6,15 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	_ "github.com/lib/pq"
+
+	"ecommerce/shared/database"
 )
 
-func NewPostgresDB(connStr string) (*sql.DB, error) {
+// NewPostgresDB opens a connection pool, retrying the initial ping for up to
+// connectTimeout (DB_CONNECT_TIMEOUT)
+func NewPostgresDB(connStr string, connectTimeout time.Duration) (*sql.DB, error) {
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -19,14 +24,46 @@ func NewPostgresDB(connStr string) (*sql.DB, error) {
 	db.SetMaxIdleConns(5)
 	db.SetConnMaxLifetime(5 * time.Minute)
 
+	// Verify connection, waiting for Postgres if it is still starting
+	if err := database.PingWithRetry(db, connectTimeout); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return db, nil
+}
+
+// NewRedisClient connects to Redis; this service only uses it for shared flags
+// such as maintenance mode
+func NewRedisClient(addr, password string, db int) *redis.Client {
+	client := redis.NewClient(&redis.Options{
+		Addr:         addr,
+		Password:     password,
+		DB:           db,
+		PoolSize:     5,
+		MaxRetries:   3,
+		DialTimeout:  5 * time.Second,
+		ReadTimeout:  3 * time.Second,
+		WriteTimeout: 3 * time.Second,
+	})
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := db.PingContext(ctx); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+	if err := client.Ping(ctx).Err(); err != nil {
+		fmt.Printf("Redis connection failed: %v (maintenance mode falls back to config)\n", err)
 	}
 
-	return db, nil
+	return client
+}
+
+// ExpectedIndexes are the indexes the queries rely on; keep in sync with the
+// CREATE INDEX migrations below (checked at startup when CHECK_INDEXES is set)
+var ExpectedIndexes = []string{
+	"idx_notifications_user_id",
+	"idx_notifications_status",
+	"idx_notifications_retry",
+	"idx_notification_templates_key",
 }
 
 func RunMigrations(db *sql.DB) error {
@@ -42,6 +79,39 @@ func RunMigrations(db *sql.DB) error {
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_notifications_status ON notifications(status)`,
+
+		// Set by the email provider's delivery callback
+		`ALTER TABLE notifications ADD COLUMN IF NOT EXISTS provider_message_id VARCHAR(255)`,
+
+		// Retry bookkeeping; next_attempt_at is only set while a failed send awaits a retry
+		`ALTER TABLE notifications ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE notifications ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP`,
+		`CREATE INDEX IF NOT EXISTS idx_notifications_retry ON notifications(next_attempt_at) WHERE status = 'failed'`,
+
+		// Opt-outs; users without a row receive everything
+		`ALTER TABLE notifications ADD COLUMN IF NOT EXISTS category VARCHAR(50) NOT NULL DEFAULT 'transactional'`,
+		`CREATE TABLE IF NOT EXISTS notification_preferences (
+			user_id VARCHAR(36) PRIMARY KEY,
+			email BOOLEAN NOT NULL DEFAULT TRUE,
+			sms BOOLEAN NOT NULL DEFAULT TRUE,
+			promotional BOOLEAN NOT NULL DEFAULT TRUE,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Admin-editable notification copy; one template per event, channel and locale
+		`CREATE TABLE IF NOT EXISTS notification_templates (
+			id VARCHAR(36) PRIMARY KEY,
+			name VARCHAR(100) NOT NULL,
+			channel VARCHAR(50) NOT NULL,
+			locale VARCHAR(10) NOT NULL,
+			subject_template TEXT NOT NULL,
+			body_template TEXT NOT NULL,
+			version INTEGER NOT NULL DEFAULT 1,
+			active BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_notification_templates_key ON notification_templates(name, channel, locale)`,
 	}
 
 	for i, migration := range migrations {
@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,6 +11,9 @@ import (
 	"ecommerce/shared/models"
 )
 
+// ErrNotificationNotFound is returned when no notification has the given ID
+var ErrNotificationNotFound = errors.New("notification not found")
+
 type NotificationRepository struct {
 	db *sql.DB
 }
@@ -23,11 +27,11 @@ func (r *NotificationRepository) Create(ctx context.Context, notification *model
 	notification.CreatedAt = time.Now()
 
 	query := `
-		INSERT INTO notifications (id, user_id, type, subject, message, status, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO notifications (id, user_id, type, category, subject, message, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 	`
 	_, err := r.db.ExecContext(ctx, query,
-		notification.ID, notification.UserID, notification.Type,
+		notification.ID, notification.UserID, notification.Type, notification.Category,
 		notification.Subject, notification.Message, notification.Status,
 		notification.CreatedAt,
 	)
@@ -36,7 +40,7 @@ func (r *NotificationRepository) Create(ctx context.Context, notification *model
 
 func (r *NotificationRepository) GetByUserID(ctx context.Context, userID string, limit, offset int) ([]*models.Notification, error) {
 	query := `
-		SELECT id, user_id, type, subject, message, status, created_at
+		SELECT id, user_id, type, category, subject, message, status, COALESCE(provider_message_id, ''), attempts, created_at
 		FROM notifications
 		WHERE user_id = $1
 		ORDER BY created_at DESC
@@ -51,7 +55,7 @@ func (r *NotificationRepository) GetByUserID(ctx context.Context, userID string,
 	var notifications []*models.Notification
 	for rows.Next() {
 		var n models.Notification
-		err := rows.Scan(&n.ID, &n.UserID, &n.Type, &n.Subject, &n.Message, &n.Status, &n.CreatedAt)
+		err := rows.Scan(&n.ID, &n.UserID, &n.Type, &n.Category, &n.Subject, &n.Message, &n.Status, &n.ProviderMessageID, &n.Attempts, &n.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -66,6 +70,84 @@ func (r *NotificationRepository) UpdateStatus(ctx context.Context, id, status st
 	return err
 }
 
+// MarkAllAsRead marks every unread notification of a user as read and
+// returns how many changed
+func (r *NotificationRepository) MarkAllAsRead(ctx context.Context, userID string) (int64, error) {
+	query := `UPDATE notifications SET status = 'read' WHERE user_id = $1 AND status != 'read'`
+	result, err := r.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RecordAttempt stores the outcome of a send attempt and counts it
+// nextAttemptAt schedules a retry of a failed send; nil means no retry
+func (r *NotificationRepository) RecordAttempt(ctx context.Context, id, status string, nextAttemptAt *time.Time) error {
+	query := `
+		UPDATE notifications
+		SET status = $1, attempts = attempts + 1, next_attempt_at = $2
+		WHERE id = $3
+	`
+	_, err := r.db.ExecContext(ctx, query, status, nextAttemptAt, id)
+	return err
+}
+
+// ClaimRetryable returns up to limit failed notifications created after since
+// whose retry is due, oldest due first
+// Claimed rows have their retry pushed back by lease, so concurrent workers
+// skip them while they're being sent
+func (r *NotificationRepository) ClaimRetryable(ctx context.Context, since time.Time, limit int, lease time.Duration) ([]*models.Notification, error) {
+	query := `
+		UPDATE notifications
+		SET next_attempt_at = $3
+		WHERE id IN (
+			SELECT id FROM notifications
+			WHERE status = 'failed' AND next_attempt_at <= NOW() AND created_at >= $1
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, type, category, subject, message, status, COALESCE(provider_message_id, ''), attempts, created_at
+	`
+	rows, err := r.db.QueryContext(ctx, query, since, limit, time.Now().Add(lease))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []*models.Notification
+	for rows.Next() {
+		var n models.Notification
+		err := rows.Scan(&n.ID, &n.UserID, &n.Type, &n.Category, &n.Subject, &n.Message, &n.Status, &n.ProviderMessageID, &n.Attempts, &n.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, &n)
+	}
+	return notifications, rows.Err()
+}
+
+// UpdateDeliveryStatus records a provider delivery outcome; an empty
+// providerMessageID keeps the one already stored
+func (r *NotificationRepository) UpdateDeliveryStatus(ctx context.Context, id, status, providerMessageID string) error {
+	query := `
+		UPDATE notifications
+		SET status = $1, provider_message_id = COALESCE(NULLIF($2, ''), provider_message_id)
+		WHERE id = $3
+	`
+	result, err := r.db.ExecContext(ctx, query, status, providerMessageID, id)
+	if err != nil {
+		return err
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotificationNotFound
+	}
+	return nil
+}
+
 func (r *NotificationRepository) HealthCheck(ctx context.Context) error {
 	return r.db.PingContext(ctx)
 }
@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"ecommerce/shared/models"
+)
+
+// GetPreferences returns a user's notification preferences, or the defaults
+// if they have never changed them
+func (r *NotificationRepository) GetPreferences(ctx context.Context, userID string) (*models.NotificationPreferences, error) {
+	query := `
+		SELECT user_id, email, sms, promotional, updated_at
+		FROM notification_preferences
+		WHERE user_id = $1
+	`
+	var prefs models.NotificationPreferences
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(
+		&prefs.UserID, &prefs.Email, &prefs.SMS, &prefs.Promotional, &prefs.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.DefaultNotificationPreferences(userID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &prefs, nil
+}
+
+// SavePreferences creates or replaces a user's notification preferences
+func (r *NotificationRepository) SavePreferences(ctx context.Context, prefs *models.NotificationPreferences) error {
+	prefs.UpdatedAt = time.Now()
+
+	query := `
+		INSERT INTO notification_preferences (user_id, email, sms, promotional, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE
+		SET email = EXCLUDED.email, sms = EXCLUDED.sms,
+			promotional = EXCLUDED.promotional, updated_at = EXCLUDED.updated_at
+	`
+	_, err := r.db.ExecContext(ctx, query, prefs.UserID, prefs.Email, prefs.SMS, prefs.Promotional, prefs.UpdatedAt)
+	return err
+}
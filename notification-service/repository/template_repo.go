@@ -0,0 +1,219 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"ecommerce/shared/cache"
+	"ecommerce/shared/models"
+)
+
+var (
+	ErrTemplateNotFound  = errors.New("template not found")
+	ErrDuplicateTemplate = errors.New("a template for this name, channel and locale already exists")
+)
+
+// uniqueViolation is the Postgres error code for a unique constraint conflict
+const uniqueViolation = "23505"
+
+// templateCacheTTL bounds how long a sender can use a cached template; edits
+// invalidate the entry, so this only matters if that delete fails
+const templateCacheTTL = 10 * time.Minute
+
+// templateColumns is the column list scanned by scanTemplate
+const templateColumns = `id, name, channel, locale, subject_template, body_template, version, active, created_at, updated_at`
+
+type TemplateRepository struct {
+	db    *sql.DB
+	redis *redis.Client
+	keys  cache.Keyer
+}
+
+func NewTemplateRepository(db *sql.DB, redisClient *redis.Client, keys cache.Keyer) *TemplateRepository {
+	return &TemplateRepository{
+		db:    db,
+		redis: redisClient,
+		keys:  keys,
+	}
+}
+
+// templateError maps a unique violation on the template key to ErrDuplicateTemplate
+func templateError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation && pqErr.Constraint == "idx_notification_templates_key" {
+		return ErrDuplicateTemplate
+	}
+	return err
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTemplate(row rowScanner) (*models.NotificationTemplate, error) {
+	var t models.NotificationTemplate
+	err := row.Scan(&t.ID, &t.Name, &t.Channel, &t.Locale, &t.SubjectTemplate, &t.BodyTemplate,
+		&t.Version, &t.Active, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *TemplateRepository) cacheKey(name, channel, locale string) string {
+	return r.keys.Key("template:%s:%s:%s", name, channel, locale)
+}
+
+// Create inserts a new template at version 1
+func (r *TemplateRepository) Create(ctx context.Context, t *models.NotificationTemplate) error {
+	t.ID = uuid.New().String()
+	t.Version = 1
+	t.CreatedAt = time.Now()
+	t.UpdatedAt = t.CreatedAt
+
+	query := `
+		INSERT INTO notification_templates (id, name, channel, locale, subject_template, body_template, version, active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		t.ID, t.Name, t.Channel, t.Locale, t.SubjectTemplate, t.BodyTemplate,
+		t.Version, t.Active, t.CreatedAt, t.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create template: %w", templateError(err))
+	}
+
+	r.redis.Del(ctx, r.cacheKey(t.Name, t.Channel, t.Locale))
+	return nil
+}
+
+// GetByID retrieves a template by ID
+func (r *TemplateRepository) GetByID(ctx context.Context, id string) (*models.NotificationTemplate, error) {
+	query := `SELECT ` + templateColumns + ` FROM notification_templates WHERE id = $1`
+
+	t, err := scanTemplate(r.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrTemplateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template: %w", err)
+	}
+	return t, nil
+}
+
+// GetActive returns the active template for a name, channel and locale, with caching
+// Returns ErrTemplateNotFound if there is none, or it is inactive
+func (r *TemplateRepository) GetActive(ctx context.Context, name, channel, locale string) (*models.NotificationTemplate, error) {
+	cacheKey := r.cacheKey(name, channel, locale)
+	if cached, err := r.redis.Get(ctx, cacheKey).Result(); err == nil {
+		var t models.NotificationTemplate
+		if err := json.Unmarshal([]byte(cached), &t); err == nil {
+			return &t, nil
+		}
+	}
+
+	query := `
+		SELECT ` + templateColumns + `
+		FROM notification_templates
+		WHERE name = $1 AND channel = $2 AND locale = $3 AND active
+	`
+	t, err := scanTemplate(r.db.QueryRowContext(ctx, query, name, channel, locale))
+	if err == sql.ErrNoRows {
+		return nil, ErrTemplateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template: %w", err)
+	}
+
+	if data, err := json.Marshal(t); err == nil {
+		r.redis.Set(ctx, cacheKey, data, templateCacheTTL)
+	}
+
+	return t, nil
+}
+
+// List returns all templates ordered by name, channel and locale
+func (r *TemplateRepository) List(ctx context.Context) ([]*models.NotificationTemplate, error) {
+	query := `SELECT ` + templateColumns + ` FROM notification_templates ORDER BY name, channel, locale`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+	defer rows.Close()
+
+	var templates []*models.NotificationTemplate
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan template: %w", err)
+		}
+		templates = append(templates, t)
+	}
+
+	return templates, rows.Err()
+}
+
+// Update saves a template's fields and bumps its version
+func (r *TemplateRepository) Update(ctx context.Context, t *models.NotificationTemplate) error {
+	previous, err := r.GetByID(ctx, t.ID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE notification_templates
+		SET name = $1, channel = $2, locale = $3, subject_template = $4, body_template = $5,
+		    active = $6, version = version + 1, updated_at = $7
+		WHERE id = $8
+		RETURNING version, updated_at
+	`
+	err = r.db.QueryRowContext(ctx, query,
+		t.Name, t.Channel, t.Locale, t.SubjectTemplate, t.BodyTemplate, t.Active, time.Now(), t.ID,
+	).Scan(&t.Version, &t.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrTemplateNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update template: %w", templateError(err))
+	}
+	t.CreatedAt = previous.CreatedAt
+
+	// The key may have changed, so drop both the old and new cache entries
+	r.redis.Del(ctx,
+		r.cacheKey(previous.Name, previous.Channel, previous.Locale),
+		r.cacheKey(t.Name, t.Channel, t.Locale),
+	)
+	return nil
+}
+
+// Delete removes a template; senders fall back to the built-in copy
+func (r *TemplateRepository) Delete(ctx context.Context, id string) error {
+	query := `DELETE FROM notification_templates WHERE id = $1 RETURNING name, channel, locale`
+
+	var name, channel, locale string
+	err := r.db.QueryRowContext(ctx, query, id).Scan(&name, &channel, &locale)
+	if err == sql.ErrNoRows {
+		return ErrTemplateNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete template: %w", err)
+	}
+
+	r.redis.Del(ctx, r.cacheKey(name, channel, locale))
+	return nil
+}
+
+// CacheHealthCheck pings Redis
+func (r *TemplateRepository) CacheHealthCheck(ctx context.Context) error {
+	return r.redis.Ping(ctx).Err()
+}
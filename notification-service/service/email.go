@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// smtpTimeout bounds a whole SMTP exchange, from dial to QUIT
+const smtpTimeout = 30 * time.Second
+
+// EmailSender delivers a plain-text email
+type EmailSender interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// SMTPSender sends email through an SMTP server, upgrading to TLS when the
+// server offers STARTTLS
+type SMTPSender struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+// NewSMTPSender creates a sender for the server at host:port
+// Authentication is skipped when username is empty
+func NewSMTPSender(host, port, username, password, from string) (*SMTPSender, error) {
+	if host == "" {
+		return nil, errors.New("SMTP host is required")
+	}
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("invalid SMTP sender address: %w", err)
+	}
+
+	return &SMTPSender{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		from:     from,
+	}, nil
+}
+
+// Send delivers one message; connection and protocol failures are returned
+// so the notification is marked failed
+func (s *SMTPSender) Send(ctx context.Context, to, subject, body string) error {
+	recipient, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address: %w", err)
+	}
+	sender, _ := mail.ParseAddress(s.from) // Validated in NewSMTPSender
+
+	ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+	defer cancel()
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.host, s.port))
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	if s.username != "" {
+		// PlainAuth refuses to send credentials over an unencrypted connection
+		if err := client.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(sender.Address); err != nil {
+		return fmt.Errorf("SMTP server rejected sender: %w", err)
+	}
+	if err := client.Rcpt(recipient.Address); err != nil {
+		return fmt.Errorf("SMTP server rejected recipient: %w", err)
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to start message: %w", err)
+	}
+	if _, err := w.Write(buildMessage(s.from, recipient.String(), subject, body)); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("SMTP server rejected message: %w", err)
+	}
+
+	return client.Quit()
+}
+
+// buildMessage formats an RFC 5322 message with CRLF line endings
+// The subject is encoded, so template output can't inject extra headers
+func buildMessage(from, to, subject, body string) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + to + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", stripNewlines(subject)) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+
+	return []byte(b.String())
+}
+
+// stripNewlines folds a header value onto one line
+func stripNewlines(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// NoopEmailSender logs emails instead of sending them, for development
+type NoopEmailSender struct {
+	logger *zap.Logger
+}
+
+// NewNoopEmailSender creates a sender that only logs
+func NewNoopEmailSender(logger *zap.Logger) *NoopEmailSender {
+	return &NoopEmailSender{logger: logger}
+}
+
+// Send logs the email and always succeeds
+func (s *NoopEmailSender) Send(ctx context.Context, to, subject, body string) error {
+	s.logger.Info("Email not sent (no-op sender)",
+		zap.String("to", to),
+		zap.String("subject", subject),
+	)
+	return nil
+}
@@ -2,23 +2,46 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"go.uber.org/zap"
 
 	"ecommerce/notification-service/repository"
+	"ecommerce/shared/messaging"
 	"ecommerce/shared/models"
 )
 
+var (
+	ErrNotificationNotFound  = errors.New("notification not found")
+	ErrInvalidDeliveryStatus = errors.New("status must be one of: delivered, bounced, failed")
+)
+
+// deliveryStatuses are the outcomes a provider callback may report
+var deliveryStatuses = map[string]bool{
+	"delivered": true,
+	"bounced":   true,
+	"failed":    true,
+}
+
 type NotificationService struct {
-	repo   *repository.NotificationRepository
-	logger *zap.Logger
+	repo      *repository.NotificationRepository
+	templates *repository.TemplateRepository
+	email     EmailSender
+	users     *UserClient // Resolves recipients' email addresses
+	retry     RetryPolicy
+	logger    *zap.Logger
 }
 
-func NewNotificationService(repo *repository.NotificationRepository, logger *zap.Logger) *NotificationService {
+func NewNotificationService(repo *repository.NotificationRepository, templates *repository.TemplateRepository, email EmailSender, users *UserClient, retry RetryPolicy, logger *zap.Logger) *NotificationService {
 	return &NotificationService{
-		repo:   repo,
-		logger: logger,
+		repo:      repo,
+		templates: templates,
+		email:     email,
+		users:     users,
+		retry:     retry,
+		logger:    logger,
 	}
 }
 
@@ -29,13 +52,20 @@ func (s *NotificationService) SendOrderConfirmation(userID, orderID string, tota
 		zap.String("order_id", orderID),
 	)
 
+	subject, message := s.compose(context.Background(), messaging.EventOrderConfirmed,
+		TemplateData{OrderID: orderID, TotalPrice: totalPrice},
+		"Order Confirmation",
+		fmt.Sprintf("Your order %s has been confirmed! Total: $%.2f", orderID, totalPrice),
+	)
+
 	// Create notification record
 	notification := &models.Notification{
-		UserID:  userID,
-		Type:    "email",
-		Subject: "Order Confirmation",
-		Message: fmt.Sprintf("Your order %s has been confirmed! Total: $%.2f", orderID, totalPrice),
-		Status:  "pending",
+		UserID:   userID,
+		Type:     defaultChannel,
+		Category: models.NotificationCategoryTransactional,
+		Subject:  subject,
+		Message:  message,
+		Status:   "pending",
 	}
 
 	// Save to database
@@ -44,17 +74,10 @@ func (s *NotificationService) SendOrderConfirmation(userID, orderID string, tota
 	}
 
 	// Actually send notification (email, SMS, push, etc.)
-	if err := s.sendNotification(notification); err != nil {
-		s.logger.Error("Failed to send notification", zap.Error(err))
-		// Mark as failed
-		s.repo.UpdateStatus(context.Background(), notification.ID, "failed")
-		return err
+	// A failed send is retried by the RetryWorker
+	if err := s.attempt(context.Background(), notification); err == nil && notification.Status == "sent" {
+		s.logger.Info("Notification sent successfully", zap.String("notification_id", notification.ID))
 	}
-
-	// Mark as sent
-	s.repo.UpdateStatus(context.Background(), notification.ID, "sent")
-
-	s.logger.Info("Notification sent successfully", zap.String("notification_id", notification.ID))
 	return nil
 }
 
@@ -65,31 +88,126 @@ func (s *NotificationService) SendOrderCancellation(userID, orderID string) erro
 		zap.String("order_id", orderID),
 	)
 
+	subject, message := s.compose(context.Background(), messaging.EventOrderCancelled,
+		TemplateData{OrderID: orderID},
+		"Order Cancelled",
+		fmt.Sprintf("Your order %s has been cancelled.", orderID),
+	)
+
 	notification := &models.Notification{
-		UserID:  userID,
-		Type:    "email",
-		Subject: "Order Cancelled",
-		Message: fmt.Sprintf("Your order %s has been cancelled.", orderID),
-		Status:  "pending",
+		UserID:   userID,
+		Type:     defaultChannel,
+		Category: models.NotificationCategoryTransactional,
+		Subject:  subject,
+		Message:  message,
+		Status:   "pending",
 	}
 
 	if err := s.repo.Create(context.Background(), notification); err != nil {
 		return fmt.Errorf("failed to save notification: %w", err)
 	}
 
-	if err := s.sendNotification(notification); err != nil {
-		s.logger.Error("Failed to send notification", zap.Error(err))
-		s.repo.UpdateStatus(context.Background(), notification.ID, "failed")
-		return err
+	s.attempt(context.Background(), notification)
+	return nil
+}
+
+// SendShipmentNotification sends order shipped notification with tracking info
+func (s *NotificationService) SendShipmentNotification(userID, orderID, trackingNumber string) error {
+	s.logger.Info("Sending shipment notification",
+		zap.String("user_id", userID),
+		zap.String("order_id", orderID),
+	)
+
+	subject, message := s.compose(context.Background(), messaging.EventOrderShipped,
+		TemplateData{OrderID: orderID, TrackingNumber: trackingNumber},
+		"Order Shipped",
+		fmt.Sprintf("Your order %s is on its way! Tracking number: %s", orderID, trackingNumber),
+	)
+
+	notification := &models.Notification{
+		UserID:   userID,
+		Type:     defaultChannel,
+		Category: models.NotificationCategoryTransactional,
+		Subject:  subject,
+		Message:  message,
+		Status:   "pending",
+	}
+
+	if err := s.repo.Create(context.Background(), notification); err != nil {
+		return fmt.Errorf("failed to save notification: %w", err)
 	}
 
-	s.repo.UpdateStatus(context.Background(), notification.ID, "sent")
+	s.attempt(context.Background(), notification)
+	return nil
+}
+
+// SendDeliveryNotification asks the customer about a delivered order
+func (s *NotificationService) SendDeliveryNotification(userID, orderID string) error {
+	s.logger.Info("Sending delivery notification",
+		zap.String("user_id", userID),
+		zap.String("order_id", orderID),
+	)
+
+	subject, message := s.compose(context.Background(), messaging.EventOrderDelivered,
+		TemplateData{OrderID: orderID},
+		"How was your order?",
+		fmt.Sprintf("Your order %s has been delivered. How was your order? Let us know by reviewing your products.", orderID),
+	)
+
+	notification := &models.Notification{
+		UserID:   userID,
+		Type:     defaultChannel,
+		Category: models.NotificationCategoryTransactional,
+		Subject:  subject,
+		Message:  message,
+		Status:   "pending",
+	}
+
+	if err := s.repo.Create(context.Background(), notification); err != nil {
+		return fmt.Errorf("failed to save notification: %w", err)
+	}
+
+	s.attempt(context.Background(), notification)
+	return nil
+}
+
+// SendPasswordReset emails a password reset link
+// Unlike other notifications it isn't saved: the link is a credential and
+// the notification history would expose it. For the same reason a failed
+// send is returned, so the event is redelivered, rather than left to the
+// RetryWorker. Preferences don't apply, as this is a security email
+func (s *NotificationService) SendPasswordReset(userID, email, resetURL string, expiresAt time.Time) error {
+	s.logger.Info("Sending password reset", zap.String("user_id", userID))
+
+	if !expiresAt.IsZero() && time.Now().After(expiresAt) {
+		s.logger.Warn("Password reset link expired before it was sent", zap.String("user_id", userID))
+		return nil
+	}
+
+	ctx := context.Background()
+	subject, message := s.compose(ctx, messaging.EventUserPasswordReset,
+		TemplateData{ResetURL: resetURL},
+		"Reset your password",
+		fmt.Sprintf("Use this link to reset your password: %s\n\nIt expires at %s. If you didn't ask to reset your password, you can ignore this email.",
+			resetURL, expiresAt.UTC().Format(time.RFC1123)),
+	)
+
+	if err := s.email.Send(ctx, email, subject, message); err != nil {
+		return fmt.Errorf("failed to send password reset email: %w", err)
+	}
 	return nil
 }
 
 // GetUserNotifications retrieves notifications for a user
 func (s *NotificationService) GetUserNotifications(ctx context.Context, userID string, limit, offset int) ([]*models.Notification, error) {
-	return s.repo.GetByUserID(ctx, userID, limit, offset)
+	notifications, err := s.repo.GetByUserID(ctx, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	if notifications == nil {
+		notifications = []*models.Notification{} // Serialize as [] rather than null
+	}
+	return notifications, nil
 }
 
 // MarkAsRead marks a notification as read
@@ -97,21 +215,173 @@ func (s *NotificationService) MarkAsRead(ctx context.Context, notificationID str
 	return s.repo.UpdateStatus(ctx, notificationID, "read")
 }
 
-// sendNotification actually sends the notification via email/SMS/push
-func (s *NotificationService) sendNotification(notification *models.Notification) error {
-	// In production, integrate with:
-	// - SendGrid/AWS SES for email
-	// - Twilio for SMS
-	// - Firebase for push notifications
+// MarkAllAsRead marks all of a user's notifications as read and returns how many changed
+func (s *NotificationService) MarkAllAsRead(ctx context.Context, userID string) (int64, error) {
+	return s.repo.MarkAllAsRead(ctx, userID)
+}
+
+// PreferencesUpdate changes a user's notification preferences; nil fields are left as they are
+type PreferencesUpdate struct {
+	Email       *bool `json:"email"`
+	SMS         *bool `json:"sms"`
+	Promotional *bool `json:"promotional"`
+}
+
+// GetPreferences returns a user's notification preferences
+func (s *NotificationService) GetPreferences(ctx context.Context, userID string) (*models.NotificationPreferences, error) {
+	return s.repo.GetPreferences(ctx, userID)
+}
+
+// UpdatePreferences applies update to a user's notification preferences
+func (s *NotificationService) UpdatePreferences(ctx context.Context, userID string, update PreferencesUpdate) (*models.NotificationPreferences, error) {
+	prefs, err := s.repo.GetPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Email != nil {
+		prefs.Email = *update.Email
+	}
+	if update.SMS != nil {
+		prefs.SMS = *update.SMS
+	}
+	if update.Promotional != nil {
+		prefs.Promotional = *update.Promotional
+	}
+
+	if err := s.repo.SavePreferences(ctx, prefs); err != nil {
+		return nil, fmt.Errorf("failed to save preferences: %w", err)
+	}
+
+	return prefs, nil
+}
+
+// UpdateDeliveryStatus records the outcome reported by the email provider
+func (s *NotificationService) UpdateDeliveryStatus(ctx context.Context, notificationID, status, providerMessageID string) error {
+	if !deliveryStatuses[status] {
+		return ErrInvalidDeliveryStatus
+	}
+
+	if err := s.repo.UpdateDeliveryStatus(ctx, notificationID, status, providerMessageID); err != nil {
+		if errors.Is(err, repository.ErrNotificationNotFound) {
+			return ErrNotificationNotFound
+		}
+		return fmt.Errorf("failed to update delivery status: %w", err)
+	}
+
+	s.logger.Info("Delivery status updated",
+		zap.String("notification_id", notificationID),
+		zap.String("status", status),
+		zap.String("provider_message_id", providerMessageID),
+	)
+	return nil
+}
+
+// attempt sends a saved notification and records the outcome
+// A failed send is scheduled for retry rather than returned to the consumer:
+// redelivering the event would save a duplicate notification
+func (s *NotificationService) attempt(ctx context.Context, notification *models.Notification) error {
+	optedOut, sendErr := s.optedOut(ctx, notification)
+	if optedOut {
+		s.logger.Info("Notification suppressed by user preferences",
+			zap.String("notification_id", notification.ID),
+			zap.String("type", notification.Type),
+			zap.String("category", notification.Category),
+		)
+		notification.Status = "suppressed"
+		return s.repo.UpdateStatus(ctx, notification.ID, "suppressed")
+	}
+	if sendErr == nil {
+		sendErr = s.sendNotification(ctx, notification)
+	}
+	notification.Attempts++
+
+	status, nextAttemptAt := "sent", (*time.Time)(nil)
+	if sendErr != nil {
+		status = "failed"
+		if next, ok := s.retry.next(notification.Attempts); ok {
+			nextAttemptAt = &next
+		}
+		s.logger.Error("Failed to send notification",
+			zap.String("notification_id", notification.ID),
+			zap.Int("attempts", notification.Attempts),
+			zap.Bool("will_retry", nextAttemptAt != nil),
+			zap.Error(sendErr),
+		)
+	}
+	notification.Status = status
 
+	if err := s.repo.RecordAttempt(ctx, notification.ID, status, nextAttemptAt); err != nil {
+		s.logger.Error("Failed to record send attempt",
+			zap.String("notification_id", notification.ID),
+			zap.Error(err),
+		)
+	}
+
+	return sendErr
+}
+
+// optedOut reports whether the recipient's preferences exclude this notification
+func (s *NotificationService) optedOut(ctx context.Context, notification *models.Notification) (bool, error) {
+	prefs, err := s.repo.GetPreferences(ctx, notification.UserID)
+	if err != nil {
+		return false, fmt.Errorf("failed to load preferences: %w", err)
+	}
+
+	if notification.Category == models.NotificationCategoryPromotional && !prefs.Promotional {
+		return true, nil
+	}
+	switch notification.Type {
+	case "email":
+		return !prefs.Email, nil
+	case "sms":
+		return !prefs.SMS, nil
+	default:
+		return false, nil
+	}
+}
+
+// RetryFailed re-sends failed notifications created after since whose retry
+// is due, and returns how many were attempted
+func (s *NotificationService) RetryFailed(ctx context.Context, since time.Time, limit int) (int, error) {
+	notifications, err := s.repo.ClaimRetryable(ctx, since, limit, retryLease)
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim notifications: %w", err)
+	}
+
+	for _, notification := range notifications {
+		if err := s.attempt(ctx, notification); err == nil && notification.Status == "sent" {
+			s.logger.Info("Notification sent on retry",
+				zap.String("notification_id", notification.ID),
+				zap.Int("attempts", notification.Attempts),
+			)
+		}
+	}
+
+	return len(notifications), nil
+}
+
+// sendNotification actually sends the notification via email/SMS/push
+// Errors are returned so the caller marks the notification failed
+func (s *NotificationService) sendNotification(ctx context.Context, notification *models.Notification) error {
 	s.logger.Info("Sending notification",
 		zap.String("type", notification.Type),
 		zap.String("user_id", notification.UserID),
 		zap.String("subject", notification.Subject),
 	)
 
-	// Simulate sending (in production, make actual API calls)
-	// For now, just log
+	if notification.Type == "email" {
+		to, err := s.users.GetEmail(ctx, notification.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to resolve recipient: %w", err)
+		}
+		if err := s.email.Send(ctx, to, notification.Subject, notification.Message); err != nil {
+			return fmt.Errorf("failed to send email: %w", err)
+		}
+		return nil
+	}
+
+	// Other channels (SMS, push) have no provider yet, so they are only logged
 	s.logger.Info("Notification content", zap.String("message", notification.Message))
 
 	return nil
@@ -121,3 +391,8 @@ func (s *NotificationService) sendNotification(notification *models.Notification
 func (s *NotificationService) HealthCheck(ctx context.Context) error {
 	return s.repo.HealthCheck(ctx)
 }
+
+// CacheHealthCheck verifies Redis; the service keeps working without it
+func (s *NotificationService) CacheHealthCheck(ctx context.Context) error {
+	return s.templates.CacheHealthCheck(ctx)
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// retryBatchSize is how many failed notifications one scan retries at most
+	retryBatchSize = 50
+
+	// retryLease is how long a claimed notification is hidden from other
+	// workers; it outlasts a send, which smtpTimeout bounds
+	retryLease = 5 * time.Minute
+)
+
+// RetryPolicy decides whether and when a failed send is retried
+// The delay doubles after each attempt: BaseDelay, 2*BaseDelay, ... up to MaxDelay
+type RetryPolicy struct {
+	MaxAttempts int // Total send attempts, including the first
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// next returns when to retry after the given number of attempts, or false
+// once MaxAttempts is reached
+func (p RetryPolicy) next(attempts int) (time.Time, bool) {
+	if attempts >= p.MaxAttempts {
+		return time.Time{}, false
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	return time.Now().Add(delay), true
+}
+
+// RetryWorker periodically re-sends failed notifications whose retry is due
+type RetryWorker struct {
+	service  *NotificationService
+	interval time.Duration
+	window   time.Duration
+	logger   *zap.Logger
+}
+
+// NewRetryWorker creates a worker scanning every interval; notifications
+// older than window are left failed
+func NewRetryWorker(service *NotificationService, interval, window time.Duration, logger *zap.Logger) *RetryWorker {
+	return &RetryWorker{
+		service:  service,
+		interval: interval,
+		window:   window,
+		logger:   logger,
+	}
+}
+
+// Run scans for due retries until ctx is cancelled
+func (w *RetryWorker) Run(ctx context.Context) {
+	w.logger.Info("Notification retry worker started", zap.Duration("interval", w.interval))
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			w.logger.Info("Notification retry worker stopped")
+			return
+		case <-ticker.C:
+		}
+
+		// Keep going while full batches come back, so a backlog drains quickly
+		for {
+			retried, err := w.service.RetryFailed(ctx, time.Now().Add(-w.window), retryBatchSize)
+			if err != nil {
+				w.logger.Error("Notification retry scan failed", zap.Error(err))
+				break
+			}
+			if retried < retryBatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"go.uber.org/zap"
+
+	"ecommerce/notification-service/repository"
+	"ecommerce/shared/messaging"
+	"ecommerce/shared/models"
+)
+
+var (
+	ErrTemplateNotFound  = errors.New("template not found")
+	ErrDuplicateTemplate = errors.New("a template for this name, channel and locale already exists")
+	ErrInvalidTemplate   = errors.New("invalid template")
+)
+
+const (
+	// defaultChannel and defaultLocale are used for every notification until
+	// users can choose their own
+	defaultChannel = "email"
+	defaultLocale  = "en"
+)
+
+// templateNames are the events a template can be written for
+var templateNames = map[string]bool{
+	messaging.EventOrderConfirmed: true,
+	messaging.EventOrderCancelled: true,
+	messaging.EventOrderShipped:   true,
+	messaging.EventOrderDelivered: true,
+
+	messaging.EventUserPasswordReset: true,
+}
+
+// templateChannels are the channels a template can be written for
+var templateChannels = map[string]bool{
+	"email": true,
+	"sms":   true,
+}
+
+// TemplateData is what notification templates can reference, e.g.
+// "Your order {{.OrderID}} has shipped"
+type TemplateData struct {
+	OrderID        string  `json:"order_id"`
+	TotalPrice     float64 `json:"total_price"`
+	TrackingNumber string  `json:"tracking_number"`
+	ResetURL       string  `json:"reset_url"` // Password reset emails only
+}
+
+// sampleTemplateData is rendered when a template is saved, so references to
+// fields that don't exist are caught before a real notification uses it
+var sampleTemplateData = TemplateData{
+	OrderID:        "00000000-0000-0000-0000-000000000000",
+	TotalPrice:     99.99,
+	TrackingNumber: "TRACK123",
+	ResetURL:       "https://example.com/reset-password?token=0000",
+}
+
+const (
+	// maxTemplateLength caps the size of a stored template
+	maxTemplateLength = 64 << 10
+
+	// maxRenderedLength caps a rendered subject or body
+	maxRenderedLength = 256 << 10
+)
+
+// errRenderTooLarge stops rendering once output passes maxRenderedLength
+var errRenderTooLarge = fmt.Errorf("rendered output exceeds %d bytes", maxRenderedLength)
+
+// limitedBuffer is a bytes.Buffer that refuses to grow past maxRenderedLength
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > maxRenderedLength {
+		return 0, errRenderTooLarge
+	}
+	return b.Buffer.Write(p)
+}
+
+// renderTemplate executes a single template against data
+// Templates are editable through the admin API, so only field access and
+// conditionals are allowed: no range loops, no nested template definitions,
+// and output is size-limited. text/template itself can't run arbitrary code
+func renderTemplate(name, text string, data TemplateData) (string, error) {
+	if len(text) > maxTemplateLength {
+		return "", fmt.Errorf("template exceeds %d bytes", maxTemplateLength)
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	if len(tmpl.Templates()) > 1 {
+		return "", errors.New("define and block are not allowed")
+	}
+	if tmpl.Tree != nil {
+		if err := checkNodes(tmpl.Tree.Root); err != nil {
+			return "", err
+		}
+	}
+
+	var buf limitedBuffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// checkNodes rejects the actions renderTemplate doesn't allow
+func checkNodes(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkNodes(child); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return checkBranch(&n.BranchNode)
+	case *parse.WithNode:
+		return checkBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		return fmt.Errorf("line %d: range is not allowed", n.Line)
+	case *parse.TemplateNode:
+		return fmt.Errorf("line %d: template is not allowed", n.Line)
+	}
+	return nil
+}
+
+func checkBranch(b *parse.BranchNode) error {
+	if err := checkNodes(b.List); err != nil {
+		return err
+	}
+	return checkNodes(b.ElseList)
+}
+
+// validateTemplate checks a template's fields and that both parts compile and render
+func validateTemplate(t *models.NotificationTemplate) error {
+	t.Name = strings.TrimSpace(t.Name)
+	t.Channel = strings.TrimSpace(t.Channel)
+	t.Locale = strings.TrimSpace(t.Locale)
+	if t.Locale == "" {
+		t.Locale = defaultLocale
+	}
+
+	if !templateNames[t.Name] {
+		return fmt.Errorf("%w: unknown name %q", ErrInvalidTemplate, t.Name)
+	}
+	if !templateChannels[t.Channel] {
+		return fmt.Errorf("%w: channel must be email or sms", ErrInvalidTemplate)
+	}
+	if strings.TrimSpace(t.BodyTemplate) == "" {
+		return fmt.Errorf("%w: body_template is required", ErrInvalidTemplate)
+	}
+
+	if _, err := renderTemplate("subject", t.SubjectTemplate, sampleTemplateData); err != nil {
+		return fmt.Errorf("%w: subject_template: %v", ErrInvalidTemplate, err)
+	}
+	if _, err := renderTemplate("body", t.BodyTemplate, sampleTemplateData); err != nil {
+		return fmt.Errorf("%w: body_template: %v", ErrInvalidTemplate, err)
+	}
+	return nil
+}
+
+// TemplatePreview is a template rendered with sample data
+type TemplatePreview struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// PreviewTemplate renders a subject and body template without saving or sending
+// anything; data defaults to built-in sample values
+func (s *NotificationService) PreviewTemplate(subjectTemplate, bodyTemplate string, data *TemplateData) (*TemplatePreview, error) {
+	if data == nil {
+		data = &sampleTemplateData
+	}
+
+	subject, err := renderTemplate("subject", subjectTemplate, *data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: subject_template: %v", ErrInvalidTemplate, err)
+	}
+	body, err := renderTemplate("body", bodyTemplate, *data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: body_template: %v", ErrInvalidTemplate, err)
+	}
+
+	return &TemplatePreview{Subject: subject, Body: body}, nil
+}
+
+// templateRepoError maps repository template errors to service errors
+func templateRepoError(err error) error {
+	switch {
+	case errors.Is(err, repository.ErrTemplateNotFound):
+		return ErrTemplateNotFound
+	case errors.Is(err, repository.ErrDuplicateTemplate):
+		return ErrDuplicateTemplate
+	}
+	return err
+}
+
+// CreateTemplate validates and stores a new template
+func (s *NotificationService) CreateTemplate(ctx context.Context, t *models.NotificationTemplate) error {
+	if err := validateTemplate(t); err != nil {
+		return err
+	}
+	return templateRepoError(s.templates.Create(ctx, t))
+}
+
+// GetTemplate retrieves a template by ID
+func (s *NotificationService) GetTemplate(ctx context.Context, id string) (*models.NotificationTemplate, error) {
+	t, err := s.templates.GetByID(ctx, id)
+	if err != nil {
+		return nil, templateRepoError(err)
+	}
+	return t, nil
+}
+
+// ListTemplates returns every template
+func (s *NotificationService) ListTemplates(ctx context.Context) ([]*models.NotificationTemplate, error) {
+	templates, err := s.templates.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if templates == nil {
+		templates = []*models.NotificationTemplate{}
+	}
+	return templates, nil
+}
+
+// UpdateTemplate validates and saves changes to a template, bumping its version
+func (s *NotificationService) UpdateTemplate(ctx context.Context, t *models.NotificationTemplate) error {
+	if err := validateTemplate(t); err != nil {
+		return err
+	}
+	return templateRepoError(s.templates.Update(ctx, t))
+}
+
+// DeleteTemplate removes a template
+func (s *NotificationService) DeleteTemplate(ctx context.Context, id string) error {
+	return templateRepoError(s.templates.Delete(ctx, id))
+}
+
+// compose renders the subject and message for an event from its active
+// template, falling back to the built-in copy when there is no usable template
+func (s *NotificationService) compose(ctx context.Context, name string, data TemplateData, defaultSubject, defaultMessage string) (string, string) {
+	t, err := s.templates.GetActive(ctx, name, defaultChannel, defaultLocale)
+	if err != nil {
+		if !errors.Is(err, repository.ErrTemplateNotFound) {
+			s.logger.Warn("Failed to load notification template", zap.String("name", name), zap.Error(err))
+		}
+		return defaultSubject, defaultMessage
+	}
+
+	subject, err := renderTemplate("subject", t.SubjectTemplate, data)
+	if err != nil {
+		s.logger.Error("Failed to render notification template", zap.String("template_id", t.ID), zap.Error(err))
+		return defaultSubject, defaultMessage
+	}
+	message, err := renderTemplate("body", t.BodyTemplate, data)
+	if err != nil {
+		s.logger.Error("Failed to render notification template", zap.String("template_id", t.ID), zap.Error(err))
+		return defaultSubject, defaultMessage
+	}
+
+	return subject, message
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"ecommerce/shared/models"
+)
+
+var (
+	ErrRecipientNotFound      = errors.New("recipient not found")
+	ErrUserServiceUnavailable = errors.New("user service unavailable")
+)
+
+// UserClient looks up notification recipients in user-service
+type UserClient struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewUserClient creates a client for the user service at baseURL
+func NewUserClient(baseURL string, timeout time.Duration) *UserClient {
+	return &UserClient{
+		baseURL:    baseURL,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// GetEmail returns a user's email address via GET /api/v1/internal/users/:id
+func (c *UserClient) GetEmail(ctx context.Context, userID string) (string, error) {
+	endpoint := c.baseURL + "/api/v1/internal/users/" + url.PathEscape(userID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUserServiceUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", fmt.Errorf("%w: %s", ErrRecipientNotFound, userID)
+	default:
+		return "", fmt.Errorf("%w: user lookup returned status %d", ErrUserServiceUnavailable, resp.StatusCode)
+	}
+
+	var apiResp struct {
+		Success bool         `json:"success"`
+		Data    *models.User `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return "", fmt.Errorf("%w: invalid response: %v", ErrUserServiceUnavailable, err)
+	}
+	if apiResp.Data == nil || apiResp.Data.Email == "" {
+		return "", fmt.Errorf("%w: %s has no email address", ErrRecipientNotFound, userID)
+	}
+
+	return apiResp.Data.Email, nil
+}
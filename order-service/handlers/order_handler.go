@@ -1,31 +1,44 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
 	"ecommerce/order-service/service"
+	"ecommerce/shared/auth"
+	"ecommerce/shared/binding"
+	apperrors "ecommerce/shared/errors"
+	"ecommerce/shared/fields"
 	"ecommerce/shared/models"
+	"ecommerce/shared/pagination"
 )
 
+// orderFields are the fields clients may select with ?fields=
+var orderFields = fields.Of(models.Order{})
+
 type OrderHandler struct {
 	service *service.OrderService
+	paging  pagination.Limits
 	logger  *zap.Logger
 }
 
-func NewOrderHandler(service *service.OrderService, logger *zap.Logger) *OrderHandler {
+func NewOrderHandler(service *service.OrderService, paging pagination.Limits, logger *zap.Logger) *OrderHandler {
 	return &OrderHandler{
 		service: service,
+		paging:  paging,
 		logger:  logger,
 	}
 }
 
+// maxIdempotencyKeyLength bounds the Idempotency-Key header, which becomes part of a Redis key
+const maxIdempotencyKeyLength = 255
+
 // CreateOrder creates a new order
-// POST /api/v1/orders
+// POST /api/v1/orders (optional Idempotency-Key header)
 func (h *OrderHandler) CreateOrder(c *gin.Context) {
 	// In production, get userID from JWT token (AuthMiddleware)
 	// For now, get from header or body
@@ -35,11 +48,7 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 	}
 
 	var req models.CreateOrderRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Error:   "Invalid request: " + err.Error(),
-		})
+	if !binding.BindJSON(c, &req) {
 		return
 	}
 
@@ -48,16 +57,33 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 		zap.Int("items_count", len(req.Items)),
 	)
 
-	order, err := h.service.CreateOrder(c.Request.Context(), userID, &req)
-	if err != nil {
-		h.logger.Error("Failed to create order", zap.Error(err))
-		statusCode := http.StatusInternalServerError
-		if err == service.ErrInsufficientStock {
-			statusCode = http.StatusBadRequest
+	// Clients retrying a checkout send the same Idempotency-Key so a lost
+	// response doesn't create a second order
+	var order *models.Order
+	var replayed bool
+	var err error
+	if key := c.GetHeader("Idempotency-Key"); key != "" {
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   "Idempotency-Key is too long",
+			})
+			return
 		}
-		c.JSON(statusCode, models.APIResponse{
-			Success: false,
-			Error:   err.Error(),
+		order, replayed, err = h.service.CreateOrderIdempotent(c.Request.Context(), userID, key, &req)
+	} else {
+		order, err = h.service.CreateOrder(c.Request.Context(), userID, &req)
+	}
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	if replayed {
+		c.JSON(http.StatusOK, models.APIResponse{
+			Success: true,
+			Message: "Order already created",
+			Data:    order,
 		})
 		return
 	}
@@ -70,7 +96,8 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 }
 
 // GetOrderByID retrieves an order by ID
-// GET /api/v1/orders/:id
+// GET /api/v1/orders/:id?expand=products
+// expand=products embeds each item's product name, category, SKU and image
 func (h *OrderHandler) GetOrderByID(c *gin.Context) {
 	orderID := c.Param("id")
 	userID := c.GetHeader("X-User-ID")
@@ -80,11 +107,25 @@ func (h *OrderHandler) GetOrderByID(c *gin.Context) {
 
 	order, err := h.service.GetOrderByID(c.Request.Context(), orderID, userID)
 	if err != nil {
-		statusCode := http.StatusNotFound
-		if err.Error() == "unauthorized access to order" {
-			statusCode = http.StatusForbidden
-		}
-		c.JSON(statusCode, models.APIResponse{
+		h.respondError(c, err)
+		return
+	}
+
+	switch expand := c.Query("expand"); expand {
+	case "": // Items carry product IDs only
+	case "products":
+		h.service.ExpandProducts(c.Request.Context(), order)
+	default:
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("unknown expand %q; supported: products", expand),
+		})
+		return
+	}
+
+	data, err := fields.Select(c, order, orderFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
 			Error:   err.Error(),
 		})
@@ -93,25 +134,95 @@ func (h *OrderHandler) GetOrderByID(c *gin.Context) {
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Data:    order,
+		Data:    data,
 	})
 }
 
-// ListUserOrders lists all orders for a user
-// GET /api/v1/orders?page=1&page_size=20
+// GetOrderHistory returns an order's status timeline, oldest first
+// GET /api/v1/orders/:id/history
+func (h *OrderHandler) GetOrderHistory(c *gin.Context) {
+	orderID := c.Param("id")
+	userID := c.GetHeader("X-User-ID")
+	if userID == "" {
+		userID = "test-user-123"
+	}
+
+	history, err := h.service.GetOrderHistory(c.Request.Context(), orderID, userID)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    history,
+	})
+}
+
+// ListUserOrders lists a user's orders, optionally filtered by status and creation date
+// GET /api/v1/orders?page=1&page_size=20&status=shipped&from=2024-01-01T00:00:00Z&to=2024-02-01T00:00:00Z
 func (h *OrderHandler) ListUserOrders(c *gin.Context) {
 	userID := c.GetHeader("X-User-ID")
 	if userID == "" {
 		userID = "test-user-123"
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	page := h.paging.FromQuery(c)
+	filter, err := orderFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	orders, total, err := h.service.ListUserOrders(c.Request.Context(), userID, page, filter)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	data, err := fields.Select(c, orders, orderFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    models.NewPaginatedResponse(data, total, page.Number, page.Size),
+	})
+}
+
+// GetOrdersBatch returns several orders by ID, skipping any the caller can't see
+// POST /api/v1/orders/batch {"ids": ["...", "..."]}
+func (h *OrderHandler) GetOrdersBatch(c *gin.Context) {
+	var req struct {
+		IDs []string `json:"ids" binding:"required"`
+	}
+
+	if !binding.BindJSON(c, &req) {
+		return
+	}
+
+	orders, err := h.service.GetOrdersBatch(
+		c.Request.Context(),
+		req.IDs,
+		c.GetString(auth.ContextUserID),
+		c.GetString(auth.ContextRole),
+	)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
 
-	orders, err := h.service.ListUserOrders(c.Request.Context(), userID, page, pageSize)
+	data, err := fields.Select(c, orders, orderFields)
 	if err != nil {
-		h.logger.Error("Failed to list orders", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
 			Error:   err.Error(),
 		})
@@ -120,7 +231,46 @@ func (h *OrderHandler) ListUserOrders(c *gin.Context) {
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Data:    orders,
+		Data:    data,
+	})
+}
+
+// GetUserStats returns the caller's aggregate order statistics
+// GET /api/v1/orders/stats (identity comes from the JWT, never a header)
+func (h *OrderHandler) GetUserStats(c *gin.Context) {
+	userID := c.GetString(auth.ContextUserID)
+
+	stats, err := h.service.GetUserStats(c.Request.Context(), userID)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    stats,
+	})
+}
+
+// VerifyPurchase reports whether a user has a delivered order containing a product
+// GET /api/v1/internal/purchases?user_id=...&product_id=... (service-to-service only)
+func (h *OrderHandler) VerifyPurchase(c *gin.Context) {
+	userID := c.Query("user_id")
+	productID := c.Query("product_id")
+
+	verified, err := h.service.VerifyPurchase(c.Request.Context(), userID, productID)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"user_id":           userID,
+			"product_id":        productID,
+			"verified_purchase": verified,
+		},
 	})
 }
 
@@ -134,15 +284,7 @@ func (h *OrderHandler) CancelOrder(c *gin.Context) {
 	}
 
 	if err := h.service.CancelOrder(c.Request.Context(), orderID, userID); err != nil {
-		h.logger.Error("Failed to cancel order", zap.Error(err))
-		statusCode := http.StatusInternalServerError
-		if err.Error() == "unauthorized" {
-			statusCode = http.StatusForbidden
-		}
-		c.JSON(statusCode, models.APIResponse{
-			Success: false,
-			Error:   err.Error(),
-		})
+		h.respondError(c, err)
 		return
 	}
 
@@ -152,6 +294,85 @@ func (h *OrderHandler) CancelOrder(c *gin.Context) {
 	})
 }
 
+// ShipOrder marks an order as shipped (admin/warehouse only)
+// PUT /api/v1/orders/:id/ship
+func (h *OrderHandler) ShipOrder(c *gin.Context) {
+	orderID := c.Param("id")
+
+	var req struct {
+		TrackingNumber string `json:"tracking_number" binding:"required"`
+	}
+
+	if !binding.BindJSON(c, &req) {
+		return
+	}
+
+	order, err := h.service.ShipOrder(c.Request.Context(), orderID, req.TrackingNumber, c.GetString(auth.ContextUserID))
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Order shipped successfully",
+		Data:    order,
+	})
+}
+
+// UpdateOrderStatus applies a fulfilment status update (admin/warehouse only)
+// PUT /api/v1/orders/:id/status {"status": "delivered"}
+// status: confirmed, delivered or cancelled; shipping goes through /ship
+// Illegal transitions (e.g. delivered -> cancelled) return 409
+func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
+	orderID := c.Param("id")
+
+	var req struct {
+		Status string `json:"status" binding:"required"`
+	}
+
+	if !binding.BindJSON(c, &req) {
+		return
+	}
+
+	order, err := h.service.UpdateOrderStatus(c.Request.Context(), orderID, req.Status, c.GetString(auth.ContextUserID))
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Order status updated",
+		Data:    order,
+	})
+}
+
+// ReplayOrderEvents re-emits an order's current-state event (admin only)
+// POST /api/v1/admin/orders/:id/replay-events
+func (h *OrderHandler) ReplayOrderEvents(c *gin.Context) {
+	orderID := c.Param("id")
+
+	event, err := h.service.ReplayOrderEvents(c.Request.Context(), orderID)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	// Audit trail: replays can trigger customer-facing side effects downstream
+	h.logger.Info("Order events replayed",
+		zap.String("admin_id", c.GetString(auth.ContextUserID)),
+		zap.String("order_id", orderID),
+		zap.String("status", event.Status),
+	)
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Order events replayed",
+		Data:    event,
+	})
+}
+
 // GetOrderStatus retrieves order status
 // GET /api/v1/orders/:id/status
 func (h *OrderHandler) GetOrderStatus(c *gin.Context) {
@@ -163,10 +384,7 @@ func (h *OrderHandler) GetOrderStatus(c *gin.Context) {
 
 	status, err := h.service.GetOrderStatus(c.Request.Context(), orderID, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.APIResponse{
-			Success: false,
-			Error:   err.Error(),
-		})
+		h.respondError(c, err)
 		return
 	}
 
@@ -197,6 +415,16 @@ func (h *OrderHandler) HealthCheck(c *gin.Context) {
 	}
 
 	response.Checks["database"] = "connected"
+
+	// Redis only backs caches and shared flags, so losing it degrades the
+	// service without taking it out of rotation
+	if err := h.service.CacheHealthCheck(c.Request.Context()); err != nil {
+		response.Status = "degraded"
+		response.Checks["redis"] = "disconnected"
+	} else {
+		response.Checks["redis"] = "connected"
+	}
+
 	c.JSON(http.StatusOK, response)
 }
 
@@ -205,3 +433,47 @@ func (h *OrderHandler) HealthCheck(c *gin.Context) {
 func (h *OrderHandler) ReadinessCheck(c *gin.Context) {
 	h.HealthCheck(c)
 }
+
+// respondError writes err with the status its type maps to. Untyped errors
+// are unexpected, so they are logged here and reported without detail
+func (h *OrderHandler) respondError(c *gin.Context, err error) {
+	status, response := apperrors.Response(err)
+	if status >= http.StatusInternalServerError {
+		h.logger.Error("Request failed",
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.FullPath()),
+			zap.Error(err),
+		)
+	}
+	c.JSON(status, response)
+}
+
+// orderFilter reads the optional status, from and to query params
+func orderFilter(c *gin.Context) (service.OrderFilter, error) {
+	filter := service.OrderFilter{Status: c.Query("status")}
+	var err error
+
+	if filter.From, err = timeParam(c, "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = timeParam(c, "to"); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+// timeParam parses an RFC3339 query param; nil means it wasn't given
+func timeParam(c *gin.Context, name string) (*time.Time, error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return nil, nil
+	}
+
+	value, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an RFC3339 timestamp, e.g. 2024-01-31T00:00:00Z", name)
+	}
+
+	return &value, nil
+}
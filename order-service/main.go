@@ -16,8 +16,13 @@ import (
 	"ecommerce/order-service/messaging"
 	"ecommerce/order-service/repository"
 	"ecommerce/order-service/service"
+	"ecommerce/shared/auth"
+	"ecommerce/shared/cache"
 	"ecommerce/shared/config"
+	"ecommerce/shared/database"
 	"ecommerce/shared/logger"
+	"ecommerce/shared/middleware"
+	"ecommerce/shared/pagination"
 )
 
 func main() {
@@ -31,13 +36,22 @@ func main() {
 	}
 	defer log.Sync()
 
+	// Refuse to start in production with insecure or missing settings
+	warnings, err := cfg.Validate()
+	if err != nil {
+		log.Fatal("Invalid configuration", zap.Error(err))
+	}
+	for _, warning := range warnings {
+		log.Warn("Configuration warning", zap.String("problem", warning))
+	}
+
 	log.Info("Starting Order Service",
 		zap.String("environment", cfg.Environment),
 		zap.String("port", cfg.Port),
 	)
 
 	// 3. Initialize database
-	db, err := repository.NewPostgresDB(cfg.GetDatabaseURL())
+	db, err := repository.NewPostgresDB(cfg.GetDatabaseURL(), cfg.DBConnectTimeout)
 	if err != nil {
 		log.Fatal("Failed to connect to database", zap.Error(err))
 	}
@@ -50,10 +64,23 @@ func main() {
 		log.Fatal("Failed to run migrations", zap.Error(err))
 	}
 
+	if cfg.CheckIndexes {
+		database.WarnMissingIndexes(db, repository.ExpectedIndexes, log.Logger)
+	}
+
 	// 5. Initialize Redis
-	redisClient := repository.NewRedisClient(cfg.GetRedisURL(), cfg.RedisPassword)
+	redisClient := repository.NewRedisClient(cfg.GetRedisURL(), cfg.RedisPassword, cfg.RedisDB)
 	defer redisClient.Close()
 
+	// The token denylist and maintenance flag live on REDIS_SHARED_DB so every
+	// service sees them, whatever its own REDIS_DB
+	sharedRedis := redisClient
+	if cfg.RedisSharedDB != cfg.RedisDB {
+		sharedRedis = repository.NewRedisClient(cfg.GetRedisURL(), cfg.RedisPassword, cfg.RedisSharedDB)
+		defer sharedRedis.Close()
+	}
+	keys := cache.NewKeyer(cfg.RedisKeyPrefix)
+
 	log.Info("Redis connection established")
 
 	// 6. Initialize RabbitMQ publisher
@@ -66,28 +93,60 @@ func main() {
 	log.Info("RabbitMQ connection established")
 
 	// 7. Initialize HTTP clients for inter-service communication
+	jwtKeys, err := auth.NewKeyring(cfg.JWTKeyID, cfg.JWTSecret, cfg.JWTPreviousSecrets)
+	if err != nil {
+		log.Fatal("Invalid JWT configuration", zap.Error(err))
+	}
+	// Product Service's stock routes only accept authenticated callers
+	serviceTokens, err := auth.NewServiceTokens(cfg.ServiceName, jwtKeys, cfg.JWTAlgorithms, 15*time.Minute)
+	if err != nil {
+		log.Fatal("Invalid JWT configuration", zap.Error(err))
+	}
 	userServiceClient := service.NewHTTPClient(cfg.UserServiceURL, 10*time.Second)
-	productServiceClient := service.NewHTTPClient(cfg.ProductServiceURL, 10*time.Second)
+	productServiceClient := service.NewProductClient(cfg.ProductServiceURL, 10*time.Second, serviceTokens)
 
 	// 8. Initialize layers
-	orderRepo := repository.NewOrderRepository(db, redisClient)
+	orderRepo := repository.NewOrderRepository(db, redisClient, keys)
 	orderService := service.NewOrderService(
 		orderRepo,
 		userServiceClient,
 		productServiceClient,
 		publisher,
+		service.NewMockPaymentProcessor(), // Until a real payment provider adapter exists
+		cfg.MaxItemQuantity,
 		log.Logger,
 	)
-	orderHandler := handlers.NewOrderHandler(orderService, log.Logger)
+	paging := pagination.Limits{DefaultPageSize: cfg.DefaultPageSize, MaxPageSize: cfg.MaxPageSize}
+	orderHandler := handlers.NewOrderHandler(orderService, paging, log.Logger)
+
+	// Publish events queued in the outbox
+	workerCtx, stopWorker := context.WithCancel(context.Background())
+	defer stopWorker()
+	outboxWorker := service.NewOutboxWorker(orderRepo, publisher, cfg.OutboxPollInterval, log.Logger)
+	go outboxWorker.Run(workerCtx)
+
+	// Cancel pending orders whose stock reservation has expired
+	sweeper := service.NewReservationSweeper(orderRepo, orderService, cfg.OrderReservationTTL, cfg.ReservationSweepInterval, log.Logger)
+	go sweeper.Run(workerCtx)
 
 	// 9. Set up router
 	if cfg.IsProduction() {
 		gin.SetMode(gin.ReleaseMode)
 	}
-	router := gin.Default()
+	// gin.New instead of gin.Default: requests are logged as structured JSON and
+	// panics answered with the usual APIResponse body
+	router := gin.New()
+	router.Use(middleware.RequestID(), middleware.AccessLog(log.Logger), middleware.Recovery(log.Logger))
+
+	// Cap request bodies so one oversized payload can't exhaust memory
+	router.Use(middleware.BodyLimit(int64(cfg.MaxBodyBytes), nil))
+
+	// Block writes while maintenance mode is on (toggled via the admin API)
+	maintenance := middleware.NewMaintenanceMode(sharedRedis, keys, cfg.MaintenanceMode)
+	router.Use(maintenance.Middleware())
 
 	// 10. Register routes
-	setupRoutes(router, orderHandler)
+	setupRoutes(router, orderHandler, auth.Middleware(jwtKeys, cfg.JWTAlgorithms, auth.NewDenylist(sharedRedis, keys)))
 
 	// 11. Start server
 	srv := &http.Server{
@@ -108,8 +167,9 @@ func main() {
 	<-quit
 
 	log.Info("Shutting down server...")
+	stopWorker()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
@@ -119,7 +179,7 @@ func main() {
 	log.Info("Server exited")
 }
 
-func setupRoutes(router *gin.Engine, handler *handlers.OrderHandler) {
+func setupRoutes(router *gin.Engine, handler *handlers.OrderHandler, requireAuth gin.HandlerFunc) {
 	// Health checks
 	router.GET("/health", handler.HealthCheck)
 	router.GET("/ready", handler.ReadinessCheck)
@@ -131,11 +191,36 @@ func setupRoutes(router *gin.Engine, handler *handlers.OrderHandler) {
 		{
 			// All order endpoints require authentication
 			// In production, add AuthMiddleware here
-			orders.POST("", handler.CreateOrder)              // Create new order
-			orders.GET("", handler.ListUserOrders)            // Get user's orders
-			orders.GET("/:id", handler.GetOrderByID)          // Get single order
-			orders.PUT("/:id/cancel", handler.CancelOrder)    // Cancel order
-			orders.GET("/:id/status", handler.GetOrderStatus) // Get order status
+			orders.POST("", handler.CreateOrder)                // Create new order
+			orders.GET("", handler.ListUserOrders)              // Get user's orders
+			orders.GET("/:id", handler.GetOrderByID)            // Get single order
+			orders.PUT("/:id/cancel", handler.CancelOrder)      // Cancel order
+			orders.GET("/:id/status", handler.GetOrderStatus)   // Get order status
+			orders.GET("/:id/history", handler.GetOrderHistory) // Status timeline
+
+			// Account stats are per-user, so the caller must be authenticated
+			orders.GET("/stats", requireAuth, handler.GetUserStats)
+
+			// Batch lookups filter by the caller's identity and role from the JWT
+			orders.POST("/batch", requireAuth, handler.GetOrdersBatch)
+
+			// Fulfilment is restricted to staff roles, verified from the JWT
+			staff := []gin.HandlerFunc{requireAuth, auth.RequirePermission(auth.PermOrderFulfill)}
+			orders.PUT("/:id/ship", append(staff, handler.ShipOrder)...)
+			orders.PUT("/:id/status", append(staff, handler.UpdateOrderStatus)...)
+		}
+
+		// Admin-only tooling
+		admin := v1.Group("/admin")
+		admin.Use(requireAuth, auth.RequirePermission(auth.PermOrderManage))
+		{
+			admin.POST("/orders/:id/replay-events", handler.ReplayOrderEvents)
+		}
+
+		// Internal routes for other services; not exposed through the gateway
+		internal := v1.Group("/internal")
+		{
+			internal.GET("/purchases", handler.VerifyPurchase)
 		}
 	}
 }
@@ -2,71 +2,183 @@ package messaging
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/streadway/amqp"
 	"go.uber.org/zap"
+
+	"ecommerce/shared/messaging"
+	"ecommerce/shared/models"
 )
 
 // OrderEvent represents an order event to be published
 type OrderEvent struct {
-	OrderID    string    `json:"order_id"`
-	UserID     string    `json:"user_id"`
-	TotalPrice float64   `json:"total_price"`
-	Status     string    `json:"status"`
-	CreatedAt  time.Time `json:"created_at"`
+	EventID        string    `json:"event_id,omitempty"` // Outbox ID; the same on every redelivery of this event
+	Type           string    `json:"type"`               // e.g. "order.confirmed"; derived from Status if empty
+	OrderID        string    `json:"order_id"`
+	UserID         string    `json:"user_id"`
+	TotalPrice     float64   `json:"total_price"`
+	Status         string    `json:"status"`
+	TrackingNumber string    `json:"tracking_number,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	Replay         bool      `json:"replay,omitempty"` // Re-emitted by an admin; consumers may have seen it before
+
+	// Items lists the order's lines
+	Items []OrderItemEvent `json:"items,omitempty"`
+}
+
+// OrderItemEvent is one line of an order, as carried by OrderEvent
+type OrderItemEvent struct {
+	ProductID string  `json:"product_id"`
+	Quantity  int     `json:"quantity"`
+	Price     float64 `json:"price"` // Unit price at time of order
+}
+
+// NewOrderItemEvents converts order items for an OrderEvent
+func NewOrderItemEvents(items []models.OrderItem) []OrderItemEvent {
+	events := make([]OrderItemEvent, 0, len(items))
+	for _, item := range items {
+		events = append(events, OrderItemEvent{
+			ProductID: item.ProductID,
+			Quantity:  item.Quantity,
+			Price:     item.Price,
+		})
+	}
+	return events
 }
 
+// ErrNotConnected is returned while the publisher is reconnecting to RabbitMQ
+// The publish may be retried once the connection is back
+var ErrNotConnected = errors.New("not connected to RabbitMQ")
+
 // RabbitMQPublisher publishes messages to RabbitMQ
+// If the connection drops it redials in the background with backoff; until
+// then publishes fail with ErrNotConnected
 type RabbitMQPublisher struct {
+	url    string
+	logger *zap.Logger
+
+	mu      sync.RWMutex
 	conn    *amqp.Connection
-	channel *amqp.Channel
-	logger  *zap.Logger
+	channel *amqp.Channel // nil while disconnected
+	closed  bool
+	done    chan struct{} // Closed by Close to stop reconnecting
 }
 
 // NewRabbitMQPublisher creates a new RabbitMQ publisher
 func NewRabbitMQPublisher(url string, logger *zap.Logger) (*RabbitMQPublisher, error) {
+	p := &RabbitMQPublisher{
+		url:    url,
+		logger: logger,
+		done:   make(chan struct{}),
+	}
+	if err := p.connect(); err != nil {
+		return nil, err
+	}
+
+	logger.Info("RabbitMQ publisher initialized")
+
+	return p, nil
+}
+
+// connect dials RabbitMQ, declares the exchange and starts watching the
+// connection so it is re-established if it drops
+func (p *RabbitMQPublisher) connect() error {
 	// Connect to RabbitMQ
-	conn, err := amqp.Dial(url)
+	conn, err := amqp.Dial(p.url)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
 	}
 
 	// Create channel
 	channel, err := conn.Channel()
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("failed to open channel: %w", err)
+		return fmt.Errorf("failed to open channel: %w", err)
 	}
 
-	// Declare exchange (fanout = broadcast to all queues)
+	// Declare exchange (topic = routed by event type)
 	err = channel.ExchangeDeclare(
-		"orders", // name
-		"fanout", // type
-		true,     // durable
-		false,    // auto-deleted
-		false,    // internal
-		false,    // no-wait
-		nil,      // arguments
+		messaging.OrdersExchange,     // name
+		messaging.OrdersExchangeType, // type
+		true,                         // durable
+		false,                        // auto-deleted
+		false,                        // internal
+		false,                        // no-wait
+		nil,                          // arguments
 	)
 	if err != nil {
-		channel.Close()
 		conn.Close()
-		return nil, fmt.Errorf("failed to declare exchange: %w", err)
+		return fmt.Errorf("failed to declare exchange: %w", err)
 	}
 
-	logger.Info("RabbitMQ publisher initialized")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		conn.Close()
+		return ErrNotConnected
+	}
+	p.conn = conn
+	p.channel = channel
+
+	go p.watch(conn.NotifyClose(make(chan *amqp.Error, 1)), channel.NotifyClose(make(chan *amqp.Error, 1)))
 
-	return &RabbitMQPublisher{
-		conn:    conn,
-		channel: channel,
-		logger:  logger,
-	}, nil
+	return nil
+}
+
+// watch waits for the connection or channel to close and, unless Close
+// closed it, redials with exponential backoff until it succeeds
+func (p *RabbitMQPublisher) watch(connClosed, channelClosed chan *amqp.Error) {
+	var reason *amqp.Error
+	select {
+	case reason = <-connClosed:
+	case reason = <-channelClosed:
+	}
+	if reason == nil {
+		return // Closed by Close
+	}
+
+	p.logger.Warn("RabbitMQ connection lost; reconnecting", zap.Error(reason))
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.conn.Close() // A channel error leaves the connection open
+	p.conn = nil
+	p.channel = nil
+	p.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-p.done:
+			return
+		case <-time.After(messaging.ReconnectDelay(attempt)):
+		}
+
+		if err := p.connect(); err != nil {
+			if errors.Is(err, ErrNotConnected) {
+				return // Closed while dialing
+			}
+			p.logger.Warn("Failed to reconnect to RabbitMQ", zap.Int("attempt", attempt+1), zap.Error(err))
+			continue
+		}
+
+		p.logger.Info("Reconnected to RabbitMQ", zap.Int("attempts", attempt+1))
+		return
+	}
 }
 
 // PublishOrderEvent publishes an order event
 func (p *RabbitMQPublisher) PublishOrderEvent(event OrderEvent) error {
+	if event.Type == "" {
+		event.Type = "order." + event.Status
+	}
+
 	// Marshal event to JSON
 	body, err := json.Marshal(event)
 	if err != nil {
@@ -74,13 +186,21 @@ func (p *RabbitMQPublisher) PublishOrderEvent(event OrderEvent) error {
 	}
 
 	// Publish message
-	err = p.channel.Publish(
-		"orders", // exchange
-		"",       // routing key (ignored for fanout)
-		false,    // mandatory
-		false,    // immediate
+	p.mu.RLock()
+	channel := p.channel
+	p.mu.RUnlock()
+	if channel == nil {
+		return ErrNotConnected
+	}
+
+	err = channel.Publish(
+		messaging.OrdersExchange, // exchange
+		event.Type,               // routing key, e.g. "order.confirmed"
+		false,                    // mandatory
+		false,                    // immediate
 		amqp.Publishing{
 			ContentType: "application/json",
+			MessageId:   event.EventID,
 			Body:        body,
 			Timestamp:   time.Now(),
 		},
@@ -90,6 +210,7 @@ func (p *RabbitMQPublisher) PublishOrderEvent(event OrderEvent) error {
 	}
 
 	p.logger.Info("Order event published",
+		zap.String("type", event.Type),
 		zap.String("order_id", event.OrderID),
 		zap.String("status", event.Status),
 	)
@@ -97,8 +218,17 @@ func (p *RabbitMQPublisher) PublishOrderEvent(event OrderEvent) error {
 	return nil
 }
 
-// Close closes the RabbitMQ connection
+// Close closes the RabbitMQ connection and stops reconnecting
 func (p *RabbitMQPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+
 	if p.channel != nil {
 		p.channel.Close()
 	}
@@ -8,9 +8,13 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	_ "github.com/lib/pq"
+
+	"ecommerce/shared/database"
 )
 
-func NewPostgresDB(connStr string) (*sql.DB, error) {
+// NewPostgresDB opens a connection pool, retrying the initial ping for up to
+// connectTimeout (DB_CONNECT_TIMEOUT)
+func NewPostgresDB(connStr string, connectTimeout time.Duration) (*sql.DB, error) {
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -20,21 +24,20 @@ func NewPostgresDB(connStr string) (*sql.DB, error) {
 	db.SetMaxIdleConns(5)
 	db.SetConnMaxLifetime(5 * time.Minute)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	if err := db.PingContext(ctx); err != nil {
+	// Verify connection, waiting for Postgres if it is still starting
+	if err := database.PingWithRetry(db, connectTimeout); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
 	return db, nil
 }
 
-func NewRedisClient(addr, password string) *redis.Client {
+func NewRedisClient(addr, password string, db int) *redis.Client {
 	client := redis.NewClient(&redis.Options{
 		Addr:         addr,
 		Password:     password,
-		DB:           0,
+		DB:           db,
 		PoolSize:     10,
 		MinIdleConns: 5,
 		MaxRetries:   3,
@@ -53,6 +56,20 @@ func NewRedisClient(addr, password string) *redis.Client {
 	return client
 }
 
+// ExpectedIndexes are the indexes the queries rely on; keep in sync with the
+// CREATE INDEX migrations below (checked at startup when CHECK_INDEXES is set)
+var ExpectedIndexes = []string{
+	"idx_orders_user_id",
+	"idx_orders_status",
+	"idx_orders_created_at",
+	"idx_order_items_order_id",
+	"idx_order_items_product_id",
+	"idx_order_status_history_order",
+	"idx_order_outbox_event",
+	"idx_order_outbox_unpublished",
+	"idx_orders_pending_created",
+}
+
 func RunMigrations(db *sql.DB) error {
 	migrations := []string{
 		// Orders table
@@ -74,12 +91,48 @@ func RunMigrations(db *sql.DB) error {
 			price DECIMAL(10, 2) NOT NULL
 		)`,
 
+		// Tracking number is set once the order ships
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS tracking_number VARCHAR(100)`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMP`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_address JSONB`,
+
 		// Indexes for performance
 		`CREATE INDEX IF NOT EXISTS idx_orders_user_id ON orders(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_orders_status ON orders(status)`,
 		`CREATE INDEX IF NOT EXISTS idx_orders_created_at ON orders(created_at)`,
 		`CREATE INDEX IF NOT EXISTS idx_order_items_order_id ON order_items(order_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_order_items_product_id ON order_items(product_id)`,
+
+		// Status timeline, written in the same transaction as each status change
+		`CREATE TABLE IF NOT EXISTS order_status_history (
+			id VARCHAR(36) PRIMARY KEY,
+			order_id VARCHAR(36) NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+			status VARCHAR(50) NOT NULL,
+			changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			changed_by VARCHAR(36) NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_order_status_history_order ON order_status_history(order_id, changed_at)`,
+
+		// Transactional outbox: events are written with the change they describe
+		// and published to RabbitMQ by the outbox worker
+		`CREATE TABLE IF NOT EXISTS order_outbox (
+			id VARCHAR(36) PRIMARY KEY,
+			order_id VARCHAR(36) NOT NULL,
+			event_type VARCHAR(50) NOT NULL,
+			payload JSONB NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			published_at TIMESTAMP
+		)`,
+		// One event of each type per order, so a retried change can't enqueue it twice
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_order_outbox_event ON order_outbox(order_id, event_type)`,
+		`CREATE INDEX IF NOT EXISTS idx_order_outbox_unpublished ON order_outbox(created_at) WHERE published_at IS NULL`,
+		// Set on events that kept failing; the worker stops retrying them
+		`ALTER TABLE order_outbox ADD COLUMN IF NOT EXISTS parked_at TIMESTAMP`,
+
+		// Pending orders hold stock reservations; the sweeper expires stale ones
+		`CREATE INDEX IF NOT EXISTS idx_orders_pending_created ON orders(created_at) WHERE status = 'pending'`,
 	}
 
 	for i, migration := range migrations {
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// idempotencyTTL is how long an Idempotency-Key is remembered
+const idempotencyTTL = 24 * time.Hour
+
+// IdempotencyRecord is what's stored against an Idempotency-Key
+// OrderID is empty while the first request is still being processed
+type IdempotencyRecord struct {
+	RequestHash string `json:"request_hash"`
+	OrderID     string `json:"order_id"`
+}
+
+func (r *OrderRepository) idempotencyKey(userID, key string) string {
+	return r.keys.Key("idempotency:%s:%s", userID, key)
+}
+
+// ClaimIdempotencyKey reserves a key for a new request
+// If the key was already used it returns the stored record and false
+func (r *OrderRepository) ClaimIdempotencyKey(ctx context.Context, userID, key, requestHash string) (*IdempotencyRecord, bool, error) {
+	data, err := json.Marshal(IdempotencyRecord{RequestHash: requestHash})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to encode idempotency record: %w", err)
+	}
+
+	claimed, err := r.redis.SetNX(ctx, r.idempotencyKey(userID, key), data, idempotencyTTL).Result()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+	if claimed {
+		return nil, true, nil
+	}
+
+	stored, err := r.redis.Get(ctx, r.idempotencyKey(userID, key)).Result()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read idempotency key: %w", err)
+	}
+
+	var record IdempotencyRecord
+	if err := json.Unmarshal([]byte(stored), &record); err != nil {
+		return nil, false, fmt.Errorf("failed to decode idempotency record: %w", err)
+	}
+	return &record, false, nil
+}
+
+// CompleteIdempotencyKey stores the order created for a claimed key
+func (r *OrderRepository) CompleteIdempotencyKey(ctx context.Context, userID, key, requestHash, orderID string) error {
+	data, err := json.Marshal(IdempotencyRecord{RequestHash: requestHash, OrderID: orderID})
+	if err != nil {
+		return fmt.Errorf("failed to encode idempotency record: %w", err)
+	}
+	return r.redis.Set(ctx, r.idempotencyKey(userID, key), data, idempotencyTTL).Err()
+}
+
+// ReleaseIdempotencyKey forgets a claimed key so the request can be retried,
+// used when the first attempt failed
+func (r *OrderRepository) ReleaseIdempotencyKey(ctx context.Context, userID, key string) error {
+	return r.redis.Del(ctx, r.idempotencyKey(userID, key)).Err()
+}
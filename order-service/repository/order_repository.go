@@ -4,24 +4,65 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 
+	"ecommerce/shared/cache"
 	"ecommerce/shared/models"
 )
 
+var (
+	ErrOrderNotFound  = errors.New("order not found")
+	ErrStatusConflict = errors.New("order is not in the expected status")
+)
+
+// orderColumns is the column list scanned by scanOrder
+const orderColumns = `id, user_id, total_price, status, COALESCE(tracking_number, ''), delivered_at, shipping_address, created_at, updated_at`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanOrder scans a row selected with orderColumns, without items
+func scanOrder(row rowScanner) (*models.Order, error) {
+	var order models.Order
+	var address []byte
+	err := row.Scan(
+		&order.ID, &order.UserID, &order.TotalPrice, &order.Status,
+		&order.TrackingNumber, &order.DeliveredAt, &address, &order.CreatedAt, &order.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Orders placed before addresses were collected have none
+	if address != nil {
+		order.ShippingAddress = &models.Address{}
+		if err := json.Unmarshal(address, order.ShippingAddress); err != nil {
+			return nil, fmt.Errorf("invalid shipping address: %w", err)
+		}
+	}
+
+	return &order, nil
+}
+
 type OrderRepository struct {
 	db    *sql.DB
 	redis *redis.Client
+	keys  cache.Keyer
 }
 
-func NewOrderRepository(db *sql.DB, redisClient *redis.Client) *OrderRepository {
+func NewOrderRepository(db *sql.DB, redisClient *redis.Client, keys cache.Keyer) *OrderRepository {
 	return &OrderRepository{
 		db:    db,
 		redis: redisClient,
+		keys:  keys,
 	}
 }
 
@@ -40,13 +81,21 @@ func (r *OrderRepository) Create(ctx context.Context, order *models.Order) error
 	order.UpdatedAt = time.Now()
 	order.Status = "pending"
 
+	// Stored as JSONB; NULL when the order has no address
+	var address []byte
+	if order.ShippingAddress != nil {
+		if address, err = json.Marshal(order.ShippingAddress); err != nil {
+			return fmt.Errorf("failed to encode shipping address: %w", err)
+		}
+	}
+
 	// Insert order
 	orderQuery := `
-		INSERT INTO orders (id, user_id, total_price, status, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO orders (id, user_id, total_price, status, shipping_address, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 	`
 	_, err = tx.ExecContext(ctx, orderQuery,
-		order.ID, order.UserID, order.TotalPrice, order.Status,
+		order.ID, order.UserID, order.TotalPrice, order.Status, address,
 		order.CreatedAt, order.UpdatedAt,
 	)
 	if err != nil {
@@ -70,18 +119,26 @@ func (r *OrderRepository) Create(ctx context.Context, order *models.Order) error
 		}
 	}
 
+	// Start the status timeline
+	if err := recordStatus(ctx, tx, order.ID, order.Status, order.UserID, order.CreatedAt); err != nil {
+		return err
+	}
+
 	// Commit transaction
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
+	// A new order changes the user's stats
+	r.redis.Del(ctx, r.keys.Key("order_stats:%s", order.UserID))
+
 	return nil
 }
 
 // GetByID retrieves an order with its items
 func (r *OrderRepository) GetByID(ctx context.Context, id string) (*models.Order, error) {
 	// Try cache first
-	cacheKey := fmt.Sprintf("order:%s", id)
+	cacheKey := r.keys.Key("order:%s", id)
 	cached, err := r.redis.Get(ctx, cacheKey).Result()
 	if err == nil {
 		var order models.Order
@@ -92,16 +149,12 @@ func (r *OrderRepository) GetByID(ctx context.Context, id string) (*models.Order
 
 	// Get order
 	orderQuery := `
-		SELECT id, user_id, total_price, status, created_at, updated_at
+		SELECT ` + orderColumns + `
 		FROM orders WHERE id = $1
 	`
-	var order models.Order
-	err = r.db.QueryRowContext(ctx, orderQuery, id).Scan(
-		&order.ID, &order.UserID, &order.TotalPrice, &order.Status,
-		&order.CreatedAt, &order.UpdatedAt,
-	)
+	order, err := scanOrder(r.db.QueryRowContext(ctx, orderQuery, id))
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("order not found")
+		return nil, ErrOrderNotFound
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get order: %w", err)
@@ -135,101 +188,466 @@ func (r *OrderRepository) GetByID(ctx context.Context, id string) (*models.Order
 		r.redis.Set(ctx, cacheKey, data, 10*time.Minute)
 	}
 
-	return &order, nil
+	return order, nil
+}
+
+// OrderFilter narrows ListByUserID and CountByUserID; zero values mean "no filter"
+type OrderFilter struct {
+	Status string
+	From   *time.Time // Inclusive, on created_at
+	To     *time.Time // Inclusive, on created_at
 }
 
-// ListByUserID retrieves all orders for a user
-func (r *OrderRepository) ListByUserID(ctx context.Context, userID string, limit, offset int) ([]*models.Order, error) {
+// where builds the WHERE clause shared by ListByUserID and CountByUserID
+// Placeholders are numbered from the args collected so far, so clauses can be optional
+func (f OrderFilter) where(userID string) (string, []interface{}) {
+	where := ` WHERE user_id = $1`
+	args := []interface{}{userID}
+
+	if f.Status != "" {
+		args = append(args, f.Status)
+		where += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	if f.From != nil {
+		args = append(args, *f.From)
+		where += fmt.Sprintf(" AND created_at >= $%d", len(args))
+	}
+	if f.To != nil {
+		args = append(args, *f.To)
+		where += fmt.Sprintf(" AND created_at <= $%d", len(args))
+	}
+
+	return where, args
+}
+
+// ListByUserID retrieves a user's orders matching filter, newest first
+func (r *OrderRepository) ListByUserID(ctx context.Context, userID string, limit, offset int, filter OrderFilter) ([]*models.Order, error) {
+	where, args := filter.where(userID)
 	query := `
-		SELECT id, user_id, total_price, status, created_at, updated_at
+		SELECT ` + orderColumns + `
+		FROM orders` + where
+
+	query += fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	args = append(args, limit, offset)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orders: %w", err)
+	}
+	defer rows.Close()
+
+	var orders []*models.Order
+	var orderIDs []string
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan order: %w", err)
+		}
+		orders = append(orders, order)
+		orderIDs = append(orderIDs, order.ID)
+	}
+
+	// Fetch the whole page's items in one query instead of one per order
+	items, err := r.getItemsForOrders(ctx, orderIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range orders {
+		order.Items = items[order.ID]
+	}
+
+	return orders, nil
+}
+
+// CountByUserID returns how many of a user's orders match the same filter as ListByUserID
+func (r *OrderRepository) CountByUserID(ctx context.Context, userID string, filter OrderFilter) (int, error) {
+	where, args := filter.where(userID)
+
+	var total int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM orders`+where, args...).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count orders: %w", err)
+	}
+	return total, nil
+}
+
+// GetMultipleByIDs retrieves several orders and their items in two queries
+// IDs that don't exist are simply absent from the result
+func (r *OrderRepository) GetMultipleByIDs(ctx context.Context, ids []string) ([]*models.Order, error) {
+	if len(ids) == 0 {
+		return []*models.Order{}, nil
+	}
+
+	placeholders, args := inPlaceholders(ids)
+	query := fmt.Sprintf(`
+		SELECT %s
 		FROM orders
-		WHERE user_id = $1
+		WHERE id IN (%s)
 		ORDER BY created_at DESC
-		LIMIT $2 OFFSET $3
-	`
+	`, orderColumns, placeholders)
 
-	rows, err := r.db.QueryContext(ctx, query, userID, limit, offset)
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list orders: %w", err)
+		return nil, fmt.Errorf("failed to get orders: %w", err)
 	}
 	defer rows.Close()
 
 	var orders []*models.Order
+	var orderIDs []string
 	for rows.Next() {
-		var order models.Order
-		err := rows.Scan(
-			&order.ID, &order.UserID, &order.TotalPrice, &order.Status,
-			&order.CreatedAt, &order.UpdatedAt,
-		)
+		order, err := scanOrder(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan order: %w", err)
 		}
+		orders = append(orders, order)
+		orderIDs = append(orderIDs, order.ID)
+	}
+
+	items, err := r.getItemsForOrders(ctx, orderIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range orders {
+		order.Items = items[order.ID]
+	}
+
+	return orders, nil
+}
+
+// ListPendingBefore retrieves up to limit pending orders created before cutoff,
+// oldest first, with their items
+func (r *OrderRepository) ListPendingBefore(ctx context.Context, cutoff time.Time, limit int) ([]*models.Order, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM orders
+		WHERE status = 'pending' AND created_at < $1
+		ORDER BY created_at
+		LIMIT $2
+	`, orderColumns)
 
-		// Get items for this order
-		items, err := r.getOrderItems(ctx, order.ID)
+	rows, err := r.db.QueryContext(ctx, query, cutoff, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending orders: %w", err)
+	}
+	defer rows.Close()
+
+	var orders []*models.Order
+	var orderIDs []string
+	for rows.Next() {
+		order, err := scanOrder(rows)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to scan order: %w", err)
 		}
-		order.Items = items
+		orders = append(orders, order)
+		orderIDs = append(orderIDs, order.ID)
+	}
 
-		orders = append(orders, &order)
+	items, err := r.getItemsForOrders(ctx, orderIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range orders {
+		order.Items = items[order.ID]
 	}
 
 	return orders, nil
 }
 
-// UpdateStatus updates order status
-func (r *OrderRepository) UpdateStatus(ctx context.Context, orderID, status string) error {
+// UserStats returns aggregate order figures for a user, cached briefly
+func (r *OrderRepository) UserStats(ctx context.Context, userID string) (*models.OrderStats, error) {
+	cacheKey := r.keys.Key("order_stats:%s", userID)
+	cached, err := r.redis.Get(ctx, cacheKey).Result()
+	if err == nil {
+		var stats models.OrderStats
+		if err := json.Unmarshal([]byte(cached), &stats); err == nil {
+			return &stats, nil
+		}
+	}
+
+	query := `
+		SELECT COUNT(*),
+			COALESCE(SUM(total_price) FILTER (WHERE status NOT IN ('cancelled', 'payment_failed')), 0),
+			MAX(created_at)
+		FROM orders
+		WHERE user_id = $1
+	`
+
+	var stats models.OrderStats
+	var lastOrderAt sql.NullTime
+	err = r.db.QueryRowContext(ctx, query, userID).Scan(
+		&stats.TotalOrders, &stats.TotalSpent, &lastOrderAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order stats: %w", err)
+	}
+	if lastOrderAt.Valid {
+		stats.LastOrderAt = &lastOrderAt.Time
+	}
+
+	// Cache for 1 minute - stats tolerate slight staleness
+	if data, err := json.Marshal(stats); err == nil {
+		r.redis.Set(ctx, cacheKey, data, time.Minute)
+	}
+
+	return &stats, nil
+}
+
+// HasDeliveredProduct reports whether the user has a delivered order containing the product
+func (r *OrderRepository) HasDeliveredProduct(ctx context.Context, userID, productID string) (bool, error) {
+	query := `
+		SELECT EXISTS(
+			SELECT 1
+			FROM orders o
+			JOIN order_items oi ON oi.order_id = o.id
+			WHERE o.user_id = $1 AND oi.product_id = $2 AND o.status = 'delivered'
+		)
+	`
+
+	var exists bool
+	if err := r.db.QueryRowContext(ctx, query, userID, productID).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check purchase: %w", err)
+	}
+
+	return exists, nil
+}
+
+// TransitionStatus moves an order from one status to another, queueing event
+// (if non-nil) in the same transaction
+// Returns ErrStatusConflict if the order is no longer in the from status
+func (r *OrderRepository) TransitionStatus(ctx context.Context, orderID, from, to, changedBy string, event *OutboxMessage) error {
 	query := `
 		UPDATE orders
 		SET status = $1, updated_at = $2
-		WHERE id = $3
+		WHERE id = $3 AND status = $4
+		RETURNING user_id
+	`
+
+	now := time.Now()
+	return r.changeStatus(ctx, orderID, to, changedBy, now, event, ErrStatusConflict,
+		query, to, now, orderID, from)
+}
+
+// ConfirmOrder moves a pending order to confirmed and queues event, running
+// commit while the order row is locked so the reservation sweeper can't
+// cancel it and release its stock in between. If commit fails the order is
+// cancelled instead, in the same transaction, and commit's error is returned
+// Returns ErrStatusConflict, without calling commit, if the order is no longer pending
+func (r *OrderRepository) ConfirmOrder(ctx context.Context, orderID, changedBy string, event *OutboxMessage, commit func(ctx context.Context) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID string
+	err = tx.QueryRowContext(ctx,
+		`SELECT user_id FROM orders WHERE id = $1 AND status = 'pending' FOR UPDATE`,
+		orderID,
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return ErrStatusConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock order: %w", err)
+	}
+
+	status := "confirmed"
+	commitErr := commit(ctx)
+	if commitErr != nil {
+		status = "cancelled"
+		event = nil
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx,
+		`UPDATE orders SET status = $1, updated_at = $2 WHERE id = $3`,
+		status, now, orderID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update order status: %w", err)
+	}
+
+	if err := recordStatus(ctx, tx, orderID, status, changedBy, now); err != nil {
+		return err
+	}
+
+	if event != nil {
+		if err := insertOutbox(ctx, tx, event); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	r.invalidate(ctx, orderID, userID)
+
+	return commitErr
+}
+
+// MarkShipped sets a confirmed order's status to shipped, records its tracking
+// number and queues event, all in one transaction
+// The status guard is in SQL so a concurrent cancel can't be overwritten
+func (r *OrderRepository) MarkShipped(ctx context.Context, orderID, trackingNumber, changedBy string, event *OutboxMessage) error {
+	query := `
+		UPDATE orders
+		SET status = 'shipped', tracking_number = $1, updated_at = $2
+		WHERE id = $3 AND status = 'confirmed'
+		RETURNING user_id
+	`
+
+	now := time.Now()
+	return r.changeStatus(ctx, orderID, "shipped", changedBy, now, event, ErrStatusConflict,
+		query, trackingNumber, now, orderID)
+}
+
+// MarkDelivered moves a shipped order to delivered, stamps delivered_at and
+// queues event in the same transaction
+func (r *OrderRepository) MarkDelivered(ctx context.Context, orderID string, deliveredAt time.Time, changedBy string, event *OutboxMessage) error {
+	query := `
+		UPDATE orders
+		SET status = 'delivered', delivered_at = $1, updated_at = $1
+		WHERE id = $2 AND status = 'shipped'
+		RETURNING user_id
 	`
 
-	result, err := r.db.ExecContext(ctx, query, status, time.Now(), orderID)
+	return r.changeStatus(ctx, orderID, "delivered", changedBy, deliveredAt, event, ErrStatusConflict,
+		query, deliveredAt, orderID)
+}
+
+// changeStatus runs a status-changing UPDATE (which must return user_id) and
+// records the change in order_status_history in the same transaction, along
+// with event in the outbox when it is non-nil
+// noRows is returned when the UPDATE matches nothing
+func (r *OrderRepository) changeStatus(ctx context.Context, orderID, status, changedBy string, changedAt time.Time, event *OutboxMessage, noRows error, query string, args ...interface{}) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID string
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return noRows
+	}
 	if err != nil {
 		return fmt.Errorf("failed to update order status: %w", err)
 	}
 
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("order not found")
+	if err := recordStatus(ctx, tx, orderID, status, changedBy, changedAt); err != nil {
+		return err
+	}
+
+	if event != nil {
+		if err := insertOutbox(ctx, tx, event); err != nil {
+			return err
+		}
 	}
 
-	// Invalidate cache
-	cacheKey := fmt.Sprintf("order:%s", orderID)
-	r.redis.Del(ctx, cacheKey)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	r.invalidate(ctx, orderID, userID)
 
 	return nil
 }
 
-// getOrderItems retrieves items for an order (helper method)
-func (r *OrderRepository) getOrderItems(ctx context.Context, orderID string) ([]models.OrderItem, error) {
+// recordStatus appends an entry to an order's status timeline
+func recordStatus(ctx context.Context, tx *sql.Tx, orderID, status, changedBy string, changedAt time.Time) error {
 	query := `
-		SELECT id, order_id, product_id, quantity, price
-		FROM order_items WHERE order_id = $1
+		INSERT INTO order_status_history (id, order_id, status, changed_at, changed_by)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	_, err := tx.ExecContext(ctx, query, uuid.New().String(), orderID, status, changedAt, changedBy)
+	if err != nil {
+		return fmt.Errorf("failed to record status history: %w", err)
+	}
+	return nil
+}
+
+// GetStatusHistory returns an order's status changes, oldest first
+func (r *OrderRepository) GetStatusHistory(ctx context.Context, orderID string) ([]*models.OrderStatusChange, error) {
+	query := `
+		SELECT id, order_id, status, changed_at, changed_by
+		FROM order_status_history
+		WHERE order_id = $1
+		ORDER BY changed_at, id
 	`
 
 	rows, err := r.db.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status history: %w", err)
+	}
+	defer rows.Close()
+
+	var history []*models.OrderStatusChange
+	for rows.Next() {
+		var change models.OrderStatusChange
+		if err := rows.Scan(&change.ID, &change.OrderID, &change.Status, &change.ChangedAt, &change.ChangedBy); err != nil {
+			return nil, fmt.Errorf("failed to scan status history: %w", err)
+		}
+		history = append(history, &change)
+	}
+
+	return history, rows.Err()
+}
+
+// invalidate drops the cached order and its owner's stats after a status change
+func (r *OrderRepository) invalidate(ctx context.Context, orderID, userID string) {
+	r.redis.Del(ctx, r.keys.Key("order:%s", orderID), r.keys.Key("order_stats:%s", userID))
+}
+
+// getItemsForOrders retrieves the items of several orders in one query, keyed by order ID
+func (r *OrderRepository) getItemsForOrders(ctx context.Context, orderIDs []string) (map[string][]models.OrderItem, error) {
+	items := make(map[string][]models.OrderItem, len(orderIDs))
+	if len(orderIDs) == 0 {
+		return items, nil
+	}
+
+	placeholders, args := inPlaceholders(orderIDs)
+	query := fmt.Sprintf(`
+		SELECT id, order_id, product_id, quantity, price
+		FROM order_items WHERE order_id IN (%s)
+	`, placeholders)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get order items: %w", err)
 	}
 	defer rows.Close()
 
-	var items []models.OrderItem
 	for rows.Next() {
 		var item models.OrderItem
 		err := rows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.Quantity, &item.Price)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan order item: %w", err)
 		}
-		items = append(items, item)
+		items[item.OrderID] = append(items[item.OrderID], item)
 	}
 
 	return items, nil
 }
 
+// inPlaceholders builds "$1,$2,..." and the matching args for an IN clause
+func inPlaceholders(ids []string) (string, []interface{}) {
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = id
+	}
+	return strings.Join(placeholders, ","), args
+}
+
 // HealthCheck verifies database connectivity
 func (r *OrderRepository) HealthCheck(ctx context.Context) error {
 	return r.db.PingContext(ctx)
 }
+
+// CacheHealthCheck pings Redis
+func (r *OrderRepository) CacheHealthCheck(ctx context.Context) error {
+	return r.redis.Ping(ctx).Err()
+}
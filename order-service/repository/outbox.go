@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OutboxMessage is an order event waiting in order_outbox to be published
+// ID is unique per event and is sent along with it, so consumers can drop duplicates
+type OutboxMessage struct {
+	ID        string
+	OrderID   string
+	EventType string
+	Payload   []byte // JSON-encoded event
+	Attempts  int
+	CreatedAt time.Time
+}
+
+// insertOutbox writes an event in the caller's transaction, so it is stored
+// if and only if the change it describes is committed
+// An order records each event type once; a second insert is ignored
+func insertOutbox(ctx context.Context, tx *sql.Tx, msg *OutboxMessage) error {
+	msg.ID = uuid.New().String()
+	msg.CreatedAt = time.Now()
+
+	query := `
+		INSERT INTO order_outbox (id, order_id, event_type, payload, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (order_id, event_type) DO NOTHING
+	`
+	_, err := tx.ExecContext(ctx, query, msg.ID, msg.OrderID, msg.EventType, msg.Payload, msg.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to write outbox event: %w", err)
+	}
+	return nil
+}
+
+// OutboxBatch is a set of unpublished events claimed by one worker
+// The rows stay locked until Commit or Rollback, so other workers skip them;
+// the outcomes recorded with MarkPublished and MarkFailed are saved on Commit
+type OutboxBatch struct {
+	tx       *sql.Tx
+	Messages []*OutboxMessage
+}
+
+// ClaimOutbox locks up to limit unpublished, unparked events, oldest first
+// Rows another worker has claimed are skipped rather than waited for
+func (r *OrderRepository) ClaimOutbox(ctx context.Context, limit int) (*OutboxBatch, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	query := `
+		SELECT id, order_id, event_type, payload, attempts, created_at
+		FROM order_outbox
+		WHERE published_at IS NULL AND parked_at IS NULL
+		ORDER BY created_at
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`
+
+	rows, err := tx.QueryContext(ctx, query, limit)
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to claim outbox events: %w", err)
+	}
+	defer rows.Close()
+
+	batch := &OutboxBatch{tx: tx}
+	for rows.Next() {
+		msg := &OutboxMessage{}
+		if err := rows.Scan(&msg.ID, &msg.OrderID, &msg.EventType, &msg.Payload, &msg.Attempts, &msg.CreatedAt); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to scan outbox event: %w", err)
+		}
+		batch.Messages = append(batch.Messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to claim outbox events: %w", err)
+	}
+
+	return batch, nil
+}
+
+// MarkPublished records that an event reached the broker
+func (b *OutboxBatch) MarkPublished(ctx context.Context, id string) error {
+	query := `UPDATE order_outbox SET published_at = $1 WHERE id = $2`
+	if _, err := b.tx.ExecContext(ctx, query, time.Now(), id); err != nil {
+		return fmt.Errorf("failed to mark outbox event published: %w", err)
+	}
+	return nil
+}
+
+// MarkFailed records a failed publish attempt. The event stays queued unless
+// park is set, which takes it out of the queue for an operator to look at
+func (b *OutboxBatch) MarkFailed(ctx context.Context, id string, publishErr error, park bool) error {
+	query := `
+		UPDATE order_outbox
+		SET attempts = attempts + 1, last_error = $1,
+			parked_at = CASE WHEN $2 THEN $3::timestamp END
+		WHERE id = $4
+	`
+	if _, err := b.tx.ExecContext(ctx, query, publishErr.Error(), park, time.Now(), id); err != nil {
+		return fmt.Errorf("failed to record outbox failure: %w", err)
+	}
+	return nil
+}
+
+// Commit saves the recorded outcomes and releases the claimed rows
+func (b *OutboxBatch) Commit() error {
+	if err := b.tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit outbox batch: %w", err)
+	}
+	return nil
+}
+
+// Rollback releases the claimed rows without saving anything; it does
+// nothing after Commit
+func (b *OutboxBatch) Rollback() {
+	b.tx.Rollback()
+}
@@ -3,38 +3,78 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
 
 	"ecommerce/order-service/messaging"
 	"ecommerce/order-service/repository"
+	apperrors "ecommerce/shared/errors"
 	"ecommerce/shared/models"
+	"ecommerce/shared/pagination"
 )
 
 var (
-	ErrOrderNotFound     = errors.New("order not found")
-	ErrInvalidOrder      = errors.New("invalid order data")
-	ErrProductNotFound   = errors.New("product not found")
-	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrOrderNotFound       = apperrors.NotFound("order_not_found", "order not found")
+	ErrOrderForbidden      = apperrors.Forbidden("order_forbidden", "unauthorized access to order")
+	ErrInvalidOrder        = apperrors.Validation("invalid_order", "invalid order data")
+	ErrUserIDRequired      = apperrors.Validation("user_id_required", "user ID is required")
+	ErrProductNotFound     = apperrors.Validation("product_not_found", "product not found")
+	ErrInsufficientStock   = apperrors.Validation("insufficient_stock", "insufficient stock")
+	ErrProductUnavailable  = apperrors.Validation("product_unavailable", "product is not available for ordering")
+	ErrTrackingRequired    = apperrors.Validation("tracking_required", "tracking number is required")
+	ErrUnsupportedStatus   = apperrors.Validation("unsupported_status", "unsupported status update")
+	ErrPurchaseCheckParams = apperrors.Validation("purchase_check_params", "user_id and product_id are required")
+	ErrInvalidTotal        = apperrors.Validation("invalid_total", "invalid order total")
+	ErrQuantityTooLarge    = apperrors.Validation("quantity_too_large", "item quantity exceeds the per-item maximum")
+	ErrInvalidAddress      = apperrors.Validation("invalid_address", "invalid shipping address")
+	ErrNoOrderIDs          = apperrors.Validation("no_order_ids", "at least one order ID is required")
+	ErrTooManyOrderIDs     = apperrors.Validation("too_many_order_ids", fmt.Sprintf("at most %d orders can be fetched at once", maxBatchOrders))
+	ErrInvalidStatusFilter = apperrors.Validation("invalid_status_filter", "status must be one of: pending, confirmed, shipped, delivered, cancelled, payment_failed")
+	ErrInvalidDateRange    = apperrors.Validation("invalid_date_range", "from must not be after to")
+	ErrIdempotencyMismatch = apperrors.Conflict("idempotency_mismatch", "idempotency key was already used with a different request")
+	ErrIdempotencyPending  = apperrors.Conflict("idempotency_pending", "a request with this idempotency key is still being processed")
+	ErrOrderExpired        = apperrors.Conflict("order_expired", "order expired before payment completed; the payment has been voided")
+	ErrNothingToReplay     = apperrors.Conflict("nothing_to_replay", "pending and payment_failed orders have no event to replay")
+	ErrStockCommitFailed   = apperrors.Unavailable("stock_commit_failed", "stock could not be committed; the order was cancelled and the payment voided")
 )
 
+const (
+	// maxOrderTotal is the largest total the orders.total_price DECIMAL(10, 2) column can hold
+	maxOrderTotal = 99999999.99
+
+	// maxBatchOrders caps how many orders one batch lookup may request
+	maxBatchOrders = 50
+)
+
+// OrderFilter narrows order listings by status and creation date
+type OrderFilter = repository.OrderFilter
+
 type OrderService struct {
 	repo                 *repository.OrderRepository
 	userServiceClient    *http.Client
-	productServiceClient *http.Client
+	productServiceClient *ProductClient
 	publisher            *messaging.RabbitMQPublisher
+	payments             PaymentProcessor
+	maxItemQuantity      int
 	logger               *zap.Logger
 }
 
 func NewOrderService(
 	repo *repository.OrderRepository,
 	userClient *http.Client,
-	productClient *http.Client,
+	productClient *ProductClient,
 	publisher *messaging.RabbitMQPublisher,
+	payments PaymentProcessor,
+	maxItemQuantity int,
 	logger *zap.Logger,
 ) *OrderService {
 	return &OrderService{
@@ -42,10 +82,61 @@ func NewOrderService(
 		userServiceClient:    userClient,
 		productServiceClient: productClient,
 		publisher:            publisher,
+		payments:             payments,
+		maxItemQuantity:      maxItemQuantity,
 		logger:               logger,
 	}
 }
 
+// CreateOrderIdempotent creates an order at most once per idempotency key and user
+// A repeat of the same request returns the original order with replayed set;
+// reusing the key for a different request is rejected
+func (s *OrderService) CreateOrderIdempotent(ctx context.Context, userID, key string, req *models.CreateOrderRequest) (order *models.Order, replayed bool, err error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to encode request: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	requestHash := hex.EncodeToString(sum[:])
+
+	record, claimed, err := s.repo.ClaimIdempotencyKey(ctx, userID, key, requestHash)
+	if err != nil {
+		// Without Redis there is no dedup; creating the order beats failing checkout
+		s.logger.Warn("Idempotency check unavailable", zap.Error(err))
+		order, err := s.CreateOrder(ctx, userID, req)
+		return order, false, err
+	}
+
+	if !claimed {
+		if record.RequestHash != requestHash {
+			return nil, false, ErrIdempotencyMismatch
+		}
+		if record.OrderID == "" {
+			return nil, false, ErrIdempotencyPending
+		}
+		order, err := s.repo.GetByID(ctx, record.OrderID)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to get original order: %w", err)
+		}
+		return order, true, nil
+	}
+
+	order, err = s.CreateOrder(ctx, userID, req)
+	if err != nil {
+		// Let the client retry with the same key
+		if releaseErr := s.repo.ReleaseIdempotencyKey(ctx, userID, key); releaseErr != nil {
+			s.logger.Error("Failed to release idempotency key", zap.Error(releaseErr))
+		}
+		return nil, false, err
+	}
+
+	if err := s.repo.CompleteIdempotencyKey(ctx, userID, key, requestHash, order.ID); err != nil {
+		s.logger.Error("Failed to store idempotency key", zap.String("order_id", order.ID), zap.Error(err))
+	}
+
+	return order, false, nil
+}
+
 // CreateOrder creates a new order
 func (s *OrderService) CreateOrder(ctx context.Context, userID string, req *models.CreateOrderRequest) (*models.Order, error) {
 	s.logger.Info("Creating order", zap.String("user_id", userID))
@@ -55,7 +146,21 @@ func (s *OrderService) CreateOrder(ctx context.Context, userID string, req *mode
 		return nil, fmt.Errorf("user validation failed: %w", err)
 	}
 
-	// Step 2: Get product details - FIX HERE
+	// Reject impossible quantities before touching stock
+	for _, item := range req.Items {
+		if item.Quantity > s.maxItemQuantity {
+			return nil, fmt.Errorf("%w: product %s quantity %d, maximum %d",
+				ErrQuantityTooLarge, item.ProductID, item.Quantity, s.maxItemQuantity)
+		}
+	}
+
+	if req.ShippingAddress != nil {
+		if err := validateAddress(req.ShippingAddress); err != nil {
+			return nil, err
+		}
+	}
+
+	// Step 2: Get product details
 	productIDs := make([]string, len(req.Items))
 	for i, item := range req.Items {
 		productIDs[i] = item.ProductID
@@ -73,12 +178,17 @@ func (s *OrderService) CreateOrder(ctx context.Context, userID string, req *mode
 	for _, item := range req.Items {
 		product, exists := products[item.ProductID]
 		if !exists {
-			return nil, fmt.Errorf("product %s not found", item.ProductID)
+			return nil, fmt.Errorf("%w: %s", ErrProductNotFound, item.ProductID)
 		}
 
-		if product.Stock < item.Quantity {
-			return nil, fmt.Errorf("insufficient stock for %s: available=%d, requested=%d",
-				product.Name, product.Stock, item.Quantity)
+		// Draft, archived or deleted products can't be ordered
+		if product.Status != models.ProductStatusPublished {
+			return nil, fmt.Errorf("%w: product %s is %s", ErrProductUnavailable, item.ProductID, product.Status)
+		}
+
+		if product.Available() < item.Quantity {
+			return nil, fmt.Errorf("%w for %s: available=%d, requested=%d",
+				ErrInsufficientStock, product.Name, product.Available(), item.Quantity)
 		}
 
 		orderItem := models.OrderItem{
@@ -90,12 +200,17 @@ func (s *OrderService) CreateOrder(ctx context.Context, userID string, req *mode
 		totalPrice += product.Price * float64(item.Quantity)
 	}
 
+	if err := validateTotal(totalPrice); err != nil {
+		return nil, err
+	}
+
 	// Step 4: Create order
 	order := &models.Order{
-		UserID:     userID,
-		Items:      orderItems,
-		TotalPrice: totalPrice,
-		Status:     "pending",
+		UserID:          userID,
+		Items:           orderItems,
+		TotalPrice:      totalPrice,
+		Status:          StatusPending,
+		ShippingAddress: req.ShippingAddress,
 	}
 
 	if err := s.repo.Create(ctx, order); err != nil {
@@ -106,97 +221,460 @@ func (s *OrderService) CreateOrder(ctx context.Context, userID string, req *mode
 
 	// Step 5: Reserve stock
 	if err := s.reserveStock(ctx, order.Items); err != nil {
-		s.repo.UpdateStatus(ctx, order.ID, "cancelled")
+		if statusErr := s.repo.TransitionStatus(context.WithoutCancel(ctx), order.ID, StatusPending, StatusCancelled, ChangedBySystem, nil); statusErr != nil {
+			s.logger.Error("Failed to cancel order", zap.String("order_id", order.ID), zap.Error(statusErr))
+		}
 		return nil, fmt.Errorf("failed to reserve stock: %w", err)
 	}
 
-	// Step 6: Update status
-	if err := s.repo.UpdateStatus(ctx, order.ID, "confirmed"); err != nil {
-		s.logger.Error("Failed to update order status", zap.Error(err))
+	// Step 6: Authorize payment; on failure the reservation is released
+	if err := s.authorizePayment(ctx, order); err != nil {
+		return nil, err
 	}
 
-	// Step 7: Publish event
-	go func() {
-		event := messaging.OrderEvent{
-			OrderID:    order.ID,
-			UserID:     userID,
-			TotalPrice: totalPrice,
-			Status:     "confirmed",
-			CreatedAt:  time.Now(),
-		}
-		if err := s.publisher.PublishOrderEvent(event); err != nil {
-			s.logger.Error("Failed to publish order event", zap.Error(err))
-		}
-	}()
+	// Step 7: Paid, so the reserved stock is sold. The order is confirmed and
+	// the confirmation event queued in the same transaction as the commit;
+	// the outbox worker publishes it, so a crash here can't lose the
+	// notification. From here on the client going away mustn't stop us
+	event, err := newOutboxMessage(newOrderEvent(order, StatusConfirmed, time.Now()))
+	if err != nil {
+		return nil, s.failConfirmation(ctx, order, nil, order.Items, nil, err)
+	}
+
+	confirmCtx := context.WithoutCancel(ctx)
+	committed, uncommitted := []models.OrderItem(nil), order.Items
+	var commitErr error
+	err = s.repo.ConfirmOrder(confirmCtx, order.ID, ChangedBySystem, event, func(ctx context.Context) error {
+		committed, uncommitted, commitErr = s.commitStock(ctx, order.Items)
+		return commitErr
+	})
+	if err != nil {
+		return nil, s.failConfirmation(confirmCtx, order, committed, uncommitted, commitErr, err)
+	}
 
+	order.Status = StatusConfirmed
 	return order, nil
 }
 
+// failConfirmation undoes a paid order that could not be confirmed: the
+// payment is voided and the stock the order still holds is given back, the
+// committed units restored and the rest of the reservation released
+// commitErr is the stock commit's error, if that is what failed; err is what
+// confirming the order returned
+func (s *OrderService) failConfirmation(ctx context.Context, order *models.Order, committed, uncommitted []models.OrderItem, commitErr, err error) error {
+	ctx = context.WithoutCancel(ctx)
+	s.logger.Error("Failed to confirm order", zap.String("order_id", order.ID), zap.Error(err))
+
+	if voidErr := s.payments.Void(ctx, order.ID); voidErr != nil {
+		s.logger.Error("Failed to void payment", zap.String("order_id", order.ID), zap.Error(voidErr))
+	}
+
+	// The reservation sweeper cancelled the order and released its stock first
+	if errors.Is(err, repository.ErrStatusConflict) {
+		return ErrOrderExpired
+	}
+
+	// A failed commit already cancelled the order. Otherwise it must leave
+	// pending before its stock is touched, or the sweeper would release it twice
+	if commitErr == nil {
+		if statusErr := s.repo.TransitionStatus(ctx, order.ID, StatusPending, StatusCancelled, ChangedBySystem, nil); statusErr != nil {
+			s.logger.Error("Failed to cancel order", zap.String("order_id", order.ID), zap.Error(statusErr))
+			return fmt.Errorf("failed to confirm order: %w", err)
+		}
+	}
+	order.Status = StatusCancelled
+
+	if restoreErr := s.restoreStock(ctx, committed); restoreErr != nil {
+		s.logger.Error("Failed to restore committed stock", zap.String("order_id", order.ID), zap.Error(restoreErr))
+	}
+	s.compensateReservation(ctx, uncommitted)
+
+	if commitErr != nil {
+		return fmt.Errorf("%w: %v", ErrStockCommitFailed, commitErr)
+	}
+	return fmt.Errorf("failed to confirm order: %w", err)
+}
+
+// authorizePayment asks the payment processor to authorize an order's total
+// If it fails, the order's reserved stock is released and it is marked payment_failed
+func (s *OrderService) authorizePayment(ctx context.Context, order *models.Order) error {
+	err := s.payments.Authorize(ctx, order.ID, order.TotalPrice)
+	if err == nil {
+		return nil
+	}
+
+	s.logger.Warn("Payment authorization failed", zap.String("order_id", order.ID), zap.Error(err))
+
+	// Leave pending first so the reservation sweeper can't release the stock too
+	statusErr := s.repo.TransitionStatus(context.WithoutCancel(ctx), order.ID, StatusPending, StatusPaymentFailed, ChangedBySystem, nil)
+	if statusErr != nil {
+		s.logger.Error("Failed to mark payment failed", zap.String("order_id", order.ID), zap.Error(statusErr))
+	}
+	if !errors.Is(statusErr, repository.ErrStatusConflict) {
+		s.compensateReservation(ctx, order.Items)
+	}
+
+	if errors.Is(err, ErrPaymentDeclined) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrPaymentUnavailable, err)
+}
+
 // GetOrderByID retrieves an order by ID
 func (s *OrderService) GetOrderByID(ctx context.Context, orderID, userID string) (*models.Order, error) {
 	order, err := s.repo.GetByID(ctx, orderID)
-	if err != nil {
+	if errors.Is(err, repository.ErrOrderNotFound) {
 		return nil, ErrOrderNotFound
 	}
+	if err != nil {
+		return nil, err
+	}
 
 	if order.UserID != userID {
-		return nil, errors.New("unauthorized access to order")
+		return nil, ErrOrderForbidden
 	}
 
 	return order, nil
 }
 
-// ListUserOrders retrieves all orders for a user
-func (s *OrderService) ListUserOrders(ctx context.Context, userID string, page, pageSize int) ([]*models.Order, error) {
-	if page < 1 {
-		page = 1
+// unavailableProductName stands in for the name of a product that no longer exists
+const unavailableProductName = "Product no longer available"
+
+// ExpandProducts embeds each item's product details, fetched from Product
+// Service in one batch. Products deleted since the order get a placeholder
+// with status "deleted". If Product Service can't be reached the order is
+// left as it is, without product details, rather than failing the request
+func (s *OrderService) ExpandProducts(ctx context.Context, order *models.Order) {
+	ids := make([]string, 0, len(order.Items))
+	seen := make(map[string]bool, len(order.Items))
+	for _, item := range order.Items {
+		if !seen[item.ProductID] {
+			seen[item.ProductID] = true
+			ids = append(ids, item.ProductID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	products, err := s.productServiceClient.GetProducts(ctx, ids)
+	if err != nil {
+		s.logger.Warn("Failed to expand order products",
+			zap.String("order_id", order.ID),
+			zap.Error(err),
+		)
+		return
+	}
+
+	for i := range order.Items {
+		item := &order.Items[i]
+		product, ok := products[item.ProductID]
+		if !ok {
+			item.Product = &models.ProductSummary{
+				ID:     item.ProductID,
+				Name:   unavailableProductName,
+				Status: models.ProductStatusDeleted,
+			}
+			continue
+		}
+
+		item.Product = &models.ProductSummary{
+			ID:       product.ID,
+			Name:     product.Name,
+			Category: product.Category,
+			SKU:      product.SKU,
+			Status:   product.Status,
+		}
+		if len(product.Images) > 0 {
+			item.Product.ImageURL = product.Images[0].URL
+		}
 	}
-	if pageSize < 1 || pageSize > 50 {
-		pageSize = 20
+}
+
+// GetOrderHistory returns one of the caller's orders' status timeline, oldest first
+func (s *OrderService) GetOrderHistory(ctx context.Context, orderID, userID string) ([]*models.OrderStatusChange, error) {
+	if _, err := s.GetOrderByID(ctx, orderID, userID); err != nil {
+		return nil, err
 	}
 
-	offset := (page - 1) * pageSize
-	return s.repo.ListByUserID(ctx, userID, pageSize, offset)
+	history, err := s.repo.GetStatusHistory(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if history == nil {
+		history = []*models.OrderStatusChange{}
+	}
+	return history, nil
 }
 
-// CancelOrder cancels an order
+// GetOrdersBatch retrieves the requested orders the caller may see: their own,
+// or any order for admins. Missing or inaccessible IDs are skipped
+func (s *OrderService) GetOrdersBatch(ctx context.Context, ids []string, userID, role string) ([]*models.Order, error) {
+	if len(ids) == 0 {
+		return nil, ErrNoOrderIDs
+	}
+	if len(ids) > maxBatchOrders {
+		return nil, ErrTooManyOrderIDs
+	}
+
+	orders, err := s.repo.GetMultipleByIDs(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get orders: %w", err)
+	}
+
+	visible := make([]*models.Order, 0, len(orders))
+	for _, order := range orders {
+		if role == "admin" || order.UserID == userID {
+			visible = append(visible, order)
+		}
+	}
+
+	return visible, nil
+}
+
+// ListUserOrders retrieves a page of a user's orders and their total order count
+func (s *OrderService) ListUserOrders(ctx context.Context, userID string, page pagination.Page, filter OrderFilter) ([]*models.Order, int, error) {
+	if filter.Status != "" && !IsValidStatus(filter.Status) {
+		return nil, 0, ErrInvalidStatusFilter
+	}
+	if filter.From != nil && filter.To != nil && filter.From.After(*filter.To) {
+		return nil, 0, ErrInvalidDateRange
+	}
+
+	orders, err := s.repo.ListByUserID(ctx, userID, page.Size, page.Offset(), filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	if orders == nil {
+		orders = []*models.Order{} // Serialize as [] rather than null
+	}
+
+	total, err := s.repo.CountByUserID(ctx, userID, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return orders, total, nil
+}
+
+// GetUserStats returns order count, total spent and last order date for a user
+func (s *OrderService) GetUserStats(ctx context.Context, userID string) (*models.OrderStats, error) {
+	return s.repo.UserStats(ctx, userID)
+}
+
+// VerifyPurchase reports whether a user received the product in a delivered order
+// Used by the reviews feature to mark verified purchases
+func (s *OrderService) VerifyPurchase(ctx context.Context, userID, productID string) (bool, error) {
+	if userID == "" || productID == "" {
+		return false, ErrPurchaseCheckParams
+	}
+	return s.repo.HasDeliveredProduct(ctx, userID, productID)
+}
+
+// CancelOrder cancels one of the caller's orders
 func (s *OrderService) CancelOrder(ctx context.Context, orderID, userID string) error {
 	order, err := s.repo.GetByID(ctx, orderID)
-	if err != nil {
+	if errors.Is(err, repository.ErrOrderNotFound) {
 		return ErrOrderNotFound
 	}
+	if err != nil {
+		return err
+	}
 
 	if order.UserID != userID {
-		return errors.New("unauthorized")
+		return ErrOrderForbidden
 	}
 
-	if order.Status == "cancelled" {
-		return errors.New("order already cancelled")
-	}
-	if order.Status == "completed" {
-		return errors.New("cannot cancel completed order")
+	return s.cancelOrder(ctx, order, userID)
+}
+
+// cancelOrder cancels an order and returns its stock: a pending order's
+// reservation is released, a paid order's committed stock is restored
+func (s *OrderService) cancelOrder(ctx context.Context, order *models.Order, changedBy string) error {
+	paid := order.Status != StatusPending
+	if err := s.transition(ctx, order, StatusCancelled, changedBy); err != nil {
+		return err
 	}
 
-	if err := s.releaseStock(ctx, order.Items); err != nil {
+	// Stock is released only after the guarded status change, so two concurrent
+	// cancels can't both return it
+	release := s.releaseReservation
+	if paid {
+		release = s.restoreStock
+	}
+	if err := release(ctx, order.Items); err != nil {
 		s.logger.Error("Failed to release stock", zap.Error(err))
 	}
 
-	if err := s.repo.UpdateStatus(ctx, orderID, "cancelled"); err != nil {
-		return fmt.Errorf("failed to cancel order: %w", err)
+	return nil
+}
+
+// transition moves an order to a new status, enforcing the state machine, and
+// queues the event announcing it in the same transaction
+// The repository re-checks the current status so a concurrent change isn't overwritten
+func (s *OrderService) transition(ctx context.Context, order *models.Order, to, changedBy string) error {
+	if !CanTransition(order.Status, to) {
+		return &TransitionError{From: order.Status, To: to}
+	}
+
+	event, err := newOutboxMessage(newOrderEvent(order, to, time.Now()))
+	if err != nil {
+		return err
 	}
 
-	go func() {
-		event := messaging.OrderEvent{
-			OrderID: orderID,
-			UserID:  userID,
-			Status:  "cancelled",
+	if err := s.repo.TransitionStatus(ctx, order.ID, order.Status, to, changedBy, event); err != nil {
+		if errors.Is(err, repository.ErrStatusConflict) {
+			return &TransitionError{From: order.Status, To: to}
 		}
-		s.publisher.PublishOrderEvent(event)
-	}()
+		return fmt.Errorf("failed to update order status: %w", err)
+	}
 
+	order.Status = to
 	return nil
 }
 
+// ShipOrder marks a confirmed order as shipped and notifies the customer
+// changedBy is the staff member's user ID, recorded in the status history
+func (s *OrderService) ShipOrder(ctx context.Context, orderID, trackingNumber, changedBy string) (*models.Order, error) {
+	if trackingNumber == "" {
+		return nil, ErrTrackingRequired
+	}
+
+	order, err := s.repo.GetByID(ctx, orderID)
+	if errors.Is(err, repository.ErrOrderNotFound) {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+
+	if !CanTransition(order.Status, StatusShipped) {
+		return nil, &TransitionError{From: order.Status, To: StatusShipped}
+	}
+
+	shipped := newOrderEvent(order, StatusShipped, time.Now())
+	shipped.TrackingNumber = trackingNumber
+	event, err := newOutboxMessage(shipped)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.MarkShipped(ctx, orderID, trackingNumber, changedBy, event); err != nil {
+		if errors.Is(err, repository.ErrStatusConflict) {
+			return nil, &TransitionError{From: order.Status, To: StatusShipped}
+		}
+		return nil, fmt.Errorf("failed to ship order: %w", err)
+	}
+
+	order.Status = StatusShipped
+	order.TrackingNumber = trackingNumber
+
+	s.logger.Info("Order shipped",
+		zap.String("order_id", orderID),
+		zap.String("tracking_number", trackingNumber),
+	)
+
+	return order, nil
+}
+
+// UpdateOrderStatus applies a staff status update, enforcing the order state machine
+// Shipping needs a tracking number, so it goes through ShipOrder instead
+func (s *OrderService) UpdateOrderStatus(ctx context.Context, orderID, status, changedBy string) (*models.Order, error) {
+	// pending and payment_failed are only ever set by the service itself
+	if !IsValidStatus(status) || status == StatusPending || status == StatusPaymentFailed {
+		return nil, ErrUnsupportedStatus
+	}
+	if status == StatusShipped {
+		return nil, ErrTrackingRequired
+	}
+	if status == StatusDelivered {
+		return s.DeliverOrder(ctx, orderID, changedBy)
+	}
+
+	order, err := s.repo.GetByID(ctx, orderID)
+	if errors.Is(err, repository.ErrOrderNotFound) {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+
+	if status == StatusCancelled {
+		if err := s.cancelOrder(ctx, order, changedBy); err != nil {
+			return nil, err
+		}
+		return order, nil
+	}
+
+	if err := s.transition(ctx, order, status, changedBy); err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// DeliverOrder records delivery of a shipped order and notifies the customer
+func (s *OrderService) DeliverOrder(ctx context.Context, orderID, changedBy string) (*models.Order, error) {
+	order, err := s.repo.GetByID(ctx, orderID)
+	if errors.Is(err, repository.ErrOrderNotFound) {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+
+	if !CanTransition(order.Status, StatusDelivered) {
+		return nil, &TransitionError{From: order.Status, To: StatusDelivered}
+	}
+
+	deliveredAt := time.Now()
+	event, err := newOutboxMessage(newOrderEvent(order, StatusDelivered, deliveredAt))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.MarkDelivered(ctx, orderID, deliveredAt, changedBy, event); err != nil {
+		if errors.Is(err, repository.ErrStatusConflict) {
+			return nil, &TransitionError{From: order.Status, To: StatusDelivered}
+		}
+		return nil, fmt.Errorf("failed to deliver order: %w", err)
+	}
+
+	order.Status = StatusDelivered
+	order.DeliveredAt = &deliveredAt
+
+	s.logger.Info("Order delivered", zap.String("order_id", orderID))
+
+	return order, nil
+}
+
+// ReplayOrderEvents republishes the event for an order's current status, flagged
+// as a replay, for consumers that missed it or were added later
+// Pending and payment_failed orders never announced their status, so they
+// are rejected with ErrNothingToReplay
+func (s *OrderService) ReplayOrderEvents(ctx context.Context, orderID string) (*messaging.OrderEvent, error) {
+	order, err := s.repo.GetByID(ctx, orderID)
+	if errors.Is(err, repository.ErrOrderNotFound) {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+
+	if order.Status == StatusPending || order.Status == StatusPaymentFailed {
+		return nil, fmt.Errorf("%w: order is %s", ErrNothingToReplay, order.Status)
+	}
+
+	event := newOrderEvent(order, order.Status, time.Now())
+	event.Type = "order." + order.Status
+	event.Replay = true
+	if err := s.publisher.PublishOrderEvent(event); err != nil {
+		return nil, fmt.Errorf("failed to publish order event: %w", err)
+	}
+
+	return &event, nil
+}
+
 // GetOrderStatus retrieves order status
 func (s *OrderService) GetOrderStatus(ctx context.Context, orderID, userID string) (string, error) {
 	order, err := s.GetOrderByID(ctx, orderID, userID)
@@ -211,49 +689,159 @@ func (s *OrderService) HealthCheck(ctx context.Context) error {
 	return s.repo.HealthCheck(ctx)
 }
 
+// CacheHealthCheck verifies Redis; the service keeps working without it
+func (s *OrderService) CacheHealthCheck(ctx context.Context) error {
+	return s.repo.CacheHealthCheck(ctx)
+}
+
 // --- Helper methods ---
 
 func (s *OrderService) validateUser(ctx context.Context, userID string) error {
 	if userID == "" {
-		return errors.New("user ID is required")
+		return ErrUserIDRequired
 	}
 	return nil
 }
 
-// FIX: Updated to accept []string instead of the items struct
+// getProductDetails fetches the order's products from Product Service
+// Missing IDs are left out of the map so CreateOrder can report them per item
 func (s *OrderService) getProductDetails(ctx context.Context, productIDs []string) (map[string]*models.Product, error) {
-	products := make(map[string]*models.Product)
-
-	// In production, make actual HTTP call to Product Service
-	// For now, mock data
-	for _, id := range productIDs {
-		products[id] = &models.Product{
-			ID:    id,
-			Name:  "Product " + id,
-			Price: 99.99,
-			Stock: 100,
-		}
+	products, err := s.productServiceClient.GetProducts(ctx, productIDs)
+	if err != nil {
+		s.logger.Error("Failed to fetch products", zap.Error(err))
+		return nil, err
 	}
-
 	return products, nil
 }
 
+// reserveStock holds stock for each item in Product Service until the order
+// is paid (commitStock) or cancelled (releaseReservation)
+// If any reservation fails, exactly the ones already made are released
+// (compensation) before the error is returned, so no inventory leaks
 func (s *OrderService) reserveStock(ctx context.Context, items []models.OrderItem) error {
+	reserved := make([]models.OrderItem, 0, len(items))
 	for _, item := range items {
 		s.logger.Info("Reserving stock",
 			zap.String("product_id", item.ProductID),
 			zap.Int("quantity", item.Quantity),
 		)
+
+		if err := s.productServiceClient.ReserveStock(ctx, item.ProductID, item.Quantity); err != nil {
+			s.compensateReservation(ctx, reserved)
+			return err
+		}
+		reserved = append(reserved, item)
 	}
 	return nil
 }
 
-func (s *OrderService) releaseStock(ctx context.Context, items []models.OrderItem) error {
+// compensateReservation releases stock reserved by a failed order
+// It runs even if the request was cancelled, since the reservations were not;
+// failures are logged and the remaining items are still released
+func (s *OrderService) compensateReservation(ctx context.Context, reserved []models.OrderItem) {
+	if len(reserved) == 0 {
+		return
+	}
+
+	if err := s.releaseReservation(context.WithoutCancel(ctx), reserved); err != nil {
+		s.logger.Error("Failed to roll back stock reservation", zap.Error(err))
+	}
+}
+
+// commitStock takes a paid order's reserved stock out of stock, splitting
+// the items into those committed and those still only reserved
+func (s *OrderService) commitStock(ctx context.Context, items []models.OrderItem) (committed, uncommitted []models.OrderItem, err error) {
+	var errs []error
 	for _, item := range items {
-		s.logger.Info("Releasing stock",
+		if err := s.changeStock(ctx, []models.OrderItem{item}, "Committing stock", s.productServiceClient.CommitStock); err != nil {
+			errs = append(errs, err)
+			uncommitted = append(uncommitted, item)
+			continue
+		}
+		committed = append(committed, item)
+	}
+	return committed, uncommitted, errors.Join(errs...)
+}
+
+// releaseReservation gives back stock reserved by an order that was never paid
+func (s *OrderService) releaseReservation(ctx context.Context, items []models.OrderItem) error {
+	return s.changeStock(ctx, items, "Releasing stock reservation", s.productServiceClient.ReleaseStock)
+}
+
+// restoreStock returns a paid order's committed stock to Product Service
+func (s *OrderService) restoreStock(ctx context.Context, items []models.OrderItem) error {
+	return s.changeStock(ctx, items, "Restoring stock", func(ctx context.Context, productID string, quantity int) error {
+		return s.productServiceClient.UpdateStock(ctx, productID, quantity, models.StockReasonOrderRelease)
+	})
+}
+
+// changeStock applies a stock change to each item, logging it as action
+// It keeps going after a failure so one bad item doesn't strand the rest
+func (s *OrderService) changeStock(ctx context.Context, items []models.OrderItem, action string, change func(ctx context.Context, productID string, quantity int) error) error {
+	var errs []error
+	for _, item := range items {
+		s.logger.Info(action,
 			zap.String("product_id", item.ProductID),
 			zap.Int("quantity", item.Quantity),
 		)
+
+		if err := change(ctx, item.ProductID, item.Quantity); err != nil {
+			s.logger.Error(action+" failed",
+				zap.String("product_id", item.ProductID),
+				zap.Int("quantity", item.Quantity),
+				zap.Error(err),
+			)
+			errs = append(errs, fmt.Errorf("product %s: %w", item.ProductID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateAddress trims a shipping address and checks its required fields
+// line2 and region are optional since not every country uses them
+func validateAddress(address *models.Address) error {
+	for _, field := range []*string{
+		&address.Line1, &address.Line2, &address.City,
+		&address.Region, &address.PostalCode, &address.Country,
+	} {
+		*field = strings.TrimSpace(*field)
+	}
+
+	var missing []string
+	if address.Line1 == "" {
+		missing = append(missing, "line1")
+	}
+	if address.City == "" {
+		missing = append(missing, "city")
+	}
+	if address.PostalCode == "" {
+		missing = append(missing, "postal_code")
+	}
+	if address.Country == "" {
+		missing = append(missing, "country")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: missing %s", ErrInvalidAddress, strings.Join(missing, ", "))
+	}
+
+	address.Country = strings.ToUpper(address.Country)
+	if len(address.Country) != 2 {
+		return fmt.Errorf("%w: country must be a two-letter ISO 3166-1 code", ErrInvalidAddress)
+	}
+	return nil
+}
+
+// validateTotal rejects computed totals the orders table can't store
+// Adjustments such as discounts must floor the total at zero before this check
+func validateTotal(total float64) error {
+	if math.IsNaN(total) || math.IsInf(total, 0) {
+		return fmt.Errorf("%w: not a number", ErrInvalidTotal)
+	}
+	if total < 0 {
+		return fmt.Errorf("%w: %.2f is negative", ErrInvalidTotal, total)
+	}
+	if total > maxOrderTotal {
+		return fmt.Errorf("%w: %.2f exceeds maximum of %.2f", ErrInvalidTotal, total, maxOrderTotal)
 	}
 	return nil
 }
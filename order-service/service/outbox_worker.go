@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"ecommerce/order-service/messaging"
+	"ecommerce/order-service/repository"
+	"ecommerce/shared/models"
+)
+
+const (
+	// outboxBatchSize is how many events one poll publishes at most
+	outboxBatchSize = 100
+
+	// maxOutboxBackoff caps the wait between polls while publishing keeps failing
+	maxOutboxBackoff = time.Minute
+
+	// maxOutboxAttempts is how many times an event may fail to publish before
+	// it is parked, so one bad event can't be retried forever
+	maxOutboxAttempts = 10
+)
+
+// newOrderEvent describes order moving to status at the given time
+func newOrderEvent(order *models.Order, status string, at time.Time) messaging.OrderEvent {
+	return messaging.OrderEvent{
+		OrderID:        order.ID,
+		UserID:         order.UserID,
+		TotalPrice:     order.TotalPrice,
+		Status:         status,
+		TrackingNumber: order.TrackingNumber,
+		CreatedAt:      at,
+		Items:          messaging.NewOrderItemEvents(order.Items),
+	}
+}
+
+// newOutboxMessage encodes an order event for the outbox
+func newOutboxMessage(event messaging.OrderEvent) (*repository.OutboxMessage, error) {
+	if event.Type == "" {
+		event.Type = "order." + event.Status
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	return &repository.OutboxMessage{
+		OrderID:   event.OrderID,
+		EventType: event.Type,
+		Payload:   payload,
+	}, nil
+}
+
+// OutboxWorker publishes events from the order_outbox table to RabbitMQ
+// An event is marked published only after the broker accepts it, so delivery
+// is at-least-once: a crash between the two republishes it, and consumers
+// dedup on its event_id
+type OutboxWorker struct {
+	repo         *repository.OrderRepository
+	publisher    *messaging.RabbitMQPublisher
+	pollInterval time.Duration
+	logger       *zap.Logger
+}
+
+// NewOutboxWorker creates an outbox worker polling every pollInterval
+func NewOutboxWorker(repo *repository.OrderRepository, publisher *messaging.RabbitMQPublisher, pollInterval time.Duration, logger *zap.Logger) *OutboxWorker {
+	return &OutboxWorker{
+		repo:         repo,
+		publisher:    publisher,
+		pollInterval: pollInterval,
+		logger:       logger,
+	}
+}
+
+// Run polls the outbox until ctx is cancelled
+// After a failed poll the wait doubles, up to maxOutboxBackoff, and resets
+// once a poll succeeds
+func (w *OutboxWorker) Run(ctx context.Context) {
+	w.logger.Info("Outbox worker started", zap.Duration("poll_interval", w.pollInterval))
+
+	wait := w.pollInterval
+	for {
+		published, err := w.publishPending(ctx)
+		if err != nil {
+			w.logger.Error("Outbox publish failed", zap.Error(err), zap.Duration("retry_in", wait))
+			wait *= 2
+			if wait > maxOutboxBackoff {
+				wait = maxOutboxBackoff
+			}
+		} else {
+			wait = w.pollInterval
+		}
+
+		// A full batch means more are probably waiting
+		if err == nil && published == outboxBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			w.logger.Info("Outbox worker stopped")
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// publishPending claims and publishes one batch of unpublished events,
+// oldest first, and returns how many it claimed
+// A failed event doesn't hold up other orders' events, only the later ones
+// of its own order, so each order's events keep their order. Events that
+// keep failing are parked after maxOutboxAttempts; while RabbitMQ is
+// unreachable the batch stops without counting an attempt against anyone
+func (w *OutboxWorker) publishPending(ctx context.Context) (int, error) {
+	batch, err := w.repo.ClaimOutbox(ctx, outboxBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	defer batch.Rollback()
+
+	var errs []error
+	failedOrders := make(map[string]bool)
+	for _, msg := range batch.Messages {
+		if failedOrders[msg.OrderID] {
+			continue
+		}
+
+		err := w.publish(msg)
+		if errors.Is(err, messaging.ErrNotConnected) {
+			errs = append(errs, err)
+			break
+		}
+		if err != nil {
+			failedOrders[msg.OrderID] = true
+			errs = append(errs, fmt.Errorf("event %s: %w", msg.ID, err))
+
+			park := msg.Attempts+1 >= maxOutboxAttempts
+			if park {
+				w.logger.Error("Parking outbox event after repeated failures",
+					zap.String("event_id", msg.ID),
+					zap.String("order_id", msg.OrderID),
+					zap.String("event_type", msg.EventType),
+					zap.Error(err),
+				)
+			}
+			if err := batch.MarkFailed(ctx, msg.ID, err, park); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		if err := batch.MarkPublished(ctx, msg.ID); err != nil {
+			// The batch's events will be published again; consumers dedup on event_id
+			return 0, err
+		}
+	}
+
+	if err := batch.Commit(); err != nil {
+		return 0, err
+	}
+	return len(batch.Messages), errors.Join(errs...)
+}
+
+// publish decodes an outbox payload and sends it, tagged with its event ID
+func (w *OutboxWorker) publish(msg *repository.OutboxMessage) error {
+	var event messaging.OrderEvent
+	if err := json.Unmarshal(msg.Payload, &event); err != nil {
+		return fmt.Errorf("failed to decode event: %w", err)
+	}
+	event.EventID = msg.ID
+
+	return w.publisher.PublishOrderEvent(event)
+}
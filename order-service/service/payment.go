@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+
+	apperrors "ecommerce/shared/errors"
+)
+
+var (
+	// ErrPaymentDeclined means the processor refused the payment
+	ErrPaymentDeclined = apperrors.PaymentRequired("payment_declined", "payment declined")
+	// ErrPaymentUnavailable means the processor couldn't be reached or failed
+	ErrPaymentUnavailable = apperrors.Unavailable("payment_unavailable", "payment processor unavailable")
+)
+
+// PaymentProcessor authorizes payment for an order before it is confirmed
+// Implementations return ErrPaymentDeclined (possibly wrapped) when the
+// payment is refused; any other error is treated as the processor failing
+// Void gives back an authorization for an order that could not be confirmed
+type PaymentProcessor interface {
+	Authorize(ctx context.Context, orderID string, amount float64) error
+	Void(ctx context.Context, orderID string) error
+}
+
+// MockPaymentProcessor approves every payment; the default until a real
+// provider adapter is configured
+type MockPaymentProcessor struct{}
+
+// NewMockPaymentProcessor creates a payment processor that approves everything
+func NewMockPaymentProcessor() *MockPaymentProcessor {
+	return &MockPaymentProcessor{}
+}
+
+// Authorize approves the payment
+func (p *MockPaymentProcessor) Authorize(ctx context.Context, orderID string, amount float64) error {
+	return nil
+}
+
+// Void does nothing; no money was ever held
+func (p *MockPaymentProcessor) Void(ctx context.Context, orderID string) error {
+	return nil
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"ecommerce/shared/auth"
+	apperrors "ecommerce/shared/errors"
+	"ecommerce/shared/models"
+)
+
+// ErrProductServiceUnavailable means product-service couldn't be reached or
+// returned an unusable response
+var ErrProductServiceUnavailable = apperrors.Unavailable("product_service_unavailable", "product service unavailable")
+
+// ErrReservationMissing means product-service holds fewer reserved units than
+// an order tried to commit or release
+var ErrReservationMissing = apperrors.Conflict("reservation_missing", "stock reservation not found")
+
+// ProductClient calls Product Service over HTTP
+// Stock changes are authenticated with a service token from tokens
+type ProductClient struct {
+	baseURL    string
+	httpClient *http.Client
+	tokens     *auth.ServiceTokens
+}
+
+// NewProductClient creates a client for the product service at baseURL
+func NewProductClient(baseURL string, timeout time.Duration, tokens *auth.ServiceTokens) *ProductClient {
+	return &ProductClient{
+		baseURL:    baseURL,
+		httpClient: NewHTTPClient(baseURL, timeout),
+		tokens:     tokens,
+	}
+}
+
+// GetProducts fetches products by ID in one call to POST /api/v1/products/batch
+// IDs that don't exist are simply absent from the returned map
+func (c *ProductClient) GetProducts(ctx context.Context, ids []string) (map[string]*models.Product, error) {
+	body, err := json.Marshal(map[string][]string{"ids": ids})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/v1/products/batch", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// Covers connection failures and timeouts
+		return nil, fmt.Errorf("%w: %v", ErrProductServiceUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: batch lookup returned status %d", ErrProductServiceUnavailable, resp.StatusCode)
+	}
+
+	var apiResp struct {
+		Success bool              `json:"success"`
+		Data    []*models.Product `json:"data"`
+		Error   string            `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return nil, fmt.Errorf("%w: invalid response: %v", ErrProductServiceUnavailable, err)
+	}
+	if !apiResp.Success {
+		return nil, fmt.Errorf("%w: %s", ErrProductServiceUnavailable, apiResp.Error)
+	}
+
+	products := make(map[string]*models.Product, len(apiResp.Data))
+	for _, product := range apiResp.Data {
+		products[product.ID] = product
+	}
+
+	return products, nil
+}
+
+// maxStockRetryAfter caps how long a stock call waits on a 503's Retry-After
+// before its single retry; longer waits fail fast instead of stalling checkout
+const maxStockRetryAfter = 2 * time.Second
+
+// UpdateStock adjusts a product's stock via PUT /api/v1/products/:id/stock
+// Positive quantities return stock of cancelled paid orders; reason is
+// recorded in product-service's stock movement audit trail
+func (c *ProductClient) UpdateStock(ctx context.Context, productID string, quantity int, reason string) error {
+	return c.sendStockRequest(ctx, http.MethodPut, productID, "/stock",
+		map[string]interface{}{"quantity": quantity, "reason": reason}, ErrInsufficientStock)
+}
+
+// ReserveStock holds stock for an unpaid order via POST /api/v1/products/:id/stock/reserve
+func (c *ProductClient) ReserveStock(ctx context.Context, productID string, quantity int) error {
+	return c.sendStockRequest(ctx, http.MethodPost, productID, "/stock/reserve", map[string]int{"quantity": quantity}, ErrInsufficientStock)
+}
+
+// CommitStock takes a paid order's reserved stock out of stock via POST /api/v1/products/:id/stock/commit
+func (c *ProductClient) CommitStock(ctx context.Context, productID string, quantity int) error {
+	return c.sendStockRequest(ctx, http.MethodPost, productID, "/stock/commit", map[string]int{"quantity": quantity}, ErrReservationMissing)
+}
+
+// ReleaseStock gives back an unpaid order's reserved stock via POST /api/v1/products/:id/stock/release
+func (c *ProductClient) ReleaseStock(ctx context.Context, productID string, quantity int) error {
+	return c.sendStockRequest(ctx, http.MethodPost, productID, "/stock/release", map[string]int{"quantity": quantity}, ErrReservationMissing)
+}
+
+// sendStockRequest sends a stock change for one product and maps the status
+// to the service's errors, a 409 to conflictErr. A 503 carrying a short
+// Retry-After is retried once after waiting that long
+func (c *ProductClient) sendStockRequest(ctx context.Context, method, productID, path string, payload interface{}, conflictErr error) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	token, err := c.tokens.Token()
+	if err != nil {
+		return fmt.Errorf("failed to create service token: %w", err)
+	}
+
+	endpoint := c.baseURL + "/api/v1/products/" + url.PathEscape(productID) + path
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrProductServiceUnavailable, err)
+		}
+		resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return nil
+		case http.StatusConflict:
+			return fmt.Errorf("%w for product %s", conflictErr, productID)
+		case http.StatusNotFound:
+			return fmt.Errorf("%w: %s", ErrProductNotFound, productID)
+		case http.StatusServiceUnavailable:
+			wait, ok := retryAfter(resp.Header.Get("Retry-After"))
+			if attempt == 0 && ok && wait <= maxStockRetryAfter {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(wait):
+				}
+				continue
+			}
+		}
+		return fmt.Errorf("%w: stock update returned status %d", ErrProductServiceUnavailable, resp.StatusCode)
+	}
+}
+
+// retryAfter parses a Retry-After header given in seconds; HTTP dates aren't
+// used by product-service and are ignored
+func retryAfter(header string) (time.Duration, bool) {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
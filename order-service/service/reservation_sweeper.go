@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"ecommerce/order-service/repository"
+)
+
+// sweepBatchSize is how many expired orders one sweep cancels at most
+const sweepBatchSize = 100
+
+// ReservationSweeper cancels pending orders that have held their stock
+// reservation longer than the TTL, so abandoned checkouts don't keep
+// inventory out of sale
+type ReservationSweeper struct {
+	repo     *repository.OrderRepository
+	orders   *OrderService
+	ttl      time.Duration
+	interval time.Duration
+	logger   *zap.Logger
+}
+
+// NewReservationSweeper creates a sweeper expiring reservations older than ttl,
+// checked every interval
+func NewReservationSweeper(repo *repository.OrderRepository, orders *OrderService, ttl, interval time.Duration, logger *zap.Logger) *ReservationSweeper {
+	return &ReservationSweeper{
+		repo:     repo,
+		orders:   orders,
+		ttl:      ttl,
+		interval: interval,
+		logger:   logger,
+	}
+}
+
+// Run sweeps until ctx is cancelled
+func (w *ReservationSweeper) Run(ctx context.Context) {
+	w.logger.Info("Reservation sweeper started",
+		zap.Duration("ttl", w.ttl),
+		zap.Duration("interval", w.interval),
+	)
+
+	for {
+		swept, err := w.sweep(ctx)
+		if err != nil {
+			w.logger.Error("Reservation sweep failed", zap.Error(err))
+		} else if swept > 0 {
+			w.logger.Info("Expired stock reservations", zap.Int("orders", swept))
+		}
+
+		// A full batch means more are probably waiting
+		if err == nil && swept == sweepBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			w.logger.Info("Reservation sweeper stopped")
+			return
+		case <-time.After(w.interval):
+		}
+	}
+}
+
+// sweep cancels one batch of expired pending orders, oldest first
+// An order that moved on in the meantime (paid or cancelled by its owner) is
+// skipped: the guarded transition rejects it and its stock is left alone
+func (w *ReservationSweeper) sweep(ctx context.Context) (int, error) {
+	orders, err := w.repo.ListPendingBefore(ctx, time.Now().Add(-w.ttl), sweepBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	// Failures are returned rather than retried straight away, so an order
+	// that can't be cancelled waits for the next sweep
+	var errs []error
+	for _, order := range orders {
+		err := w.orders.cancelOrder(ctx, order, ChangedBySystem)
+		if err != nil && !errors.Is(err, ErrInvalidTransition) {
+			errs = append(errs, fmt.Errorf("order %s: %w", order.ID, err))
+		}
+	}
+
+	return len(orders), errors.Join(errs...)
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"fmt"
+
+	apperrors "ecommerce/shared/errors"
+)
+
+// Order statuses
+const (
+	StatusPending   = "pending"
+	StatusConfirmed = "confirmed"
+	StatusShipped   = "shipped"
+	StatusDelivered = "delivered"
+	StatusCancelled = "cancelled"
+
+	// StatusPaymentFailed is set by the service when payment authorization fails
+	StatusPaymentFailed = "payment_failed"
+)
+
+// ChangedBySystem is recorded in the status history for changes made by the
+// service itself, e.g. confirming an order once stock is reserved
+const ChangedBySystem = "system"
+
+// transitions lists the statuses each status may move to; delivered,
+// cancelled and payment_failed are final
+var transitions = map[string][]string{
+	StatusPending:       {StatusConfirmed, StatusCancelled, StatusPaymentFailed},
+	StatusConfirmed:     {StatusShipped, StatusCancelled},
+	StatusShipped:       {StatusDelivered},
+	StatusDelivered:     {},
+	StatusCancelled:     {},
+	StatusPaymentFailed: {},
+}
+
+// CanTransition reports whether an order may move from one status to another
+func CanTransition(from, to string) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidStatus reports whether status is a known order status
+func IsValidStatus(status string) bool {
+	_, ok := transitions[status]
+	return ok
+}
+
+// ErrInvalidTransition is what every TransitionError unwraps to
+var ErrInvalidTransition = apperrors.Conflict("invalid_transition", "invalid order status transition")
+
+// TransitionError is returned when an order can't move to the requested status
+type TransitionError struct {
+	From string
+	To   string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot change order status from %s to %s", e.From, e.To)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return ErrInvalidTransition
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"ecommerce/shared/binding"
+	"ecommerce/shared/models"
+)
+
+// categoryRequest is the editable part of a category
+type categoryRequest struct {
+	Name     string  `json:"name" binding:"required"`
+	Slug     string  `json:"slug"`      // Derived from the name if omitted
+	ParentID *string `json:"parent_id"` // Omit for a top-level category
+}
+
+func (r categoryRequest) toCategory() *models.Category {
+	return &models.Category{
+		Name:     r.Name,
+		Slug:     r.Slug,
+		ParentID: r.ParentID,
+	}
+}
+
+// ListCategories returns every category; clients build the tree from parent_id
+// GET /api/v1/categories
+func (h *ProductHandler) ListCategories(c *gin.Context) {
+	categories, err := h.service.ListCategories(c.Request.Context())
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    categories,
+	})
+}
+
+// GetCategory returns one category
+// GET /api/v1/categories/:id
+func (h *ProductHandler) GetCategory(c *gin.Context) {
+	category, err := h.service.GetCategory(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    category,
+	})
+}
+
+// CreateCategory adds a category
+// POST /api/v1/categories
+func (h *ProductHandler) CreateCategory(c *gin.Context) {
+	var req categoryRequest
+	if !binding.BindJSON(c, &req) {
+		return
+	}
+
+	category := req.toCategory()
+	if err := h.service.CreateCategory(c.Request.Context(), category); err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Message: "Category created successfully",
+		Data:    category,
+	})
+}
+
+// UpdateCategory replaces a category's name, slug and parent
+// PUT /api/v1/categories/:id
+func (h *ProductHandler) UpdateCategory(c *gin.Context) {
+	var req categoryRequest
+	if !binding.BindJSON(c, &req) {
+		return
+	}
+
+	category := req.toCategory()
+	category.ID = c.Param("id")
+	if err := h.service.UpdateCategory(c.Request.Context(), category); err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Category updated successfully",
+		Data:    category,
+	})
+}
+
+// DeleteCategory removes a category with no subcategories or products
+// DELETE /api/v1/categories/:id
+func (h *ProductHandler) DeleteCategory(c *gin.Context) {
+	if err := h.service.DeleteCategory(c.Request.Context(), c.Param("id")); err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Category deleted successfully",
+	})
+}
@@ -1,6 +1,10 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
@@ -9,17 +13,31 @@ import (
 	"go.uber.org/zap"
 
 	"ecommerce/product-service/service"
+	"ecommerce/shared/auth"
+	"ecommerce/shared/binding"
+	apperrors "ecommerce/shared/errors"
+	"ecommerce/shared/fields"
 	"ecommerce/shared/models"
+	"ecommerce/shared/pagination"
 )
 
+// productFields are the fields clients may select with ?fields=
+var productFields = fields.Of(models.Product{})
+
+// concurrentUpdateRetryAfter is the Retry-After, in seconds, sent with
+// ErrConcurrentUpdate
+const concurrentUpdateRetryAfter = 1
+
 type ProductHandler struct {
 	service *service.ProductService
+	paging  pagination.Limits
 	logger  *zap.Logger
 }
 
-func NewProductHandler(service *service.ProductService, logger *zap.Logger) *ProductHandler {
+func NewProductHandler(service *service.ProductService, paging pagination.Limits, logger *zap.Logger) *ProductHandler {
 	return &ProductHandler{
 		service: service,
+		paging:  paging,
 		logger:  logger,
 	}
 }
@@ -29,11 +47,7 @@ func NewProductHandler(service *service.ProductService, logger *zap.Logger) *Pro
 func (h *ProductHandler) CreateProduct(c *gin.Context) {
 	var product models.Product
 
-	if err := c.ShouldBindJSON(&product); err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Error:   "Invalid request: " + err.Error(),
-		})
+	if !binding.BindJSON(c, &product) {
 		return
 	}
 
@@ -41,11 +55,7 @@ func (h *ProductHandler) CreateProduct(c *gin.Context) {
 
 	created, err := h.service.CreateProduct(c.Request.Context(), &product)
 	if err != nil {
-		h.logger.Error("Failed to create product", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   err.Error(),
-		})
+		h.respondError(c, err)
 		return
 	}
 
@@ -63,30 +73,55 @@ func (h *ProductHandler) GetProductByID(c *gin.Context) {
 
 	product, err := h.service.GetProductByID(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.APIResponse{
+		h.respondError(c, err)
+		return
+	}
+
+	data, err := fields.Select(c, product, productFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
-			Error:   "Product not found",
+			Error:   err.Error(),
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Data:    product,
+		Data:    data,
 	})
 }
 
-// ListProducts lists products with pagination and optional category filter
-// GET /api/v1/products?page=1&page_size=20&category=Electronics
+// ListProducts lists products with pagination and optional category and price filters
+// GET /api/v1/products?page=1&page_size=20&category=Electronics&min_price=10&max_price=500&in_stock=true&fields=id,name,price
+// Passing cursor (empty for the first page) switches to cursor pagination:
+// GET /api/v1/products?cursor=<next_cursor>&page_size=20
 func (h *ProductHandler) ListProducts(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
-	category := c.Query("category")
+	page := h.paging.FromQuery(c)
+	filter, err := productFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	filter.Category = c.Query("category")
+
+	if cursor, ok := c.GetQuery("cursor"); ok {
+		h.listProductsByCursor(c, cursor, page.Size, filter)
+		return
+	}
 
-	products, err := h.service.ListProducts(c.Request.Context(), page, pageSize, category)
+	products, total, err := h.service.ListProducts(c.Request.Context(), page, filter)
 	if err != nil {
-		h.logger.Error("Failed to list products", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+		h.respondError(c, err)
+		return
+	}
+
+	data, err := fields.Select(c, products, productFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
 			Error:   err.Error(),
 		})
@@ -95,21 +130,70 @@ func (h *ProductHandler) ListProducts(c *gin.Context) {
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Data:    products,
+		Data:    models.NewPaginatedResponse(data, total, page.Number, page.Size),
+	})
+}
+
+// listProductsByCursor serves ListProducts in cursor mode; it reports no
+// total, since counting would cost what the cursor saves
+func (h *ProductHandler) listProductsByCursor(c *gin.Context, cursor string, size int, filter service.ProductFilter) {
+	after, err := pagination.DecodeCursor(cursor)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	products, next, err := h.service.ListProductsAfter(c.Request.Context(), after, size, filter)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	data, err := fields.Select(c, products, productFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: models.CursorResponse{
+			Items:      data,
+			PageSize:   size,
+			NextCursor: next,
+		},
 	})
 }
 
-// SearchProducts searches products by name
-// GET /api/v1/products/search?q=laptop&page=1&page_size=20
+// SearchProducts searches products by name, optionally within a price range
+// GET /api/v1/products/search?q=laptop&page=1&page_size=20&min_price=10&max_price=500&in_stock=true
 func (h *ProductHandler) SearchProducts(c *gin.Context) {
-	query := c.Query("q")
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	page := h.paging.FromQuery(c)
+	filter, err := productFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	filter.Search = c.Query("q")
+
+	products, total, err := h.service.ListProducts(c.Request.Context(), page, filter)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
 
-	products, err := h.service.SearchProducts(c.Request.Context(), query, page, pageSize)
+	data, err := fields.Select(c, products, productFields)
 	if err != nil {
-		h.logger.Error("Failed to search products", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
 			Error:   err.Error(),
 		})
@@ -118,58 +202,97 @@ func (h *ProductHandler) SearchProducts(c *gin.Context) {
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Data:    products,
+		Data:    models.NewPaginatedResponse(data, total, page.Number, page.Size),
 	})
 }
 
 // GetProductsByCategory retrieves products in a category
-// GET /api/v1/products/category/:category
+// GET /api/v1/products/category/:category?in_stock=true
 func (h *ProductHandler) GetProductsByCategory(c *gin.Context) {
-	category := c.Param("category")
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	page := h.paging.FromQuery(c)
+	filter, err := productFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	filter.Category = c.Param("category")
+
+	products, total, err := h.service.ListProducts(c.Request.Context(), page, filter)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
 
-	products, err := h.service.GetProductsByCategory(c.Request.Context(), category, page, pageSize)
+	data, err := fields.Select(c, products, productFields)
 	if err != nil {
-		h.logger.Error("Failed to get products by category", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
 			Error:   err.Error(),
 		})
 		return
 	}
 
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    models.NewPaginatedResponse(data, total, page.Number, page.Size),
+	})
+}
+
+// ListProductCategories returns the categories in use and their product counts
+// GET /api/v1/products/categories
+func (h *ProductHandler) ListProductCategories(c *gin.Context) {
+	categories, err := h.service.ListProductCategories(c.Request.Context())
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    categories,
+	})
+}
+
+// GetProductsBatch returns the products matching the given IDs, including their status
+// POST /api/v1/products/batch (used by order-service to validate order items)
+func (h *ProductHandler) GetProductsBatch(c *gin.Context) {
+	var req struct {
+		IDs []string `json:"ids" binding:"required"`
+	}
+
+	if !binding.BindJSON(c, &req) {
+		return
+	}
+
+	products, err := h.service.GetMultipleProducts(c.Request.Context(), req.IDs)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
 		Data:    products,
 	})
 }
 
-// UpdateProduct updates product information
+// UpdateProduct updates product information; stock in the body is ignored,
+// use the stock endpoints instead
 // PUT /api/v1/products/:id
 func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 	id := c.Param("id")
 
 	var updates models.Product
-	if err := c.ShouldBindJSON(&updates); err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Error:   "Invalid request: " + err.Error(),
-		})
+	if !binding.BindJSON(c, &updates) {
 		return
 	}
 
 	updated, err := h.service.UpdateProduct(c.Request.Context(), id, &updates)
 	if err != nil {
-		h.logger.Error("Failed to update product", zap.Error(err))
-		statusCode := http.StatusInternalServerError
-		if err == service.ErrProductNotFound {
-			statusCode = http.StatusNotFound
-		}
-		c.JSON(statusCode, models.APIResponse{
-			Success: false,
-			Error:   err.Error(),
-		})
+		h.respondError(c, err)
 		return
 	}
 
@@ -186,23 +309,16 @@ func (h *ProductHandler) UpdateStock(c *gin.Context) {
 	id := c.Param("id")
 
 	var req struct {
-		Quantity int `json:"quantity" binding:"required"`
+		Quantity int    `json:"quantity" binding:"required"`
+		Reason   string `json:"reason"` // order_reservation, order_release or manual (default)
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Error:   "Invalid request: " + err.Error(),
-		})
+	if !binding.BindJSON(c, &req) {
 		return
 	}
 
-	if err := h.service.UpdateStock(c.Request.Context(), id, req.Quantity); err != nil {
-		h.logger.Error("Failed to update stock", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   err.Error(),
-		})
+	if err := h.service.UpdateStock(c.Request.Context(), id, req.Quantity, req.Reason); err != nil {
+		h.respondError(c, err)
 		return
 	}
 
@@ -212,17 +328,92 @@ func (h *ProductHandler) UpdateStock(c *gin.Context) {
 	})
 }
 
+// SetStock sets a product's stock to an absolute count, e.g. after a stocktake
+// PUT /api/v1/products/:id/stock/set {"stock": 40}
+// 409 when unpaid orders have more than that reserved
+func (h *ProductHandler) SetStock(c *gin.Context) {
+	var req struct {
+		Stock *int `json:"stock" binding:"required"` // Pointer so 0 counts as given
+	}
+	if !binding.BindJSON(c, &req) {
+		return
+	}
+
+	if err := h.service.SetStock(c.Request.Context(), c.Param("id"), *req.Stock); err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Stock set successfully",
+	})
+}
+
+// ReserveStock holds stock for an unpaid order (order-service)
+// POST /api/v1/products/:id/stock/reserve {"quantity": 2}
+// 409 when the unreserved stock doesn't cover the quantity
+func (h *ProductHandler) ReserveStock(c *gin.Context) {
+	h.changeReservation(c, h.service.ReserveStock, "Stock reserved")
+}
+
+// CommitStock takes a paid order's reserved stock out of stock (order-service)
+// POST /api/v1/products/:id/stock/commit {"quantity": 2}
+func (h *ProductHandler) CommitStock(c *gin.Context) {
+	h.changeReservation(c, h.service.CommitStock, "Stock committed")
+}
+
+// ReleaseStock gives back stock reserved by an unpaid order (order-service)
+// POST /api/v1/products/:id/stock/release {"quantity": 2}
+func (h *ProductHandler) ReleaseStock(c *gin.Context) {
+	h.changeReservation(c, h.service.ReleaseStock, "Reservation released")
+}
+
+// changeReservation applies a reservation change to the product in the path
+func (h *ProductHandler) changeReservation(c *gin.Context, change func(ctx context.Context, productID string, quantity int) error, message string) {
+	var req struct {
+		Quantity int `json:"quantity" binding:"required,gt=0"`
+	}
+
+	if !binding.BindJSON(c, &req) {
+		return
+	}
+
+	if err := change(c.Request.Context(), c.Param("id"), req.Quantity); err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: message,
+	})
+}
+
+// GetStockHistory lists a product's stock movements, newest first (admin only)
+// GET /api/v1/products/:id/stock-history?page=1&page_size=20
+func (h *ProductHandler) GetStockHistory(c *gin.Context) {
+	page := h.paging.FromQuery(c)
+
+	movements, total, err := h.service.GetStockHistory(c.Request.Context(), c.Param("id"), page)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    models.NewPaginatedResponse(movements, total, page.Number, page.Size),
+	})
+}
+
 // DeleteProduct removes a product
 // DELETE /api/v1/products/:id
 func (h *ProductHandler) DeleteProduct(c *gin.Context) {
 	id := c.Param("id")
 
 	if err := h.service.DeleteProduct(c.Request.Context(), id); err != nil {
-		h.logger.Error("Failed to delete product", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   err.Error(),
-		})
+		h.respondError(c, err)
 		return
 	}
 
@@ -232,6 +423,131 @@ func (h *ProductHandler) DeleteProduct(c *gin.Context) {
 	})
 }
 
+// AddProductImage attaches an image to a product
+// POST /api/v1/products/:id/images
+func (h *ProductHandler) AddProductImage(c *gin.Context) {
+	var req struct {
+		URL      string `json:"url" binding:"required"`
+		Position *int   `json:"position"` // Omit to append after existing images
+	}
+
+	if !binding.BindJSON(c, &req) {
+		return
+	}
+
+	image, err := h.service.AddProductImage(c.Request.Context(), c.Param("id"), req.URL, req.Position)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Message: "Image added successfully",
+		Data:    image,
+	})
+}
+
+// DeleteProductImage removes an image from a product
+// DELETE /api/v1/products/:id/images/:imageId
+func (h *ProductHandler) DeleteProductImage(c *gin.Context) {
+	err := h.service.DeleteProductImage(c.Request.Context(), c.Param("id"), c.Param("imageId"))
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Image deleted successfully",
+	})
+}
+
+// BulkDeleteProducts soft-deletes a list of products (admin only)
+// DELETE /api/v1/admin/products
+func (h *ProductHandler) BulkDeleteProducts(c *gin.Context) {
+	var req struct {
+		IDs []string `json:"ids" binding:"required"`
+	}
+
+	if !binding.BindJSON(c, &req) {
+		return
+	}
+
+	results, err := h.service.BulkDeleteProducts(c.Request.Context(), req.IDs)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	// Audit trail: who removed what from the catalog
+	h.logger.Info("Products bulk deleted",
+		zap.String("admin_id", c.GetString(auth.ContextUserID)),
+		zap.Strings("product_ids", req.IDs),
+		zap.Any("results", results),
+	)
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Bulk delete completed",
+		Data:    results,
+	})
+}
+
+// respondError writes err with the status its type maps to. Untyped errors
+// are unexpected, so they are logged here and reported without detail
+func (h *ProductHandler) respondError(c *gin.Context, err error) {
+	status, response := apperrors.Response(err)
+	if errors.Is(err, service.ErrConcurrentUpdate) {
+		// Contention clears quickly; tell callers when retrying is worthwhile
+		c.Header("Retry-After", strconv.Itoa(concurrentUpdateRetryAfter))
+	}
+	if status >= http.StatusInternalServerError {
+		h.logger.Error("Request failed",
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.FullPath()),
+			zap.Error(err),
+		)
+	}
+	c.JSON(status, response)
+}
+
+// productFilter reads the optional min_price, max_price and in_stock query params
+func productFilter(c *gin.Context) (service.ProductFilter, error) {
+	var filter service.ProductFilter
+	var err error
+
+	if raw := c.Query("in_stock"); raw != "" {
+		if filter.InStock, err = strconv.ParseBool(raw); err != nil {
+			return filter, errors.New("in_stock must be true or false")
+		}
+	}
+
+	if filter.MinPrice, err = priceParam(c, "min_price"); err != nil {
+		return filter, err
+	}
+	if filter.MaxPrice, err = priceParam(c, "max_price"); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+// priceParam parses a price query param; nil means it wasn't given
+func priceParam(c *gin.Context, name string) (*float64, error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return nil, nil
+	}
+
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) || value < 0 {
+		return nil, fmt.Errorf("%s must be a non-negative number", name)
+	}
+
+	return &value, nil
+}
+
 // HealthCheck returns service health
 // GET /health
 func (h *ProductHandler) HealthCheck(c *gin.Context) {
@@ -250,6 +566,16 @@ func (h *ProductHandler) HealthCheck(c *gin.Context) {
 	}
 
 	response.Checks["database"] = "connected"
+
+	// Redis only backs caches and shared flags, so losing it degrades the
+	// service without taking it out of rotation
+	if err := h.service.CacheHealthCheck(c.Request.Context()); err != nil {
+		response.Status = "degraded"
+		response.Checks["redis"] = "disconnected"
+	} else {
+		response.Checks["redis"] = "connected"
+	}
+
 	c.JSON(http.StatusOK, response)
 }
 
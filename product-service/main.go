@@ -7,16 +7,21 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
 	"ecommerce/product-service/handlers"
+	"ecommerce/product-service/messaging"
 	"ecommerce/product-service/repository"
 	"ecommerce/product-service/service"
+	"ecommerce/shared/auth"
+	"ecommerce/shared/cache"
 	"ecommerce/shared/config"
+	"ecommerce/shared/database"
 	"ecommerce/shared/logger"
+	"ecommerce/shared/middleware"
+	"ecommerce/shared/pagination"
 )
 
 func main() {
@@ -30,13 +35,22 @@ func main() {
 	}
 	defer log.Sync()
 
+	// Refuse to start in production with insecure or missing settings
+	warnings, err := cfg.Validate()
+	if err != nil {
+		log.Fatal("Invalid configuration", zap.Error(err))
+	}
+	for _, warning := range warnings {
+		log.Warn("Configuration warning", zap.String("problem", warning))
+	}
+
 	log.Info("Starting Product Service",
 		zap.String("environment", cfg.Environment),
 		zap.String("port", cfg.Port),
 	)
 
 	// 3. Initialize database
-	db, err := repository.NewPostgresDB(cfg.GetDatabaseURL())
+	db, err := repository.NewPostgresDB(cfg.GetDatabaseURL(), cfg.DBConnectTimeout)
 	if err != nil {
 		log.Fatal("Failed to connect to database", zap.Error(err))
 	}
@@ -49,27 +63,70 @@ func main() {
 		log.Fatal("Failed to run migrations", zap.Error(err))
 	}
 
+	if cfg.CheckIndexes {
+		database.WarnMissingIndexes(db, repository.ExpectedIndexes, log.Logger)
+	}
+
 	// 5. Initialize Redis
-	redisClient := repository.NewRedisClient(cfg.GetRedisURL(), cfg.RedisPassword)
+	redisClient := repository.NewRedisClient(cfg.GetRedisURL(), cfg.RedisPassword, cfg.RedisDB)
 	defer redisClient.Close()
 
+	// The token denylist and maintenance flag live on REDIS_SHARED_DB so every
+	// service sees them, whatever its own REDIS_DB
+	sharedRedis := redisClient
+	if cfg.RedisSharedDB != cfg.RedisDB {
+		sharedRedis = repository.NewRedisClient(cfg.GetRedisURL(), cfg.RedisPassword, cfg.RedisSharedDB)
+		defer sharedRedis.Close()
+	}
+	keys := cache.NewKeyer(cfg.RedisKeyPrefix)
+
 	log.Info("Redis connection established")
 
-	// 6. Initialize layers
-	productRepo := repository.NewProductRepository(db, redisClient)
-	productService := service.NewProductService(productRepo)
-	productHandler := handlers.NewProductHandler(productService, log.Logger)
+	// 6. Initialize RabbitMQ publisher (low stock events)
+	publisher, err := messaging.NewRabbitMQPublisher(cfg.RabbitMQURL, log.Logger)
+	if err != nil {
+		log.Fatal("Failed to connect to RabbitMQ", zap.Error(err))
+	}
+	defer publisher.Close()
+
+	log.Info("RabbitMQ connection established")
 
-	// 7. Set up router
+	// 7. Initialize layers
+	productRepo := repository.NewProductRepository(db, redisClient, keys)
+	categoryRepo := repository.NewCategoryRepository(db, redisClient, keys)
+	productService := service.NewProductService(productRepo, categoryRepo, publisher, cfg.LowStockThreshold, cfg.CategoryAutoCreate, log.Logger)
+	paging := pagination.Limits{DefaultPageSize: cfg.DefaultPageSize, MaxPageSize: cfg.MaxPageSize}
+	productHandler := handlers.NewProductHandler(productService, paging, log.Logger)
+
+	// 8. Set up router
 	if cfg.IsProduction() {
 		gin.SetMode(gin.ReleaseMode)
 	}
-	router := gin.Default()
-
-	// 8. Register routes
-	setupRoutes(router, productHandler)
+	// gin.New instead of gin.Default: requests are logged as structured JSON and
+	// panics answered with the usual APIResponse body
+	router := gin.New()
+	router.Use(middleware.RequestID(), middleware.AccessLog(log.Logger), middleware.Recovery(log.Logger))
+
+	// Cap request bodies so one oversized payload can't exhaust memory; the
+	// bulk endpoints take lists of IDs and get the larger cap
+	router.Use(middleware.BodyLimit(int64(cfg.MaxBodyBytes), map[string]int64{
+		"/api/v1/products/batch": int64(cfg.MaxBulkBodyBytes),
+		"/api/v1/admin/products": int64(cfg.MaxBulkBodyBytes),
+	}))
+
+	// Block writes while maintenance mode is on (toggled via the admin API)
+	maintenance := middleware.NewMaintenanceMode(sharedRedis, keys, cfg.MaintenanceMode)
+	// The batch lookup is a read despite being a POST
+	router.Use(maintenance.Middleware("/api/v1/products/batch"))
+
+	// 9. Register routes
+	jwtKeys, err := auth.NewKeyring(cfg.JWTKeyID, cfg.JWTSecret, cfg.JWTPreviousSecrets)
+	if err != nil {
+		log.Fatal("Invalid JWT configuration", zap.Error(err))
+	}
+	setupRoutes(router, productHandler, auth.Middleware(jwtKeys, cfg.JWTAlgorithms, auth.NewDenylist(sharedRedis, keys)))
 
-	// 9. Start server
+	// 10. Start server
 	srv := &http.Server{
 		Addr:    ":" + cfg.Port,
 		Handler: router,
@@ -82,14 +139,14 @@ func main() {
 		}
 	}()
 
-	// 10. Graceful shutdown
+	// 11. Graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	log.Info("Shutting down server...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
@@ -99,7 +156,7 @@ func main() {
 	log.Info("Server exited")
 }
 
-func setupRoutes(router *gin.Engine, handler *handlers.ProductHandler) {
+func setupRoutes(router *gin.Engine, handler *handlers.ProductHandler, requireAuth gin.HandlerFunc) {
 	// Health checks
 	router.GET("/health", handler.HealthCheck)
 	router.GET("/ready", handler.ReadinessCheck)
@@ -113,14 +170,58 @@ func setupRoutes(router *gin.Engine, handler *handlers.ProductHandler) {
 			products.GET("", handler.ListProducts)       // List with filters
 			products.GET("/:id", handler.GetProductByID) // Get single product
 			products.GET("/category/:category", handler.GetProductsByCategory)
-			products.GET("/search", handler.SearchProducts) // Search by name
+			products.GET("/search", handler.SearchProducts)   // Search by name
+			products.POST("/batch", handler.GetProductsBatch) // Lookup by IDs (order-service)
+
+			// Categories in use with product counts, for the storefront menu
+			products.GET("/categories", handler.ListProductCategories)
 
 			// Protected routes (require authentication - will add middleware in handler)
 			// Admin only routes would need AdminMiddleware
-			products.POST("", handler.CreateProduct)        // Create new product
-			products.PUT("/:id", handler.UpdateProduct)     // Update product
-			products.DELETE("/:id", handler.DeleteProduct)  // Delete product
-			products.PUT("/:id/stock", handler.UpdateStock) // Update stock
+			products.POST("", handler.CreateProduct)       // Create new product
+			products.PUT("/:id", handler.UpdateProduct)    // Update product
+			products.DELETE("/:id", handler.DeleteProduct) // Delete product
+
+			// Stock changes need product.write: catalog managers, or order-service
+			// with its service token
+			stock := []gin.HandlerFunc{requireAuth, auth.RequirePermission(auth.PermProductWrite)}
+			products.PUT("/:id/stock", append(stock, handler.UpdateStock)...)
+
+			// Two-phase stock for orders: reserve at checkout, commit once paid,
+			// release if cancelled or never paid (order-service)
+			products.POST("/:id/stock/reserve", append(stock, handler.ReserveStock)...)
+			products.POST("/:id/stock/commit", append(stock, handler.CommitStock)...)
+			products.POST("/:id/stock/release", append(stock, handler.ReleaseStock)...)
+
+			// Image management is restricted to catalog managers
+			images := []gin.HandlerFunc{requireAuth, auth.RequirePermission(auth.PermProductWrite)}
+			products.POST("/:id/images", append(images, handler.AddProductImage)...)
+			products.DELETE("/:id/images/:imageId", append(images, handler.DeleteProductImage)...)
+
+			// Absolute stock correction after a physical count
+			products.PUT("/:id/stock/set", requireAuth, auth.RequirePermission(auth.PermProductWrite), handler.SetStock)
+
+			// Inventory audit trail
+			products.GET("/:id/stock-history", requireAuth, auth.RequirePermission(auth.PermInventoryRead), handler.GetStockHistory)
+		}
+
+		categories := v1.Group("/categories")
+		{
+			categories.GET("", handler.ListCategories)
+			categories.GET("/:id", handler.GetCategory)
+
+			// Editing the category tree is restricted to catalog managers
+			write := []gin.HandlerFunc{requireAuth, auth.RequirePermission(auth.PermProductWrite)}
+			categories.POST("", append(write, handler.CreateCategory)...)
+			categories.PUT("/:id", append(write, handler.UpdateCategory)...)
+			categories.DELETE("/:id", append(write, handler.DeleteCategory)...)
+		}
+
+		// Admin-only routes
+		admin := v1.Group("/admin")
+		admin.Use(requireAuth, auth.RequirePermission(auth.PermProductWrite))
+		{
+			admin.DELETE("/products", handler.BulkDeleteProducts) // Bulk soft-delete
 		}
 	}
 }
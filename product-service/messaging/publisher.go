@@ -0,0 +1,205 @@
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+	"go.uber.org/zap"
+
+	"ecommerce/shared/messaging"
+)
+
+// ProductEvent represents a product event to be published
+type ProductEvent struct {
+	Type      string    `json:"type"` // e.g. "low_stock"
+	ProductID string    `json:"product_id"`
+	Name      string    `json:"name"`
+	Stock     int       `json:"stock"`
+	Threshold int       `json:"threshold"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ErrNotConnected is returned while the publisher is reconnecting to RabbitMQ
+// The publish may be retried once the connection is back
+var ErrNotConnected = errors.New("not connected to RabbitMQ")
+
+// RabbitMQPublisher publishes messages to RabbitMQ
+// If the connection drops it redials in the background with backoff; until
+// then publishes fail with ErrNotConnected
+type RabbitMQPublisher struct {
+	url    string
+	logger *zap.Logger
+
+	mu      sync.RWMutex
+	conn    *amqp.Connection
+	channel *amqp.Channel // nil while disconnected
+	closed  bool
+	done    chan struct{} // Closed by Close to stop reconnecting
+}
+
+// NewRabbitMQPublisher creates a new RabbitMQ publisher
+func NewRabbitMQPublisher(url string, logger *zap.Logger) (*RabbitMQPublisher, error) {
+	p := &RabbitMQPublisher{
+		url:    url,
+		logger: logger,
+		done:   make(chan struct{}),
+	}
+	if err := p.connect(); err != nil {
+		return nil, err
+	}
+
+	logger.Info("RabbitMQ publisher initialized")
+
+	return p, nil
+}
+
+// connect dials RabbitMQ, declares the exchange and starts watching the
+// connection so it is re-established if it drops
+func (p *RabbitMQPublisher) connect() error {
+	// Connect to RabbitMQ
+	conn, err := amqp.Dial(p.url)
+	if err != nil {
+		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+
+	// Create channel
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to open channel: %w", err)
+	}
+
+	// Product events get their own exchange so order consumers don't see them
+	err = channel.ExchangeDeclare(
+		messaging.ProductsExchange,     // name
+		messaging.ProductsExchangeType, // type
+		true,                           // durable
+		false,                          // auto-deleted
+		false,                          // internal
+		false,                          // no-wait
+		nil,                            // arguments
+	)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to declare exchange: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		conn.Close()
+		return ErrNotConnected
+	}
+	p.conn = conn
+	p.channel = channel
+
+	go p.watch(conn.NotifyClose(make(chan *amqp.Error, 1)), channel.NotifyClose(make(chan *amqp.Error, 1)))
+
+	return nil
+}
+
+// watch waits for the connection or channel to close and, unless Close
+// closed it, redials with exponential backoff until it succeeds
+func (p *RabbitMQPublisher) watch(connClosed, channelClosed chan *amqp.Error) {
+	var reason *amqp.Error
+	select {
+	case reason = <-connClosed:
+	case reason = <-channelClosed:
+	}
+	if reason == nil {
+		return // Closed by Close
+	}
+
+	p.logger.Warn("RabbitMQ connection lost; reconnecting", zap.Error(reason))
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.conn.Close() // A channel error leaves the connection open
+	p.conn = nil
+	p.channel = nil
+	p.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-p.done:
+			return
+		case <-time.After(messaging.ReconnectDelay(attempt)):
+		}
+
+		if err := p.connect(); err != nil {
+			if errors.Is(err, ErrNotConnected) {
+				return // Closed while dialing
+			}
+			p.logger.Warn("Failed to reconnect to RabbitMQ", zap.Int("attempt", attempt+1), zap.Error(err))
+			continue
+		}
+
+		p.logger.Info("Reconnected to RabbitMQ", zap.Int("attempts", attempt+1))
+		return
+	}
+}
+
+// PublishProductEvent publishes a product event
+func (p *RabbitMQPublisher) PublishProductEvent(event ProductEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	p.mu.RLock()
+	channel := p.channel
+	p.mu.RUnlock()
+	if channel == nil {
+		return ErrNotConnected
+	}
+
+	err = channel.Publish(
+		messaging.ProductsExchange,   // exchange
+		messaging.ProductsRoutingKey, // routing key (ignored for fanout)
+		false,                        // mandatory
+		false,                        // immediate
+		amqp.Publishing{
+			ContentType: "application/json",
+			Body:        body,
+			Timestamp:   time.Now(),
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+
+	p.logger.Info("Product event published",
+		zap.String("type", event.Type),
+		zap.String("product_id", event.ProductID),
+		zap.Int("stock", event.Stock),
+	)
+
+	return nil
+}
+
+// Close closes the RabbitMQ connection and stops reconnecting
+func (p *RabbitMQPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+
+	if p.channel != nil {
+		p.channel.Close()
+	}
+	if p.conn != nil {
+		return p.conn.Close()
+	}
+	return nil
+}
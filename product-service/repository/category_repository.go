@@ -0,0 +1,232 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"ecommerce/shared/cache"
+	"ecommerce/shared/models"
+)
+
+var (
+	ErrCategoryNotFound  = errors.New("category not found")
+	ErrParentNotFound    = errors.New("parent category not found")
+	ErrDuplicateCategory = errors.New("a category with this slug already exists")
+
+	// ErrCategoryInUse is returned when deleting a category that still has
+	// subcategories or products
+	ErrCategoryInUse = errors.New("category has subcategories or products")
+)
+
+// foreignKeyViolation is the Postgres error code for a foreign key conflict
+const foreignKeyViolation = "23503"
+
+// categoryColumns is the column list scanned by scanCategory
+const categoryColumns = `id, name, slug, parent_id, created_at, updated_at`
+
+type CategoryRepository struct {
+	db    *sql.DB
+	redis *redis.Client
+	keys  cache.Keyer
+}
+
+func NewCategoryRepository(db *sql.DB, redisClient *redis.Client, keys cache.Keyer) *CategoryRepository {
+	return &CategoryRepository{
+		db:    db,
+		redis: redisClient,
+		keys:  keys,
+	}
+}
+
+// categoryError maps a taken slug to ErrDuplicateCategory and a missing
+// parent to ErrParentNotFound
+func categoryError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+	switch {
+	case pqErr.Code == uniqueViolation && pqErr.Constraint == "idx_categories_slug":
+		return ErrDuplicateCategory
+	case pqErr.Code == foreignKeyViolation:
+		return ErrParentNotFound
+	}
+	return err
+}
+
+func scanCategory(row rowScanner) (*models.Category, error) {
+	var c models.Category
+	if err := row.Scan(&c.ID, &c.Name, &c.Slug, &c.ParentID, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Create inserts a new category
+func (r *CategoryRepository) Create(ctx context.Context, c *models.Category) error {
+	c.ID = uuid.New().String()
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = c.CreatedAt
+
+	query := `
+		INSERT INTO categories (id, name, slug, parent_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := r.db.ExecContext(ctx, query, c.ID, c.Name, c.Slug, c.ParentID, c.CreatedAt, c.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create category: %w", categoryError(err))
+	}
+	return nil
+}
+
+// GetByID retrieves a category by ID
+func (r *CategoryRepository) GetByID(ctx context.Context, id string) (*models.Category, error) {
+	query := `SELECT ` + categoryColumns + ` FROM categories WHERE id = $1`
+	return r.get(ctx, query, id)
+}
+
+// GetBySlug retrieves a category by slug
+func (r *CategoryRepository) GetBySlug(ctx context.Context, slug string) (*models.Category, error) {
+	query := `SELECT ` + categoryColumns + ` FROM categories WHERE slug = $1`
+	return r.get(ctx, query, slug)
+}
+
+func (r *CategoryRepository) get(ctx context.Context, query string, arg string) (*models.Category, error) {
+	c, err := scanCategory(r.db.QueryRowContext(ctx, query, arg))
+	if err == sql.ErrNoRows {
+		return nil, ErrCategoryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get category: %w", err)
+	}
+	return c, nil
+}
+
+// List returns all categories ordered by name
+func (r *CategoryRepository) List(ctx context.Context) ([]*models.Category, error) {
+	query := `SELECT ` + categoryColumns + ` FROM categories ORDER BY name`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list categories: %w", err)
+	}
+	defer rows.Close()
+
+	var categories []*models.Category
+	for rows.Next() {
+		c, err := scanCategory(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan category: %w", err)
+		}
+		categories = append(categories, c)
+	}
+
+	return categories, rows.Err()
+}
+
+// IsDescendant reports whether id is ancestorID or one of its subcategories,
+// following parent links up from id
+func (r *CategoryRepository) IsDescendant(ctx context.Context, id, ancestorID string) (bool, error) {
+	query := `
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id FROM categories WHERE id = $1
+			UNION
+			SELECT c.id, c.parent_id FROM categories c JOIN ancestors a ON c.id = a.parent_id
+		)
+		SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)
+	`
+
+	var found bool
+	if err := r.db.QueryRowContext(ctx, query, id, ancestorID).Scan(&found); err != nil {
+		return false, fmt.Errorf("failed to check category ancestry: %w", err)
+	}
+	return found, nil
+}
+
+// Update saves a category. Products store the category name, so a rename
+// moves them to the new name in the same transaction
+func (r *CategoryRepository) Update(ctx context.Context, c *models.Category, previousName string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	c.UpdatedAt = time.Now()
+	query := `
+		UPDATE categories
+		SET name = $1, slug = $2, parent_id = $3, updated_at = $4
+		WHERE id = $5
+	`
+	result, err := tx.ExecContext(ctx, query, c.Name, c.Slug, c.ParentID, c.UpdatedAt, c.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update category: %w", categoryError(err))
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrCategoryNotFound
+	}
+
+	var renamed []string
+	if c.Name != previousName {
+		rows, err := tx.QueryContext(ctx,
+			`UPDATE products SET category = $1, updated_at = $2 WHERE category = $3 RETURNING id`,
+			c.Name, c.UpdatedAt, previousName,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to rename product categories: %w", err)
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan renamed product: %w", err)
+			}
+			renamed = append(renamed, id)
+		}
+		rows.Close()
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	for _, id := range renamed {
+		r.redis.Del(ctx, r.keys.Key("product:%s", id))
+	}
+	return nil
+}
+
+// Delete removes a category that has no subcategories and no products
+// outside the deleted ones
+func (r *CategoryRepository) Delete(ctx context.Context, id string) error {
+	query := `
+		DELETE FROM categories
+		WHERE id = $1 AND NOT EXISTS (
+			SELECT 1 FROM products WHERE category = categories.name AND status <> 'deleted'
+		)
+	`
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation {
+			return ErrCategoryInUse
+		}
+		return fmt.Errorf("failed to delete category: %w", err)
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		// Either it doesn't exist or products still use it
+		if _, err := r.GetByID(ctx, id); err != nil {
+			return err
+		}
+		return ErrCategoryInUse
+	}
+	return nil
+}
@@ -8,9 +8,13 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	_ "github.com/lib/pq"
+
+	"ecommerce/shared/database"
 )
 
-func NewPostgresDB(connStr string) (*sql.DB, error) {
+// NewPostgresDB opens a connection pool, retrying the initial ping for up to
+// connectTimeout (DB_CONNECT_TIMEOUT)
+func NewPostgresDB(connStr string, connectTimeout time.Duration) (*sql.DB, error) {
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -20,21 +24,20 @@ func NewPostgresDB(connStr string) (*sql.DB, error) {
 	db.SetMaxIdleConns(5)
 	db.SetConnMaxLifetime(5 * time.Minute)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	if err := db.PingContext(ctx); err != nil {
+	// Verify connection, waiting for Postgres if it is still starting
+	if err := database.PingWithRetry(db, connectTimeout); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
 	return db, nil
 }
 
-func NewRedisClient(addr, password string) *redis.Client {
+func NewRedisClient(addr, password string, db int) *redis.Client {
 	client := redis.NewClient(&redis.Options{
 		Addr:         addr,
 		Password:     password,
-		DB:           0,
+		DB:           db,
 		PoolSize:     10,
 		MinIdleConns: 5,
 		MaxRetries:   3,
@@ -53,6 +56,22 @@ func NewRedisClient(addr, password string) *redis.Client {
 	return client
 }
 
+// ExpectedIndexes are the indexes the queries rely on; keep in sync with the
+// CREATE INDEX migrations below (checked at startup when CHECK_INDEXES is set)
+var ExpectedIndexes = []string{
+	"idx_products_category",
+	"idx_products_price",
+	"idx_products_name",
+	"idx_products_status",
+	"idx_products_created_id",
+	"idx_products_search",
+	"idx_products_sku",
+	"idx_product_images_product",
+	"idx_stock_movements_product",
+	"idx_categories_slug",
+	"idx_categories_parent",
+}
+
 func RunMigrations(db *sql.DB) error {
 	migrations := []string{
 		`CREATE TABLE IF NOT EXISTS products (
@@ -66,9 +85,90 @@ func RunMigrations(db *sql.DB) error {
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
 
+		// Lifecycle status - existing products are treated as published
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'published'`,
+
 		`CREATE INDEX IF NOT EXISTS idx_products_category ON products(category)`,
 		`CREATE INDEX IF NOT EXISTS idx_products_price ON products(price)`,
 		`CREATE INDEX IF NOT EXISTS idx_products_name ON products(LOWER(name))`,
+		`CREATE INDEX IF NOT EXISTS idx_products_status ON products(status)`,
+		// Cursor pagination walks products by (created_at, id), newest first
+		`CREATE INDEX IF NOT EXISTS idx_products_created_id ON products(created_at DESC, id DESC)`,
+
+		// Full-text search - name is weighted above description for ranking
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector
+			GENERATED ALWAYS AS (
+				setweight(to_tsvector('english', COALESCE(name, '')), 'A') ||
+				setweight(to_tsvector('english', COALESCE(description, '')), 'B')
+			) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_products_search ON products USING GIN(search_vector)`,
+
+		// Retail identifiers - SKU is optional (NULL) but unique when set
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS sku VARCHAR(64)`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD'`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_products_sku ON products(sku)`,
+
+		// NULL means the service-wide LOW_STOCK_THRESHOLD applies
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS low_stock_threshold INTEGER`,
+
+		// Bumped on every write; stock updates use it for optimistic locking
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 0`,
+
+		// Units held by unpaid orders; they stay in stock until the order is paid
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS reserved INTEGER NOT NULL DEFAULT 0 CHECK (reserved >= 0)`,
+
+		// Images go with their product when it is hard-deleted
+		`CREATE TABLE IF NOT EXISTS product_images (
+			id VARCHAR(36) PRIMARY KEY,
+			product_id VARCHAR(36) NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+			url TEXT NOT NULL,
+			position INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_product_images_product ON product_images(product_id, position)`,
+
+		// Inventory audit trail - no foreign key so history outlives a hard-deleted product
+		`CREATE TABLE IF NOT EXISTS stock_movements (
+			id VARCHAR(36) PRIMARY KEY,
+			product_id VARCHAR(36) NOT NULL,
+			delta INTEGER NOT NULL,
+			reason VARCHAR(50) NOT NULL,
+			resulting_stock INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_stock_movements_product ON stock_movements(product_id, created_at)`,
+		// Change in reserved units, so reservations show up in the trail too
+		`ALTER TABLE stock_movements ADD COLUMN IF NOT EXISTS reserved_delta INTEGER NOT NULL DEFAULT 0`,
+
+		// Categories products may be filed under; a parent can't be deleted
+		// while it has children
+		`CREATE TABLE IF NOT EXISTS categories (
+			id VARCHAR(36) PRIMARY KEY,
+			name VARCHAR(100) NOT NULL,
+			slug VARCHAR(100) NOT NULL,
+			parent_id VARCHAR(36) REFERENCES categories(id) ON DELETE RESTRICT,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_categories_slug ON categories(slug)`,
+		`CREATE INDEX IF NOT EXISTS idx_categories_parent ON categories(parent_id)`,
+
+		// Seed categories from the free-text ones products already use, plus
+		// the default; spellings that slug the same ("Electronics",
+		// "electronics") collapse into one. Slugs match service.Slugify
+		`INSERT INTO categories (id, name, slug)
+			SELECT gen_random_uuid()::text, MIN(name), slug
+			FROM (
+				SELECT category AS name,
+					TRIM(BOTH '-' FROM LOWER(REGEXP_REPLACE(category, '[^a-zA-Z0-9]+', '-', 'g'))) AS slug
+				FROM products
+				WHERE category IS NOT NULL
+				UNION ALL
+				SELECT 'Uncategorized', 'uncategorized'
+			) used
+			WHERE slug <> ''
+			GROUP BY slug
+			ON CONFLICT (slug) DO NOTHING`,
 	}
 
 	for i, migration := range migrations {
@@ -5,25 +5,103 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 
+	"ecommerce/shared/cache"
 	"ecommerce/shared/models"
+	"ecommerce/shared/pagination"
 )
 
+// productColumns is the column list shared by every product SELECT, in scanProduct order
+const productColumns = `id, name, description, price, stock, reserved, category, COALESCE(sku, ''), currency, status, created_at, updated_at, low_stock_threshold`
+
+var (
+	// ErrProductNotFound is returned when the product doesn't exist or was deleted
+	ErrProductNotFound = errors.New("product not found")
+
+	// ErrInsufficientStock is returned when a stock change would go below zero,
+	// or below what unpaid orders have reserved
+	ErrInsufficientStock = errors.New("insufficient stock")
+
+	// ErrNoReservation is returned when committing or releasing more units
+	// than are reserved
+	ErrNoReservation = errors.New("not enough reserved stock")
+
+	// ErrStockBelowReserved is returned when an update would set stock below
+	// what unpaid orders have reserved
+	ErrStockBelowReserved = errors.New("stock cannot be set below reserved stock")
+
+	// ErrDuplicateSKU is returned when another product already has the SKU
+	ErrDuplicateSKU = errors.New("sku already exists")
+
+	// ErrImageNotFound is returned when the image doesn't exist on the product
+	ErrImageNotFound = errors.New("image not found")
+
+	// ErrConcurrentUpdate is returned when a stock update keeps losing to
+	// concurrent writers and runs out of retries
+	ErrConcurrentUpdate = errors.New("product was modified concurrently, please retry")
+)
+
+// categoriesCacheTTL is how long the distinct category list is cached; it
+// changes rarely, so it isn't invalidated on writes
+const categoriesCacheTTL = 5 * time.Minute
+
+const (
+	// maxStockUpdateAttempts bounds the optimistic stock update retry loop
+	maxStockUpdateAttempts = 5
+	// stockRetryDelay is multiplied by the attempt number between retries
+	stockRetryDelay = 10 * time.Millisecond
+)
+
+// uniqueViolation is the Postgres error code for a unique constraint conflict
+const uniqueViolation = "23505"
+
+// skuError maps a unique violation on the SKU index to ErrDuplicateSKU
+func skuError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation && pqErr.Constraint == "idx_products_sku" {
+		return ErrDuplicateSKU
+	}
+	return err
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanProduct scans a row selected with productColumns
+func scanProduct(row rowScanner) (*models.Product, error) {
+	var product models.Product
+	err := row.Scan(
+		&product.ID, &product.Name, &product.Description, &product.Price,
+		&product.Stock, &product.Reserved, &product.Category, &product.SKU, &product.Currency,
+		&product.Status, &product.CreatedAt, &product.UpdatedAt, &product.LowStockThreshold,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
 type ProductRepository struct {
 	db    *sql.DB
 	redis *redis.Client
+	keys  cache.Keyer
 }
 
-func NewProductRepository(db *sql.DB, redisClient *redis.Client) *ProductRepository {
+func NewProductRepository(db *sql.DB, redisClient *redis.Client, keys cache.Keyer) *ProductRepository {
 	return &ProductRepository{
 		db:    db,
 		redis: redisClient,
+		keys:  keys,
 	}
 }
 
@@ -34,17 +112,18 @@ func (r *ProductRepository) Create(ctx context.Context, product *models.Product)
 	product.UpdatedAt = time.Now()
 
 	query := `
-		INSERT INTO products (id, name, description, price, stock, category, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		INSERT INTO products (id, name, description, price, stock, category, sku, currency, status, created_at, updated_at, low_stock_threshold)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $9, $10, $11, $12)
 	`
 
 	_, err := r.db.ExecContext(ctx, query,
 		product.ID, product.Name, product.Description, product.Price,
-		product.Stock, product.Category, product.CreatedAt, product.UpdatedAt,
+		product.Stock, product.Category, product.SKU, product.Currency,
+		product.Status, product.CreatedAt, product.UpdatedAt, product.LowStockThreshold,
 	)
 
 	if err != nil {
-		return fmt.Errorf("failed to create product: %w", err)
+		return fmt.Errorf("failed to create product: %w", skuError(err))
 	}
 
 	return nil
@@ -52,57 +131,104 @@ func (r *ProductRepository) Create(ctx context.Context, product *models.Product)
 
 // GetByID retrieves a product by ID with caching
 func (r *ProductRepository) GetByID(ctx context.Context, id string) (*models.Product, error) {
-	cacheKey := fmt.Sprintf("product:%s", id)
+	cacheKey := r.keys.Key("product:%s", id)
 	cached, err := r.redis.Get(ctx, cacheKey).Result()
 
 	if err == nil {
 		var product models.Product
 		if err := json.Unmarshal([]byte(cached), &product); err == nil {
+			// Entries cached before the status column existed have no status;
+			// writing them back unchanged would store an empty status
+			if product.Status == "" {
+				product.Status = models.ProductStatusPublished
+			}
+			if product.Currency == "" {
+				product.Currency = "USD"
+			}
 			return &product, nil
 		}
 	}
 
-	query := `
-		SELECT id, name, description, price, stock, category, created_at, updated_at
-		FROM products WHERE id = $1
-	`
+	// Soft-deleted products are treated as missing
+	query := `SELECT ` + productColumns + ` FROM products WHERE id = $1 AND status <> 'deleted'`
 
-	var product models.Product
-	err = r.db.QueryRowContext(ctx, query, id).Scan(
-		&product.ID, &product.Name, &product.Description, &product.Price,
-		&product.Stock, &product.Category, &product.CreatedAt, &product.UpdatedAt,
-	)
+	product, err := scanProduct(r.db.QueryRowContext(ctx, query, id))
 
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("product not found")
+		return nil, ErrProductNotFound
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get product: %w", err)
 	}
 
+	images, err := r.ListImages(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	product.Images = images
+
 	if data, err := json.Marshal(product); err == nil {
 		r.redis.Set(ctx, cacheKey, data, 30*time.Minute)
 	}
 
-	return &product, nil
+	return product, nil
 }
 
-// List retrieves products with pagination and filters
-func (r *ProductRepository) List(ctx context.Context, limit, offset int, category string) ([]*models.Product, error) {
-	query := `
-		SELECT id, name, description, price, stock, category, created_at, updated_at
-		FROM products
-	`
+// ProductFilter narrows List and Count; zero values mean "no filter"
+type ProductFilter struct {
+	Category string
+	Search   string   // Full-text match on name and description, ranked by relevance
+	MinPrice *float64 // Inclusive
+	MaxPrice *float64 // Inclusive
+	InStock  bool     // Only products with unreserved stock
+}
+
+// minFullTextLength is the shortest search term sent to full-text search;
+// a single character can't form a useful lexeme, so it falls back to LIKE
+const minFullTextLength = 2
+
+// where builds the WHERE clause shared by List and Count, and the ORDER BY List
+// uses (relevance first when full-text searching)
+// Placeholders are numbered from the args collected so far, so clauses can be optional
+func (f ProductFilter) where() (string, []interface{}, string) {
+	where := ` WHERE status <> 'deleted'`
 	args := []interface{}{}
-	argPosition := 1
+	orderBy := "created_at DESC"
 
-	if category != "" {
-		query += fmt.Sprintf(" WHERE category = $%d", argPosition)
-		args = append(args, category)
-		argPosition++
+	if f.Category != "" {
+		args = append(args, f.Category)
+		where += fmt.Sprintf(" AND category = $%d", len(args))
+	}
+	if term := strings.TrimSpace(f.Search); len([]rune(term)) >= minFullTextLength {
+		// search_vector weights name above description, so ts_rank favours name matches
+		args = append(args, term)
+		where += fmt.Sprintf(" AND search_vector @@ plainto_tsquery('english', $%d)", len(args))
+		orderBy = fmt.Sprintf("ts_rank(search_vector, plainto_tsquery('english', $%d)) DESC, created_at DESC", len(args))
+	} else if term != "" {
+		args = append(args, "%"+term+"%")
+		where += fmt.Sprintf(" AND (LOWER(name) LIKE LOWER($%d) OR LOWER(description) LIKE LOWER($%d))", len(args), len(args))
 	}
+	if f.MinPrice != nil {
+		args = append(args, *f.MinPrice)
+		where += fmt.Sprintf(" AND price >= $%d", len(args))
+	}
+	if f.MaxPrice != nil {
+		args = append(args, *f.MaxPrice)
+		where += fmt.Sprintf(" AND price <= $%d", len(args))
+	}
+	if f.InStock {
+		where += " AND stock - reserved > 0"
+	}
+
+	return where, args, orderBy
+}
+
+// List retrieves products with pagination and filters
+func (r *ProductRepository) List(ctx context.Context, limit, offset int, filter ProductFilter) ([]*models.Product, error) {
+	where, args, orderBy := filter.where()
+	query := `SELECT ` + productColumns + ` FROM products` + where
 
-	query += fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d OFFSET $%d", argPosition, argPosition+1)
+	query += fmt.Sprintf(" ORDER BY %s LIMIT $%d OFFSET $%d", orderBy, len(args)+1, len(args)+2)
 	args = append(args, limit, offset)
 
 	rows, err := r.db.QueryContext(ctx, query, args...)
@@ -113,127 +239,409 @@ func (r *ProductRepository) List(ctx context.Context, limit, offset int, categor
 
 	var products []*models.Product
 	for rows.Next() {
-		var product models.Product
-		err := rows.Scan(
-			&product.ID, &product.Name, &product.Description, &product.Price,
-			&product.Stock, &product.Category, &product.CreatedAt, &product.UpdatedAt,
-		)
+		product, err := scanProduct(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan product: %w", err)
 		}
-		products = append(products, &product)
+		products = append(products, product)
 	}
 
 	return products, nil
 }
 
-// SearchByName searches products by name
-func (r *ProductRepository) SearchByName(ctx context.Context, searchTerm string, limit, offset int) ([]*models.Product, error) {
-	query := `
-		SELECT id, name, description, price, stock, category, created_at, updated_at
-		FROM products
-		WHERE LOWER(name) LIKE LOWER($1) OR LOWER(description) LIKE LOWER($1)
-		ORDER BY created_at DESC
-		LIMIT $2 OFFSET $3
-	`
+// ListAfter retrieves up to limit products matching the filter that come after
+// the cursor, newest first; a nil cursor starts from the newest
+// Ties on created_at are broken by ID so every product has one place in the order
+func (r *ProductRepository) ListAfter(ctx context.Context, limit int, after *pagination.Cursor, filter ProductFilter) ([]*models.Product, error) {
+	where, args, _ := filter.where()
+	if after != nil {
+		args = append(args, after.CreatedAt, after.ID)
+		where += fmt.Sprintf(" AND (created_at, id) < ($%d, $%d)", len(args)-1, len(args))
+	}
 
-	searchPattern := "%" + searchTerm + "%"
+	query := `SELECT ` + productColumns + ` FROM products` + where
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args)+1)
+	args = append(args, limit)
 
-	rows, err := r.db.QueryContext(ctx, query, searchPattern, limit, offset)
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to search products: %w", err)
+		return nil, fmt.Errorf("failed to list products: %w", err)
 	}
 	defer rows.Close()
 
 	var products []*models.Product
 	for rows.Next() {
-		var product models.Product
-		err := rows.Scan(
-			&product.ID, &product.Name, &product.Description, &product.Price,
-			&product.Stock, &product.Category, &product.CreatedAt, &product.UpdatedAt,
-		)
+		product, err := scanProduct(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan product: %w", err)
 		}
-		products = append(products, &product)
+		products = append(products, product)
 	}
 
-	return products, nil
+	return products, rows.Err()
 }
 
-// GetByCategory retrieves products by category
-func (r *ProductRepository) GetByCategory(ctx context.Context, category string, limit, offset int) ([]*models.Product, error) {
-	return r.List(ctx, limit, offset, category)
+// Count returns how many products match the same filters as List
+func (r *ProductRepository) Count(ctx context.Context, filter ProductFilter) (int, error) {
+	where, args, _ := filter.where()
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM products`+where, args...).Scan(&total); err != nil {
+		return 0, fmt.Errorf("failed to count products: %w", err)
+	}
+
+	return total, nil
 }
 
-// Update modifies product information
+// ListCategories returns the distinct categories of non-deleted products
+// with their product counts, ordered by name, with caching
+func (r *ProductRepository) ListCategories(ctx context.Context) ([]models.CategoryCount, error) {
+	cacheKey := r.keys.Key("product-categories")
+	if cached, err := r.redis.Get(ctx, cacheKey).Result(); err == nil {
+		var categories []models.CategoryCount
+		if err := json.Unmarshal([]byte(cached), &categories); err == nil {
+			return categories, nil
+		}
+	}
+
+	query := `
+		SELECT category, COUNT(*)
+		FROM products
+		WHERE category IS NOT NULL AND status <> 'deleted'
+		GROUP BY category
+		ORDER BY category
+	`
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list categories: %w", err)
+	}
+	defer rows.Close()
+
+	categories := []models.CategoryCount{}
+	for rows.Next() {
+		var c models.CategoryCount
+		if err := rows.Scan(&c.Category, &c.ProductCount); err != nil {
+			return nil, fmt.Errorf("failed to scan category: %w", err)
+		}
+		categories = append(categories, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list categories: %w", err)
+	}
+
+	if data, err := json.Marshal(categories); err == nil {
+		r.redis.Set(ctx, cacheKey, data, categoriesCacheTTL)
+	}
+
+	return categories, nil
+}
+
+// Update modifies product information; stock is not touched
 func (r *ProductRepository) Update(ctx context.Context, product *models.Product) error {
 	product.UpdatedAt = time.Now()
 
+	// Stock and reservations are left alone: they only change through the
+	// stock methods, which record each movement, so a concurrent reservation
+	// or sale can't be overwritten by a stale read
 	query := `
 		UPDATE products
-		SET name = $1, description = $2, price = $3, stock = $4, category = $5, updated_at = $6
-		WHERE id = $7
+		SET name = $1, description = $2, price = $3, category = $4,
+			sku = NULLIF($5, ''), currency = $6, status = $7, updated_at = $8, low_stock_threshold = $9
+		WHERE id = $10
 	`
 
 	result, err := r.db.ExecContext(ctx, query,
-		product.Name, product.Description, product.Price, product.Stock,
-		product.Category, product.UpdatedAt, product.ID,
+		product.Name, product.Description, product.Price, product.Category,
+		product.SKU, product.Currency, product.Status, product.UpdatedAt, product.LowStockThreshold,
+		product.ID,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to update product: %w", err)
+		return fmt.Errorf("failed to update product: %w", skuError(err))
 	}
 
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("product not found")
+		return ErrProductNotFound
 	}
 
-	cacheKey := fmt.Sprintf("product:%s", product.ID)
+	cacheKey := r.keys.Key("product:%s", product.ID)
 	r.redis.Del(ctx, cacheKey)
 
 	return nil
 }
 
-// UpdateStock updates product stock with transaction
-func (r *ProductRepository) UpdateStock(ctx context.Context, productID string, quantity int) error {
+// StockChange describes a committed stock update
+type StockChange struct {
+	ProductID string
+	Name      string
+	Previous  int
+	Current   int
+	Threshold *int // The product's own low-stock threshold, if set
+}
+
+// UpdateStock changes a product's stock by quantity using optimistic locking:
+// the row is only written if its version is unchanged since it was read, so
+// concurrent updates retry instead of queueing on a row lock
+// The change is recorded in stock_movements in the same transaction, so the
+// audit trail can't disagree with the stock level
+func (r *ProductRepository) UpdateStock(ctx context.Context, productID string, quantity int, reason string) (*StockChange, error) {
+	return r.changeStock(ctx, productID, reason, ErrInsufficientStock, func(previous int) int {
+		return previous + quantity
+	})
+}
+
+// SetStock sets a product's stock to an absolute count, recording the
+// difference as a movement; it fails with ErrStockBelowReserved if unpaid
+// orders have more reserved
+func (r *ProductRepository) SetStock(ctx context.Context, productID string, stock int, reason string) (*StockChange, error) {
+	return r.changeStock(ctx, productID, reason, ErrStockBelowReserved, func(int) int {
+		return stock
+	})
+}
+
+// changeStock runs the optimistic update loop shared by UpdateStock and
+// SetStock; next computes the new stock from the current one, and guardErr is
+// returned if it would fall below the reserved units
+func (r *ProductRepository) changeStock(ctx context.Context, productID, reason string, guardErr error, next func(previous int) int) (*StockChange, error) {
+	for attempt := 0; attempt < maxStockUpdateAttempts; attempt++ {
+		if attempt > 0 {
+			// Back off a little more each time so contending writers spread out
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Duration(attempt) * stockRetryDelay):
+			}
+		}
+
+		change, err := r.tryUpdateStock(ctx, productID, reason, guardErr, next)
+		if err != nil {
+			return nil, err
+		}
+		if change != nil {
+			r.redis.Del(ctx, r.keys.Key("product:%s", productID))
+			return change, nil
+		}
+	}
+
+	return nil, ErrConcurrentUpdate
+}
+
+// tryUpdateStock makes one optimistic stock update attempt
+// It returns a nil change without error when another writer got there first
+func (r *ProductRepository) tryUpdateStock(ctx context.Context, productID, reason string, guardErr error, next func(previous int) int) (*StockChange, error) {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to start transaction: %w", err)
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	var currentStock int
-	query := `SELECT stock FROM products WHERE id = $1 FOR UPDATE`
-	err = tx.QueryRowContext(ctx, query, productID).Scan(&currentStock)
+	change := &StockChange{ProductID: productID}
+	var reserved, version int
+	query := `SELECT name, stock, reserved, low_stock_threshold, version FROM products WHERE id = $1`
+	err = tx.QueryRowContext(ctx, query, productID).Scan(&change.Name, &change.Previous, &reserved, &change.Threshold, &version)
 	if err == sql.ErrNoRows {
-		return fmt.Errorf("product not found")
+		return nil, ErrProductNotFound
 	}
 	if err != nil {
-		return fmt.Errorf("failed to get stock: %w", err)
+		return nil, fmt.Errorf("failed to get stock: %w", err)
 	}
 
-	newStock := currentStock + quantity
-	if newStock < 0 {
-		return fmt.Errorf("insufficient stock: current=%d, requested=%d", currentStock, -quantity)
+	// Reserved units belong to unpaid orders, so stock may not drop below them
+	change.Current = next(change.Previous)
+	if change.Current < reserved {
+		return nil, fmt.Errorf("%w: current=%d, reserved=%d, new=%d", guardErr, change.Previous, reserved, change.Current)
 	}
 
-	updateQuery := `UPDATE products SET stock = $1, updated_at = $2 WHERE id = $3`
-	_, err = tx.ExecContext(ctx, updateQuery, newStock, time.Now(), productID)
+	// The version guard means stock still holds change.Previous, so writing
+	// the new value directly is the same as applying the delta. The stock guard
+	// is repeated in SQL so a write can never go below it
+	updateQuery := `
+		UPDATE products
+		SET stock = $1, version = version + 1, updated_at = $2
+		WHERE id = $3 AND version = $4 AND $1 >= reserved
+	`
+	result, err := tx.ExecContext(ctx, updateQuery, change.Current, time.Now(), productID, version)
 	if err != nil {
-		return fmt.Errorf("failed to update stock: %w", err)
+		return nil, fmt.Errorf("failed to update stock: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil, nil
+	}
+
+	if err := recordMovement(ctx, tx, productID, change.Current-change.Previous, 0, reason, change.Current, time.Now()); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return change, nil
+}
+
+// ReserveStock holds quantity units for an unpaid order without taking them
+// out of stock; it fails with ErrInsufficientStock unless the unreserved stock
+// covers them. The guard is a single UPDATE, so concurrent reservations queue
+// on the row lock rather than retrying
+func (r *ProductRepository) ReserveStock(ctx context.Context, productID string, quantity int) error {
+	query := `
+		UPDATE products
+		SET reserved = reserved + $1, version = version + 1, updated_at = $2
+		WHERE id = $3 AND stock - reserved >= $1
+		RETURNING stock
+	`
+	return r.adjustReserved(ctx, productID, query, quantity, quantity, models.StockReasonOrderHold, ErrInsufficientStock)
+}
+
+// ReleaseStock drops quantity units from a product's reservations, e.g. for
+// an order that was cancelled or never paid; stock itself is unchanged
+func (r *ProductRepository) ReleaseStock(ctx context.Context, productID string, quantity int) error {
+	query := `
+		UPDATE products
+		SET reserved = reserved - $1, version = version + 1, updated_at = $2
+		WHERE id = $3 AND reserved >= $1
+		RETURNING stock
+	`
+	return r.adjustReserved(ctx, productID, query, quantity, -quantity, models.StockReasonOrderHoldRelease, ErrNoReservation)
+}
+
+// adjustReserved runs a guarded reservation update (which must return stock)
+// and records it in stock_movements as reservedDelta in the same transaction;
+// guardErr is returned when the product exists but the guard rejected the change
+func (r *ProductRepository) adjustReserved(ctx context.Context, productID, query string, quantity, reservedDelta int, reason string, guardErr error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var stock int
+	now := time.Now()
+	err = tx.QueryRowContext(ctx, query, quantity, now, productID).Scan(&stock)
+	if err == sql.ErrNoRows {
+		return r.guardFailure(ctx, productID, guardErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update reserved stock: %w", err)
+	}
+
+	if err := recordMovement(ctx, tx, productID, 0, reservedDelta, reason, stock, now); err != nil {
+		return err
 	}
 
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	cacheKey := fmt.Sprintf("product:%s", productID)
-	r.redis.Del(ctx, cacheKey)
+	r.redis.Del(ctx, r.keys.Key("product:%s", productID))
+	return nil
+}
+
+// CommitStock turns quantity reserved units into a sale: stock and reserved
+// both drop by quantity. The sale is recorded in stock_movements in the same
+// transaction
+func (r *ProductRepository) CommitStock(ctx context.Context, productID string, quantity int) (*StockChange, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// stock >= reserved always holds, so taking quantity from both can't go negative
+	change := &StockChange{ProductID: productID}
+	now := time.Now()
+	query := `
+		UPDATE products
+		SET stock = stock - $1, reserved = reserved - $1, version = version + 1, updated_at = $2
+		WHERE id = $3 AND reserved >= $1
+		RETURNING name, stock, low_stock_threshold
+	`
+	err = tx.QueryRowContext(ctx, query, quantity, now, productID).Scan(&change.Name, &change.Current, &change.Threshold)
+	if err == sql.ErrNoRows {
+		return nil, r.guardFailure(ctx, productID, ErrNoReservation)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit stock: %w", err)
+	}
+	change.Previous = change.Current + quantity
+
+	if err := recordMovement(ctx, tx, productID, -quantity, -quantity, models.StockReasonOrderCommit, change.Current, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
 
+	r.redis.Del(ctx, r.keys.Key("product:%s", productID))
+	return change, nil
+}
+
+// recordMovement appends an entry to a product's inventory audit trail
+func recordMovement(ctx context.Context, tx *sql.Tx, productID string, delta, reservedDelta int, reason string, resultingStock int, at time.Time) error {
+	query := `
+		INSERT INTO stock_movements (id, product_id, delta, reserved_delta, reason, resulting_stock, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	_, err := tx.ExecContext(ctx, query, uuid.New().String(), productID, delta, reservedDelta, reason, resultingStock, at)
+	if err != nil {
+		return fmt.Errorf("failed to record stock movement: %w", err)
+	}
 	return nil
 }
 
+// guardFailure explains why a guarded stock update touched no rows: the
+// product doesn't exist, or guardErr with its current stock and reservations
+func (r *ProductRepository) guardFailure(ctx context.Context, productID string, guardErr error) error {
+	var stock, reserved int
+	err := r.db.QueryRowContext(ctx, `SELECT stock, reserved FROM products WHERE id = $1`, productID).Scan(&stock, &reserved)
+	if err == sql.ErrNoRows {
+		return ErrProductNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get stock: %w", err)
+	}
+	return fmt.Errorf("%w: stock=%d, reserved=%d", guardErr, stock, reserved)
+}
+
+// ListStockMovements returns a page of a product's stock movements, newest first
+func (r *ProductRepository) ListStockMovements(ctx context.Context, productID string, limit, offset int) ([]*models.StockMovement, error) {
+	query := `
+		SELECT id, product_id, delta, reserved_delta, reason, resulting_stock, created_at
+		FROM stock_movements
+		WHERE product_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, productID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stock movements: %w", err)
+	}
+	defer rows.Close()
+
+	var movements []*models.StockMovement
+	for rows.Next() {
+		var m models.StockMovement
+		if err := rows.Scan(&m.ID, &m.ProductID, &m.Delta, &m.ReservedDelta, &m.Reason, &m.ResultingStock, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan stock movement: %w", err)
+		}
+		movements = append(movements, &m)
+	}
+
+	return movements, rows.Err()
+}
+
+// CountStockMovements returns how many stock movements a product has
+func (r *ProductRepository) CountStockMovements(ctx context.Context, productID string) (int, error) {
+	var total int
+	query := `SELECT COUNT(*) FROM stock_movements WHERE product_id = $1`
+	if err := r.db.QueryRowContext(ctx, query, productID).Scan(&total); err != nil {
+		return 0, fmt.Errorf("failed to count stock movements: %w", err)
+	}
+	return total, nil
+}
+
 // Delete removes a product
 func (r *ProductRepository) Delete(ctx context.Context, id string) error {
 	query := `DELETE FROM products WHERE id = $1`
@@ -245,15 +653,67 @@ func (r *ProductRepository) Delete(ctx context.Context, id string) error {
 
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("product not found")
+		return ErrProductNotFound
 	}
 
-	cacheKey := fmt.Sprintf("product:%s", id)
+	cacheKey := r.keys.Key("product:%s", id)
 	r.redis.Del(ctx, cacheKey)
 
 	return nil
 }
 
+// SoftDeleteMany marks the given products deleted in one transaction
+// Returns the IDs that were deleted; IDs that don't exist or were already
+// deleted are omitted
+func (r *ProductRepository) SoftDeleteMany(ctx context.Context, ids []string) ([]string, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, time.Now())
+	for i, id := range ids {
+		placeholders[i] = fmt.Sprintf("$%d", i+2)
+		args = append(args, id)
+	}
+
+	query := fmt.Sprintf(`
+		UPDATE products
+		SET status = 'deleted', updated_at = $1
+		WHERE id IN (%s) AND status <> 'deleted'
+		RETURNING id
+	`, strings.Join(placeholders, ","))
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete products: %w", err)
+	}
+
+	var deleted []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan deleted product: %w", err)
+		}
+		deleted = append(deleted, id)
+	}
+	rows.Close()
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	for _, id := range deleted {
+		r.redis.Del(ctx, r.keys.Key("product:%s", id))
+	}
+
+	return deleted, nil
+}
+
 // GetMultipleByIDs retrieves multiple products
 func (r *ProductRepository) GetMultipleByIDs(ctx context.Context, ids []string) ([]*models.Product, error) {
 	if len(ids) == 0 {
@@ -268,10 +728,10 @@ func (r *ProductRepository) GetMultipleByIDs(ctx context.Context, ids []string)
 	}
 
 	query := fmt.Sprintf(`
-		SELECT id, name, description, price, stock, category, created_at, updated_at
+		SELECT %s
 		FROM products
 		WHERE id IN (%s)
-	`, strings.Join(placeholders, ","))
+	`, productColumns, strings.Join(placeholders, ","))
 
 	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -281,21 +741,130 @@ func (r *ProductRepository) GetMultipleByIDs(ctx context.Context, ids []string)
 
 	var products []*models.Product
 	for rows.Next() {
-		var product models.Product
-		err := rows.Scan(
-			&product.ID, &product.Name, &product.Description, &product.Price,
-			&product.Stock, &product.Category, &product.CreatedAt, &product.UpdatedAt,
-		)
+		product, err := scanProduct(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan product: %w", err)
 		}
-		products = append(products, &product)
+		products = append(products, product)
+	}
+
+	images, err := r.listImagesFor(ctx, placeholders, args)
+	if err != nil {
+		return nil, err
+	}
+	for _, product := range products {
+		product.Images = images[product.ID]
 	}
 
 	return products, nil
 }
 
+// listImagesFor retrieves the images of several products in one query, keyed
+// by product ID; placeholders and args are the products' IN list
+func (r *ProductRepository) listImagesFor(ctx context.Context, placeholders []string, args []interface{}) (map[string][]models.ProductImage, error) {
+	query := fmt.Sprintf(`
+		SELECT id, product_id, url, position, created_at
+		FROM product_images
+		WHERE product_id IN (%s)
+		ORDER BY product_id, position, created_at
+	`, strings.Join(placeholders, ","))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get images: %w", err)
+	}
+	defer rows.Close()
+
+	images := make(map[string][]models.ProductImage)
+	for rows.Next() {
+		var image models.ProductImage
+		if err := rows.Scan(&image.ID, &image.ProductID, &image.URL, &image.Position, &image.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan image: %w", err)
+		}
+		images[image.ProductID] = append(images[image.ProductID], image)
+	}
+
+	return images, rows.Err()
+}
+
+// AddImage attaches an image to a product
+// A nil position appends the image after the product's existing images
+func (r *ProductRepository) AddImage(ctx context.Context, image *models.ProductImage, position *int) error {
+	image.ID = uuid.New().String()
+	image.CreatedAt = time.Now()
+
+	query := `
+		INSERT INTO product_images (id, product_id, url, position, created_at)
+		VALUES ($1, $2, $3,
+			COALESCE($4, (SELECT COALESCE(MAX(position) + 1, 0) FROM product_images WHERE product_id = $2)),
+			$5)
+		RETURNING position
+	`
+
+	err := r.db.QueryRowContext(ctx, query,
+		image.ID, image.ProductID, image.URL, position, image.CreatedAt,
+	).Scan(&image.Position)
+	if err != nil {
+		return fmt.Errorf("failed to add image: %w", err)
+	}
+
+	r.redis.Del(ctx, r.keys.Key("product:%s", image.ProductID))
+
+	return nil
+}
+
+// ListImages returns a product's images in display order
+func (r *ProductRepository) ListImages(ctx context.Context, productID string) ([]models.ProductImage, error) {
+	query := `
+		SELECT id, product_id, url, position, created_at
+		FROM product_images
+		WHERE product_id = $1
+		ORDER BY position, created_at
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get images: %w", err)
+	}
+	defer rows.Close()
+
+	var images []models.ProductImage
+	for rows.Next() {
+		var image models.ProductImage
+		if err := rows.Scan(&image.ID, &image.ProductID, &image.URL, &image.Position, &image.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan image: %w", err)
+		}
+		images = append(images, image)
+	}
+
+	return images, rows.Err()
+}
+
+// DeleteImage removes one of a product's images
+func (r *ProductRepository) DeleteImage(ctx context.Context, productID, imageID string) error {
+	query := `DELETE FROM product_images WHERE id = $1 AND product_id = $2`
+
+	result, err := r.db.ExecContext(ctx, query, imageID, productID)
+	if err != nil {
+		return fmt.Errorf("failed to delete image: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrImageNotFound
+	}
+
+	r.redis.Del(ctx, r.keys.Key("product:%s", productID))
+
+	return nil
+}
+
 // HealthCheck verifies database connectivity
 func (r *ProductRepository) HealthCheck(ctx context.Context) error {
 	return r.db.PingContext(ctx)
 }
+
+// CacheHealthCheck pings Redis
+func (r *ProductRepository) CacheHealthCheck(ctx context.Context) error {
+	return r.redis.Ping(ctx).Err()
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"ecommerce/product-service/repository"
+	apperrors "ecommerce/shared/errors"
+	"ecommerce/shared/models"
+)
+
+var (
+	ErrCategoryNotFound     = apperrors.NotFound("category_not_found", "category not found")
+	ErrParentNotFound       = apperrors.Validation("parent_not_found", "parent category not found")
+	ErrDuplicateCategory    = apperrors.Conflict("duplicate_category", "a category with this slug already exists")
+	ErrCategoryInUse        = apperrors.Conflict("category_in_use", "category has subcategories or products")
+	ErrCategoryNameRequired = apperrors.Validation("category_name_required", "category name is required")
+	ErrInvalidSlug          = apperrors.Validation("invalid_slug", "slug must contain only lowercase letters, digits and hyphens")
+	ErrCategoryCycle        = apperrors.Validation("category_cycle", "a category cannot be nested under itself or its subcategories")
+	ErrUnknownCategory      = apperrors.Validation("unknown_category", "category does not exist")
+)
+
+// defaultCategory is used when a product is created without one; the
+// migrations seed it
+const defaultCategory = "Uncategorized"
+
+// Slugify turns a category name into its slug: lowercase ASCII letters and
+// digits, with every other run of characters replaced by a single hyphen
+// Keep in sync with the seed migration, which does the same in SQL
+func Slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+			continue
+		}
+		hyphen = true
+	}
+	return b.String()
+}
+
+// ListCategories returns every category
+func (s *ProductService) ListCategories(ctx context.Context) ([]*models.Category, error) {
+	categories, err := s.categories.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if categories == nil {
+		categories = []*models.Category{}
+	}
+	return categories, nil
+}
+
+// GetCategory retrieves a category by ID
+func (s *ProductService) GetCategory(ctx context.Context, id string) (*models.Category, error) {
+	category, err := s.categories.GetByID(ctx, id)
+	if errors.Is(err, repository.ErrCategoryNotFound) {
+		return nil, ErrCategoryNotFound
+	}
+	return category, err
+}
+
+// CreateCategory adds a category; the slug is derived from the name unless given
+func (s *ProductService) CreateCategory(ctx context.Context, category *models.Category) error {
+	if err := s.prepareCategory(ctx, category); err != nil {
+		return err
+	}
+
+	if err := s.categories.Create(ctx, category); err != nil {
+		return categoryError(err)
+	}
+	return nil
+}
+
+// UpdateCategory replaces a category's name, slug and parent
+// Products filed under the old name move to the new one
+func (s *ProductService) UpdateCategory(ctx context.Context, category *models.Category) error {
+	existing, err := s.GetCategory(ctx, category.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.prepareCategory(ctx, category); err != nil {
+		return err
+	}
+	if category.ParentID != nil {
+		cycle, err := s.categories.IsDescendant(ctx, *category.ParentID, category.ID)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return ErrCategoryCycle
+		}
+	}
+
+	if err := s.categories.Update(ctx, category, existing.Name); err != nil {
+		return categoryError(err)
+	}
+	category.CreatedAt = existing.CreatedAt
+	return nil
+}
+
+// DeleteCategory removes a category with no subcategories or products
+func (s *ProductService) DeleteCategory(ctx context.Context, id string) error {
+	return categoryError(s.categories.Delete(ctx, id))
+}
+
+// prepareCategory validates a category and fills in its slug
+func (s *ProductService) prepareCategory(ctx context.Context, category *models.Category) error {
+	category.Name = strings.TrimSpace(category.Name)
+	if category.Name == "" {
+		return ErrCategoryNameRequired
+	}
+
+	if category.Slug == "" {
+		category.Slug = Slugify(category.Name)
+	} else if Slugify(category.Slug) != category.Slug {
+		return ErrInvalidSlug
+	}
+	if category.Slug == "" {
+		return ErrInvalidSlug
+	}
+
+	if category.ParentID != nil {
+		if _, err := s.categories.GetByID(ctx, *category.ParentID); err != nil {
+			if errors.Is(err, repository.ErrCategoryNotFound) {
+				return ErrParentNotFound
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveCategory returns the canonical name of the category a product is
+// filed under, matching by slug so "electronics" finds "Electronics"
+// Unknown categories are rejected, or created when autoCreateCategories is set
+func (s *ProductService) resolveCategory(ctx context.Context, name string) (string, error) {
+	slug := Slugify(name)
+	if slug == "" {
+		return "", fmt.Errorf("%w: %q", ErrUnknownCategory, name)
+	}
+
+	category, err := s.categories.GetBySlug(ctx, slug)
+	if err == nil {
+		return category.Name, nil
+	}
+	if !errors.Is(err, repository.ErrCategoryNotFound) {
+		return "", err
+	}
+	if !s.autoCreateCategories {
+		return "", fmt.Errorf("%w: %q", ErrUnknownCategory, name)
+	}
+
+	category = &models.Category{Name: strings.TrimSpace(name), Slug: slug}
+	if err := s.categories.Create(ctx, category); err != nil {
+		// Created concurrently by another request
+		if errors.Is(err, repository.ErrDuplicateCategory) {
+			if existing, getErr := s.categories.GetBySlug(ctx, slug); getErr == nil {
+				return existing.Name, nil
+			}
+		}
+		return "", err
+	}
+	return category.Name, nil
+}
+
+// categoryError maps repository category errors to the service's errors
+func categoryError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, repository.ErrCategoryNotFound):
+		return ErrCategoryNotFound
+	case errors.Is(err, repository.ErrParentNotFound):
+		return ErrParentNotFound
+	case errors.Is(err, repository.ErrDuplicateCategory):
+		return ErrDuplicateCategory
+	case errors.Is(err, repository.ErrCategoryInUse):
+		return ErrCategoryInUse
+	default:
+		return err
+	}
+}
@@ -4,31 +4,105 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
+	"go.uber.org/zap"
+
+	"ecommerce/product-service/messaging"
 	"ecommerce/product-service/repository"
+	apperrors "ecommerce/shared/errors"
+	sharedmessaging "ecommerce/shared/messaging"
 	"ecommerce/shared/models"
+	"ecommerce/shared/pagination"
 )
 
 var (
-	ErrProductNotFound   = errors.New("product not found")
-	ErrInsufficientStock = errors.New("insufficient stock")
-	ErrInvalidPrice      = errors.New("price must be positive")
-	ErrInvalidStock      = errors.New("stock cannot be negative")
+	ErrProductNotFound   = apperrors.NotFound("product_not_found", "product not found")
+	ErrInsufficientStock = apperrors.Conflict("insufficient_stock", "insufficient stock")
+	ErrNoReservation     = apperrors.Conflict("no_reservation", "not enough reserved stock")
+	ErrBelowReserved     = apperrors.Conflict("stock_below_reserved", "stock cannot be set below reserved stock")
+	ErrNameRequired      = apperrors.Validation("name_required", "product name is required")
+	ErrInvalidPrice      = apperrors.Validation("invalid_price", "price must be positive")
+	ErrInvalidStock      = apperrors.Validation("invalid_stock", "stock cannot be negative")
+	ErrInvalidQuantity   = apperrors.Validation("invalid_quantity", "quantity must be positive")
+	ErrInvalidStatus     = apperrors.Validation("invalid_status", "status must be one of: draft, published, archived")
+	ErrNoProductIDs      = apperrors.Validation("no_product_ids", "at least one product ID is required")
+	ErrTooManyProductIDs = apperrors.Validation("too_many_product_ids", fmt.Sprintf("at most %d products can be deleted at once", maxBulkDelete))
+	ErrInvalidPriceRange = apperrors.Validation("invalid_price_range", "min_price cannot be greater than max_price")
+	ErrInvalidCurrency   = apperrors.Validation("invalid_currency", "currency must be a supported ISO 4217 code")
+	ErrDuplicateSKU      = apperrors.Conflict("duplicate_sku", "sku already exists")
+	ErrInvalidImageURL   = apperrors.Validation("invalid_image_url", "image url must be an absolute http or https url")
+	ErrInvalidPosition   = apperrors.Validation("invalid_position", "image position cannot be negative")
+	ErrImageNotFound     = apperrors.NotFound("image_not_found", "image not found")
+	ErrInvalidThreshold  = apperrors.Validation("invalid_threshold", "low_stock_threshold cannot be negative")
+	ErrInvalidReason     = apperrors.Validation("invalid_reason", "reason must be one of: order_reservation, order_release, manual")
+	// ErrConcurrentUpdate is retryable, and not a conflict: order-service reads 409 as insufficient stock
+	ErrConcurrentUpdate = apperrors.Unavailable("concurrent_update", "product was modified concurrently, please retry")
 )
 
+// stockReasons lists the reasons a stock change may be recorded with
+var stockReasons = map[string]bool{
+	models.StockReasonOrderReservation: true,
+	models.StockReasonOrderRelease:     true,
+	models.StockReasonManual:           true,
+}
+
+// defaultCurrency is used when a product is created without one
+const defaultCurrency = "USD"
+
+// validCurrencies lists the ISO 4217 codes products may be priced in
+var validCurrencies = map[string]bool{
+	"USD": true, "EUR": true, "GBP": true, "CAD": true, "AUD": true,
+	"JPY": true, "CHF": true, "NGN": true, "INR": true, "CNY": true,
+}
+
+// maxBulkDelete caps how many products one bulk delete may touch
+const maxBulkDelete = 100
+
+// validStatuses lists the product lifecycle statuses accepted on create/update
+var validStatuses = map[string]bool{
+	models.ProductStatusDraft:     true,
+	models.ProductStatusPublished: true,
+	models.ProductStatusArchived:  true,
+}
+
+// ProductFilter narrows product listings by category, search term and price
+type ProductFilter = repository.ProductFilter
+
 type ProductService struct {
-	repo *repository.ProductRepository
+	repo                 *repository.ProductRepository
+	categories           *repository.CategoryRepository
+	publisher            *messaging.RabbitMQPublisher
+	lowStockThreshold    int  // Used for products without their own threshold
+	autoCreateCategories bool // Create unknown product categories instead of rejecting them
+	logger               *zap.Logger
 }
 
-func NewProductService(repo *repository.ProductRepository) *ProductService {
-	return &ProductService{repo: repo}
+func NewProductService(
+	repo *repository.ProductRepository,
+	categories *repository.CategoryRepository,
+	publisher *messaging.RabbitMQPublisher,
+	lowStockThreshold int,
+	autoCreateCategories bool,
+	logger *zap.Logger,
+) *ProductService {
+	return &ProductService{
+		repo:                 repo,
+		categories:           categories,
+		publisher:            publisher,
+		lowStockThreshold:    lowStockThreshold,
+		autoCreateCategories: autoCreateCategories,
+		logger:               logger,
+	}
 }
 
 // CreateProduct creates a new product
 func (s *ProductService) CreateProduct(ctx context.Context, product *models.Product) (*models.Product, error) {
 	// Validate input
 	if product.Name == "" {
-		return nil, errors.New("product name is required")
+		return nil, ErrNameRequired
 	}
 	if product.Price <= 0 {
 		return nil, ErrInvalidPrice
@@ -36,13 +110,41 @@ func (s *ProductService) CreateProduct(ctx context.Context, product *models.Prod
 	if product.Stock < 0 {
 		return nil, ErrInvalidStock
 	}
+	if product.LowStockThreshold != nil && *product.LowStockThreshold < 0 {
+		return nil, ErrInvalidThreshold
+	}
 
 	// Set default category if empty
 	if product.Category == "" {
-		product.Category = "Uncategorized"
+		product.Category = defaultCategory
+	}
+	category, err := s.resolveCategory(ctx, product.Category)
+	if err != nil {
+		return nil, err
+	}
+	product.Category = category
+
+	product.SKU = strings.TrimSpace(product.SKU)
+	product.Currency = strings.ToUpper(strings.TrimSpace(product.Currency))
+	if product.Currency == "" {
+		product.Currency = defaultCurrency
+	}
+	if !validCurrencies[product.Currency] {
+		return nil, ErrInvalidCurrency
+	}
+
+	// New products are orderable unless created as drafts
+	if product.Status == "" {
+		product.Status = models.ProductStatusPublished
+	}
+	if !validStatuses[product.Status] {
+		return nil, ErrInvalidStatus
 	}
 
 	if err := s.repo.Create(ctx, product); err != nil {
+		if errors.Is(err, repository.ErrDuplicateSKU) {
+			return nil, ErrDuplicateSKU
+		}
 		return nil, fmt.Errorf("failed to create product: %w", err)
 	}
 
@@ -52,62 +154,80 @@ func (s *ProductService) CreateProduct(ctx context.Context, product *models.Prod
 // GetProductByID retrieves a product by ID
 func (s *ProductService) GetProductByID(ctx context.Context, id string) (*models.Product, error) {
 	product, err := s.repo.GetByID(ctx, id)
-	if err != nil {
+	if errors.Is(err, repository.ErrProductNotFound) {
 		return nil, ErrProductNotFound
 	}
+	if err != nil {
+		return nil, err
+	}
 	return product, nil
 }
 
-// ListProducts retrieves products with pagination
-func (s *ProductService) ListProducts(ctx context.Context, page, pageSize int, category string) ([]*models.Product, error) {
-	if page < 1 {
-		page = 1
+// ListProducts retrieves a page of products and the total matching the filter
+func (s *ProductService) ListProducts(ctx context.Context, page pagination.Page, filter ProductFilter) ([]*models.Product, int, error) {
+	if filter.MinPrice != nil && filter.MaxPrice != nil && *filter.MinPrice > *filter.MaxPrice {
+		return nil, 0, ErrInvalidPriceRange
 	}
-	if pageSize < 1 || pageSize > 100 {
-		pageSize = 20
+
+	products, err := s.repo.List(ctx, page.Size, page.Offset(), filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	if products == nil {
+		products = []*models.Product{} // Serialize as [] rather than null
 	}
 
-	offset := (page - 1) * pageSize
-	return s.repo.List(ctx, pageSize, offset, category)
+	total, err := s.repo.Count(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return products, total, nil
 }
 
-// SearchProducts searches products by name
-func (s *ProductService) SearchProducts(ctx context.Context, query string, page, pageSize int) ([]*models.Product, error) {
-	if query == "" {
-		return s.ListProducts(ctx, page, pageSize, "")
+// ListProductsAfter retrieves a page of products after the cursor, newest
+// first, and the cursor for the next page ("" on the last page)
+func (s *ProductService) ListProductsAfter(ctx context.Context, after *pagination.Cursor, size int, filter ProductFilter) ([]*models.Product, string, error) {
+	if filter.MinPrice != nil && filter.MaxPrice != nil && *filter.MinPrice > *filter.MaxPrice {
+		return nil, "", ErrInvalidPriceRange
 	}
 
-	if page < 1 {
-		page = 1
+	// One extra row tells us whether another page follows
+	products, err := s.repo.ListAfter(ctx, size+1, after, filter)
+	if err != nil {
+		return nil, "", err
 	}
-	if pageSize < 1 || pageSize > 100 {
-		pageSize = 20
+	if products == nil {
+		products = []*models.Product{}
 	}
 
-	offset := (page - 1) * pageSize
-	return s.repo.SearchByName(ctx, query, pageSize, offset)
-}
-
-// GetProductsByCategory retrieves products in a category
-func (s *ProductService) GetProductsByCategory(ctx context.Context, category string, page, pageSize int) ([]*models.Product, error) {
-	if page < 1 {
-		page = 1
-	}
-	if pageSize < 1 || pageSize > 100 {
-		pageSize = 20
+	next := ""
+	if len(products) > size {
+		products = products[:size]
+		last := products[size-1]
+		next = pagination.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
 	}
 
-	offset := (page - 1) * pageSize
-	return s.repo.GetByCategory(ctx, category, pageSize, offset)
+	return products, next, nil
+}
+
+// ListProductCategories returns the categories products are filed under, with
+// how many products each has (the storefront's category menu)
+func (s *ProductService) ListProductCategories(ctx context.Context) ([]models.CategoryCount, error) {
+	return s.repo.ListCategories(ctx)
 }
 
 // UpdateProduct updates product information
+// Stock is ignored; it changes through UpdateStock and SetStock
 func (s *ProductService) UpdateProduct(ctx context.Context, id string, updates *models.Product) (*models.Product, error) {
 	// Get existing product
 	existing, err := s.repo.GetByID(ctx, id)
-	if err != nil {
+	if errors.Is(err, repository.ErrProductNotFound) {
 		return nil, ErrProductNotFound
 	}
+	if err != nil {
+		return nil, err
+	}
 
 	// Update fields
 	if updates.Name != "" {
@@ -119,53 +239,268 @@ func (s *ProductService) UpdateProduct(ctx context.Context, id string, updates *
 	if updates.Price > 0 {
 		existing.Price = updates.Price
 	}
-	if updates.Stock >= 0 {
-		existing.Stock = updates.Stock
-	}
 	if updates.Category != "" {
-		existing.Category = updates.Category
+		category, err := s.resolveCategory(ctx, updates.Category)
+		if err != nil {
+			return nil, err
+		}
+		existing.Category = category
+	}
+	if sku := strings.TrimSpace(updates.SKU); sku != "" {
+		existing.SKU = sku
+	}
+	if updates.Currency != "" {
+		currency := strings.ToUpper(strings.TrimSpace(updates.Currency))
+		if !validCurrencies[currency] {
+			return nil, ErrInvalidCurrency
+		}
+		existing.Currency = currency
+	}
+	if updates.LowStockThreshold != nil {
+		if *updates.LowStockThreshold < 0 {
+			return nil, ErrInvalidThreshold
+		}
+		existing.LowStockThreshold = updates.LowStockThreshold
+	}
+	if updates.Status != "" {
+		if !validStatuses[updates.Status] {
+			return nil, ErrInvalidStatus
+		}
+		existing.Status = updates.Status
 	}
 
 	if err := s.repo.Update(ctx, existing); err != nil {
+		if errors.Is(err, repository.ErrDuplicateSKU) {
+			return nil, ErrDuplicateSKU
+		}
+		if errors.Is(err, repository.ErrProductNotFound) {
+			return nil, ErrProductNotFound
+		}
 		return nil, fmt.Errorf("failed to update product: %w", err)
 	}
 
 	return existing, nil
 }
 
-// UpdateStock updates product stock (called by Order Service)
-func (s *ProductService) UpdateStock(ctx context.Context, productID string, quantity int) error {
-	return s.repo.UpdateStock(ctx, productID, quantity)
+// UpdateStock adjusts product stock directly (admins, and order-service
+// restoring stock of cancelled paid orders); an empty reason is recorded as a
+// manual adjustment. Stock can't drop below what unpaid orders have reserved
+func (s *ProductService) UpdateStock(ctx context.Context, productID string, quantity int, reason string) error {
+	if reason == "" {
+		reason = models.StockReasonManual
+	}
+	if !stockReasons[reason] {
+		return ErrInvalidReason
+	}
+
+	change, err := s.repo.UpdateStock(ctx, productID, quantity, reason)
+	if err != nil {
+		return stockError(err)
+	}
+	s.checkLowStock(change)
+	return nil
 }
 
-// ReserveStock reserves stock for an order (decreases stock)
+// SetStock sets a product's stock to an absolute count, e.g. after a
+// stocktake, recording the difference as a manual correction
+func (s *ProductService) SetStock(ctx context.Context, productID string, stock int) error {
+	if stock < 0 {
+		return ErrInvalidStock
+	}
+
+	change, err := s.repo.SetStock(ctx, productID, stock, models.StockReasonManualCorrection)
+	if err != nil {
+		return stockError(err)
+	}
+	s.checkLowStock(change)
+	return nil
+}
+
+// ReserveStock holds stock for an unpaid order; it stays in stock until
+// CommitStock, and ReleaseStock gives it back
 func (s *ProductService) ReserveStock(ctx context.Context, productID string, quantity int) error {
 	if quantity <= 0 {
-		return errors.New("quantity must be positive")
+		return ErrInvalidQuantity
+	}
+	return stockError(s.repo.ReserveStock(ctx, productID, quantity))
+}
+
+// CommitStock takes a paid order's reserved stock out of stock
+func (s *ProductService) CommitStock(ctx context.Context, productID string, quantity int) error {
+	if quantity <= 0 {
+		return ErrInvalidQuantity
 	}
 
-	// Decrease stock (negative quantity)
-	return s.repo.UpdateStock(ctx, productID, -quantity)
+	change, err := s.repo.CommitStock(ctx, productID, quantity)
+	if err != nil {
+		return stockError(err)
+	}
+	s.checkLowStock(change)
+	return nil
 }
 
-// ReleaseStock releases reserved stock (increases stock) - for cancelled orders
+// ReleaseStock gives back stock reserved by an order that was cancelled or
+// never paid
 func (s *ProductService) ReleaseStock(ctx context.Context, productID string, quantity int) error {
 	if quantity <= 0 {
-		return errors.New("quantity must be positive")
+		return ErrInvalidQuantity
+	}
+	return stockError(s.repo.ReleaseStock(ctx, productID, quantity))
+}
+
+// stockError maps repository errors from stock changes to the service's errors
+func stockError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, repository.ErrConcurrentUpdate):
+		return ErrConcurrentUpdate
+	case errors.Is(err, repository.ErrInsufficientStock):
+		return fmt.Errorf("%w: %v", ErrInsufficientStock, err)
+	case errors.Is(err, repository.ErrNoReservation):
+		return fmt.Errorf("%w: %v", ErrNoReservation, err)
+	case errors.Is(err, repository.ErrStockBelowReserved):
+		return fmt.Errorf("%w: %v", ErrBelowReserved, err)
+	case errors.Is(err, repository.ErrProductNotFound):
+		return ErrProductNotFound
+	default:
+		return err
+	}
+}
+
+// checkLowStock raises a low-stock event when a change takes the product
+// from at/above its threshold to below it
+func (s *ProductService) checkLowStock(change *repository.StockChange) {
+	threshold := s.lowStockThreshold
+	if change.Threshold != nil {
+		threshold = *change.Threshold
 	}
 
-	// Increase stock (positive quantity)
-	return s.repo.UpdateStock(ctx, productID, quantity)
+	// Only the downward crossing alerts; further sales below the threshold don't
+	if change.Previous >= threshold && change.Current < threshold {
+		event := messaging.ProductEvent{
+			Type:      sharedmessaging.EventProductLowStock,
+			ProductID: change.ProductID,
+			Name:      change.Name,
+			Stock:     change.Current,
+			Threshold: threshold,
+			CreatedAt: time.Now(),
+		}
+		go func() {
+			if err := s.publisher.PublishProductEvent(event); err != nil {
+				s.logger.Error("Failed to publish low stock event",
+					zap.String("product_id", event.ProductID),
+					zap.Error(err),
+				)
+			}
+		}()
+	}
+}
+
+// GetStockHistory returns a page of a product's stock movements and their total
+func (s *ProductService) GetStockHistory(ctx context.Context, productID string, page pagination.Page) ([]*models.StockMovement, int, error) {
+	if _, err := s.GetProductByID(ctx, productID); err != nil {
+		return nil, 0, err
+	}
+
+	movements, err := s.repo.ListStockMovements(ctx, productID, page.Size, page.Offset())
+	if err != nil {
+		return nil, 0, err
+	}
+	if movements == nil {
+		movements = []*models.StockMovement{}
+	}
+
+	total, err := s.repo.CountStockMovements(ctx, productID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return movements, total, nil
 }
 
 // DeleteProduct removes a product
 func (s *ProductService) DeleteProduct(ctx context.Context, id string) error {
-	return s.repo.Delete(ctx, id)
+	err := s.repo.Delete(ctx, id)
+	if errors.Is(err, repository.ErrProductNotFound) {
+		return ErrProductNotFound
+	}
+	return err
+}
+
+// BulkDeleteProducts soft-deletes products and reports the outcome per ID
+// ("deleted" or "not_found") (admin only)
+func (s *ProductService) BulkDeleteProducts(ctx context.Context, ids []string) (map[string]string, error) {
+	if len(ids) == 0 {
+		return nil, ErrNoProductIDs
+	}
+	if len(ids) > maxBulkDelete {
+		return nil, ErrTooManyProductIDs
+	}
+
+	deleted, err := s.repo.SoftDeleteMany(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete products: %w", err)
+	}
+
+	results := make(map[string]string, len(ids))
+	for _, id := range ids {
+		results[id] = "not_found"
+	}
+	for _, id := range deleted {
+		results[id] = "deleted"
+	}
+
+	return results, nil
+}
+
+// AddProductImage attaches an image URL to a product
+// A nil position places it after the existing images
+func (s *ProductService) AddProductImage(ctx context.Context, productID, imageURL string, position *int) (*models.ProductImage, error) {
+	parsed, err := url.ParseRequestURI(imageURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, ErrInvalidImageURL
+	}
+	if position != nil && *position < 0 {
+		return nil, ErrInvalidPosition
+	}
+
+	if _, err := s.GetProductByID(ctx, productID); err != nil {
+		return nil, err
+	}
+
+	image := &models.ProductImage{
+		ProductID: productID,
+		URL:       imageURL,
+	}
+	if err := s.repo.AddImage(ctx, image, position); err != nil {
+		return nil, fmt.Errorf("failed to add image: %w", err)
+	}
+
+	return image, nil
+}
+
+// DeleteProductImage removes an image from a product
+func (s *ProductService) DeleteProductImage(ctx context.Context, productID, imageID string) error {
+	if err := s.repo.DeleteImage(ctx, productID, imageID); err != nil {
+		if errors.Is(err, repository.ErrImageNotFound) {
+			return ErrImageNotFound
+		}
+		return fmt.Errorf("failed to delete image: %w", err)
+	}
+	return nil
 }
 
 // GetMultipleProducts retrieves multiple products by IDs (for order validation)
 func (s *ProductService) GetMultipleProducts(ctx context.Context, ids []string) ([]*models.Product, error) {
-	return s.repo.GetMultipleByIDs(ctx, ids)
+	products, err := s.repo.GetMultipleByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	if products == nil {
+		products = []*models.Product{}
+	}
+	return products, nil
 }
 
 // CheckStockAvailability checks if products have sufficient stock
@@ -192,11 +527,11 @@ func (s *ProductService) CheckStockAvailability(ctx context.Context, items map[s
 	for productID, quantity := range items {
 		product, exists := productMap[productID]
 		if !exists {
-			return fmt.Errorf("product %s not found", productID)
+			return fmt.Errorf("%w: %s", ErrProductNotFound, productID)
 		}
-		if product.Stock < quantity {
-			return fmt.Errorf("insufficient stock for %s: available=%d, requested=%d",
-				product.Name, product.Stock, quantity)
+		if product.Available() < quantity {
+			return fmt.Errorf("%w for %s: available=%d, requested=%d",
+				ErrInsufficientStock, product.Name, product.Available(), quantity)
 		}
 	}
 
@@ -207,3 +542,8 @@ func (s *ProductService) CheckStockAvailability(ctx context.Context, items map[s
 func (s *ProductService) HealthCheck(ctx context.Context) error {
 	return s.repo.HealthCheck(ctx)
 }
+
+// CacheHealthCheck verifies Redis; the service keeps working without it
+func (s *ProductService) CacheHealthCheck(ctx context.Context) error {
+	return s.repo.CacheHealthCheck(ctx)
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+
+	"ecommerce/shared/models"
+)
+
+// Context keys set by Middleware for downstream handlers
+const (
+	ContextUserID = "user_id"
+	ContextRole   = "role"
+)
+
+// Claims holds the identity carried in a user-service JWT
+type Claims struct {
+	ID        string // jti, used to revoke the token on logout
+	UserID    string
+	Email     string
+	Role      string
+	ExpiresAt time.Time
+}
+
+// DefaultAlgorithms are the JWT signing algorithms accepted when none are configured
+var DefaultAlgorithms = []string{"HS256"}
+
+// SigningMethod returns the method new tokens are signed with: the first of the
+// allowed algorithms, which must be HMAC since tokens share one secret
+// Listing an older algorithm after it keeps existing tokens valid during a rotation
+func SigningMethod(allowedAlgs []string) (*jwt.SigningMethodHMAC, error) {
+	if len(allowedAlgs) == 0 {
+		allowedAlgs = DefaultAlgorithms
+	}
+	method, ok := jwt.GetSigningMethod(allowedAlgs[0]).(*jwt.SigningMethodHMAC)
+	if !ok {
+		return nil, fmt.Errorf("unsupported signing algorithm: %q", allowedAlgs[0])
+	}
+	return method, nil
+}
+
+// ParseToken verifies an HMAC-signed token issued by user-service and returns its claims
+// The verification secret is picked from keys by the token's kid header
+// Tokens signed with an algorithm outside allowedAlgs (DefaultAlgorithms if empty)
+// are rejected, even other HMAC variants
+func ParseToken(tokenString string, keys *Keyring, allowedAlgs []string) (*Claims, error) {
+	if len(allowedAlgs) == 0 {
+		allowedAlgs = DefaultAlgorithms
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		// Verify signing method
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		secret, ok := keys.Lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown key ID: %q", kid)
+		}
+		return secret, nil
+	}, jwt.WithValidMethods(allowedAlgs))
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token claims")
+	}
+
+	userID, ok := claims["user_id"].(string)
+	if !ok || userID == "" {
+		return nil, errors.New("invalid user_id in token")
+	}
+
+	tokenID, _ := claims["jti"].(string)
+	email, _ := claims["email"].(string)
+	role, _ := claims["role"].(string)
+
+	var expiresAt time.Time
+	if exp, ok := claims["exp"].(float64); ok {
+		expiresAt = time.Unix(int64(exp), 0)
+	}
+
+	return &Claims{
+		ID:        tokenID,
+		UserID:    userID,
+		Email:     email,
+		Role:      role,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// Middleware validates the Bearer token and stores the caller's ID and role in context
+// Services use it where identity must come from the token, not client-supplied headers
+// Tokens revoked via logout are rejected when a denylist is given
+func Middleware(keys *Keyring, allowedAlgs []string, denylist *Denylist) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Format: "Bearer <token>"
+		parts := strings.Split(c.GetHeader("Authorization"), " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			c.JSON(http.StatusUnauthorized, models.APIResponse{
+				Success: false,
+				Error:   "Authorization header required",
+			})
+			c.Abort()
+			return
+		}
+
+		claims, err := ParseToken(parts[1], keys, allowedAlgs)
+		if err != nil || (denylist != nil && denylist.IsDenied(c.Request.Context(), claims.ID)) {
+			c.JSON(http.StatusUnauthorized, models.APIResponse{
+				Success: false,
+				Error:   "Invalid or expired token",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set(ContextUserID, claims.UserID)
+		c.Set(ContextRole, claims.Role)
+		c.Next()
+	}
+}
+
+// RequireRole allows the request only if the caller has one of the given roles
+// Must be used after Middleware
+func RequireRole(roles ...string) gin.HandlerFunc {
+	allowed := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		allowed[role] = true
+	}
+
+	return func(c *gin.Context) {
+		if !allowed[c.GetString(ContextRole)] {
+			c.JSON(http.StatusForbidden, models.APIResponse{
+				Success: false,
+				Error:   "Access denied: insufficient role",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"ecommerce/shared/cache"
+)
+
+// Denylist records revoked token IDs (the jti claim) in Redis until the
+// token would have expired anyway
+type Denylist struct {
+	redis *redis.Client
+	keys  cache.Keyer
+}
+
+// NewDenylist creates a token denylist backed by Redis
+func NewDenylist(redisClient *redis.Client, keys cache.Keyer) *Denylist {
+	return &Denylist{
+		redis: redisClient,
+		keys:  keys,
+	}
+}
+
+// Deny revokes a token ID for the rest of the token's lifetime
+func (d *Denylist) Deny(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil // Already expired - nothing to revoke
+	}
+	return d.redis.Set(ctx, d.key(tokenID), "1", ttl).Err()
+}
+
+// IsDenied reports whether a token ID has been revoked
+// Redis errors are treated as not denied, matching how the services treat
+// Redis as non-critical; signature and expiry checks still apply
+func (d *Denylist) IsDenied(ctx context.Context, tokenID string) bool {
+	if tokenID == "" {
+		return false
+	}
+	n, err := d.redis.Exists(ctx, d.key(tokenID)).Result()
+	return err == nil && n > 0
+}
+
+func (d *Denylist) key(tokenID string) string {
+	return d.keys.Key("token_denylist:%s", tokenID)
+}
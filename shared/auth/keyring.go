@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Keyring holds the JWT secrets accepted for verification, by key ID (the kid
+// header), and which of them signs new tokens
+// Keeping the previous secret during a rotation window lets tokens signed
+// with it stay valid until they expire
+type Keyring struct {
+	primaryID string
+	secrets   map[string][]byte
+}
+
+// NewKeyring creates a keyring signing with primarySecret under primaryID
+// previous lists retired secrets still accepted, as "kid:secret" entries
+// Tokens without a kid header are verified with the key whose ID is empty,
+// so an entry like ":old-secret" covers tokens issued before key IDs were used
+func NewKeyring(primaryID, primarySecret string, previous []string) (*Keyring, error) {
+	k := &Keyring{
+		primaryID: primaryID,
+		secrets:   map[string][]byte{primaryID: []byte(primarySecret)},
+	}
+
+	for i, entry := range previous {
+		id, secret, ok := strings.Cut(entry, ":")
+		if !ok || secret == "" {
+			// Don't echo the entry; it may be a bare secret
+			return nil, fmt.Errorf("previous JWT secret %d must be \"kid:secret\"", i+1)
+		}
+		if _, exists := k.secrets[id]; exists {
+			return nil, fmt.Errorf("duplicate JWT key ID %q", id)
+		}
+		k.secrets[id] = []byte(secret)
+	}
+
+	return k, nil
+}
+
+// PrimaryID returns the key ID new tokens carry in their kid header
+func (k *Keyring) PrimaryID() string {
+	return k.primaryID
+}
+
+// Primary returns the secret new tokens are signed with
+func (k *Keyring) Primary() []byte {
+	return k.secrets[k.primaryID]
+}
+
+// Lookup returns the secret for a token's key ID
+func (k *Keyring) Lookup(id string) ([]byte, bool) {
+	secret, ok := k.secrets[id]
+	return secret, ok
+}
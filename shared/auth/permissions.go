@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+
+	"ecommerce/shared/models"
+)
+
+// Permissions are named capabilities granted to roles; routes check these
+// instead of role names so new roles don't mean touching every route
+const (
+	PermProfileRead        = "profile.read"
+	PermProfileWrite       = "profile.write"
+	PermOrderCreate        = "order.create"
+	PermOrderReadOwn       = "order.read_own"
+	PermOrderCancelOwn     = "order.cancel_own"
+	PermOrderFulfill       = "order.fulfill" // Ship orders and record delivery
+	PermOrderManage        = "order.manage"
+	PermProductWrite       = "product.write"
+	PermInventoryRead      = "inventory.read"
+	PermUserManage         = "user.manage"
+	PermMaintenanceManage  = "maintenance.manage"
+	PermNotificationManage = "notification.manage" // Edit notification templates
+)
+
+// Roles a user can have
+const (
+	RoleCustomer  = "customer"
+	RoleWarehouse = "warehouse"
+	RoleAdmin     = "admin"
+)
+
+// allPermissions is every permission; admin is granted all of them
+var allPermissions = []string{
+	PermProfileRead, PermProfileWrite,
+	PermOrderCreate, PermOrderReadOwn, PermOrderCancelOwn,
+	PermOrderFulfill, PermOrderManage,
+	PermProductWrite, PermInventoryRead,
+	PermUserManage, PermMaintenanceManage,
+	PermNotificationManage,
+}
+
+// customerPermissions are granted to every signed-in user
+var customerPermissions = []string{
+	PermProfileRead, PermProfileWrite,
+	PermOrderCreate, PermOrderReadOwn, PermOrderCancelOwn,
+}
+
+// rolePermissions maps each role to its permissions
+var rolePermissions = map[string]map[string]bool{
+	RoleCustomer:  permissionSet(customerPermissions),
+	RoleWarehouse: permissionSet(customerPermissions, PermOrderFulfill),
+	RoleAdmin:     permissionSet(allPermissions),
+}
+
+func permissionSet(base []string, extra ...string) map[string]bool {
+	set := make(map[string]bool, len(base)+len(extra))
+	for _, perm := range append(append([]string{}, base...), extra...) {
+		set[perm] = true
+	}
+	return set
+}
+
+// IsRole reports whether role is one users can be given
+func IsRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether role grants perm; unknown roles grant nothing
+func HasPermission(role, perm string) bool {
+	if role == RoleService {
+		return servicePermissions[perm]
+	}
+	return rolePermissions[role][perm]
+}
+
+// PermissionsForRole returns the permissions role grants, sorted
+func PermissionsForRole(role string) []string {
+	perms := make([]string, 0, len(rolePermissions[role]))
+	for perm := range rolePermissions[role] {
+		perms = append(perms, perm)
+	}
+	sort.Strings(perms)
+	return perms
+}
+
+// RequirePermission allows the request only if the caller's role grants perm
+// Must be used after Middleware (or anything else that sets ContextRole)
+func RequirePermission(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasPermission(c.GetString(ContextRole), perm) {
+			c.JSON(http.StatusForbidden, models.APIResponse{
+				Success: false,
+				Error:   "Access denied: missing permission " + perm,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,101 @@
+package binding
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+
+	"ecommerce/shared/models"
+)
+
+// BindJSON decodes the request body into obj
+// On failure it writes a 400 with a readable message and returns false, so
+// handlers can simply return. A body over the middleware.BodyLimit cap is a 413
+func BindJSON(c *gin.Context, obj interface{}) bool {
+	err := c.ShouldBindJSON(obj)
+	if err == nil {
+		return true
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, models.APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("request body must not exceed %d bytes", tooLarge.Limit),
+		})
+		c.Abort()
+		return false
+	}
+
+	response := models.APIResponse{
+		Success: false,
+		Error:   Message(err),
+	}
+
+	// Validation failures also carry per-field details
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		response.Data = fieldErrors(validationErrs)
+	}
+
+	c.JSON(http.StatusBadRequest, response)
+	c.Abort()
+	return false
+}
+
+// Message classifies a binding error into a client-facing message
+func Message(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var validationErrs validator.ValidationErrors
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return "request body is empty"
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &syntaxErr):
+		return "malformed JSON body"
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("field %q must be of type %s", typeErr.Field, typeErr.Type.String())
+	case errors.As(err, &validationErrs):
+		details := fieldErrors(validationErrs)
+		messages := make([]string, 0, len(details))
+		for _, fe := range validationErrs {
+			messages = append(messages, fe.Field()+" "+details[fe.Field()])
+		}
+		return "validation failed: " + strings.Join(messages, "; ")
+	default:
+		return "Invalid request: " + err.Error()
+	}
+}
+
+// fieldErrors maps each invalid field to a short description of the failed rule
+func fieldErrors(errs validator.ValidationErrors) map[string]string {
+	details := make(map[string]string, len(errs))
+	for _, fe := range errs {
+		switch fe.Tag() {
+		case "required":
+			details[fe.Field()] = "is required"
+		case "email":
+			details[fe.Field()] = "must be a valid email address"
+		case "min":
+			details[fe.Field()] = "must be at least " + fe.Param()
+		case "max":
+			details[fe.Field()] = "must be at most " + fe.Param()
+		case "gt":
+			details[fe.Field()] = "must be greater than " + fe.Param()
+		case "gte":
+			details[fe.Field()] = "must be greater than or equal to " + fe.Param()
+		case "oneof":
+			details[fe.Field()] = "must be one of: " + fe.Param()
+		default:
+			details[fe.Field()] = "failed " + fe.Tag() + " validation"
+		}
+	}
+	return details
+}
@@ -0,0 +1,19 @@
+package cache
+
+import "fmt"
+
+// Keyer builds Redis keys under a namespace (REDIS_KEY_PREFIX), so several
+// environments can share one Redis instance without colliding
+type Keyer struct {
+	prefix string
+}
+
+// NewKeyer creates a keyer; an empty prefix keeps the original key names
+func NewKeyer(prefix string) Keyer {
+	return Keyer{prefix: prefix}
+}
+
+// Key formats a key and prepends the namespace, e.g. Key("product:%s", id)
+func (k Keyer) Key(format string, args ...interface{}) string {
+	return k.prefix + fmt.Sprintf(format, args...)
+}
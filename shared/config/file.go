@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// LoadConfigFromFile loads configuration from a YAML or JSON file, with
+// environment variables overriding its values
+// The file is a flat map keyed by the environment variable names, e.g.
+//
+//	DB_HOST: localhost
+//	JWT_ALGORITHMS: [HS256, HS384]
+//
+// Unknown keys are rejected so a typo can't silently fall back to a default
+func LoadConfigFromFile(serviceName, path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	// JSON is valid YAML, so one parser handles both
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("malformed config file %s: %w", path, err)
+	}
+
+	file := make(map[string]string, len(raw))
+	for key, value := range raw {
+		str, err := settingString(value)
+		if err != nil {
+			return nil, fmt.Errorf("config file %s: %s: %w", path, key, err)
+		}
+		file[key] = str
+	}
+
+	s := newSettings(file)
+	cfg := load(serviceName, s)
+
+	var unknown []string
+	for key := range file {
+		if !s.known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("config file %s: unknown keys: %s", path, strings.Join(unknown, ", "))
+	}
+
+	return cfg, nil
+}
+
+// settingString converts a file value to the string form its environment
+// variable would have; lists become comma-separated
+func settingString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			str, err := settingString(item)
+			if err != nil || strings.Contains(str, ",") {
+				return "", fmt.Errorf("list items must be plain values without commas")
+			}
+			items[i] = str
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v; use a string, number, boolean or list", v)
+	}
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultJWTSecret is the development fallback for JWT_SECRET; it must never
+// sign production tokens
+const defaultJWTSecret = "your-secret-key-change-in-production"
+
+// defaultDBPassword is the local Postgres password used when DB_PASSWORD is unset
+const defaultDBPassword = "postgres"
+
+// Bcrypt cost bounds: bcrypt itself accepts 4 to 31, but below
+// minSecureBcryptCost hashes are too cheap to brute-force for production use
+const (
+	defaultBcryptCost   = 10
+	minBcryptCost       = 4
+	maxBcryptCost       = 31
+	minSecureBcryptCost = 10
+)
+
+// defaultPasswordResetURL is the local frontend's reset page
+const defaultPasswordResetURL = "http://localhost:5173/reset-password"
+
+// Development defaults for the service URLs; in production each must be set explicitly
+const (
+	defaultUserServiceURL         = "http://localhost:8081"
+	defaultProductServiceURL      = "http://localhost:8082"
+	defaultOrderServiceURL        = "http://localhost:8083"
+	defaultNotificationServiceURL = "http://localhost:8084"
+)
+
+// Validate checks for settings left at insecure or development defaults
+// In production any problem is returned as an error, so main can refuse to
+// start; elsewhere the problems are returned as warnings to log
+func (c *Config) Validate() (warnings []string, err error) {
+	var problems []string
+
+	if c.JWTSecret == defaultJWTSecret {
+		problems = append(problems, "JWT_SECRET is not set; using the insecure development default")
+	}
+	if c.ServiceName != "api-gateway" {
+		switch c.DBPassword {
+		case "":
+			problems = append(problems, "DB_PASSWORD is empty")
+		case defaultDBPassword:
+			problems = append(problems, "DB_PASSWORD is not set; using the insecure development default")
+		}
+	}
+	if c.ServiceName == "user-service" {
+		if c.PasswordResetURL == defaultPasswordResetURL {
+			problems = append(problems, "PASSWORD_RESET_URL is not set; reset links point at localhost")
+		}
+		switch {
+		case c.BcryptCost < minBcryptCost || c.BcryptCost > maxBcryptCost:
+			problems = append(problems, fmt.Sprintf("BCRYPT_COST %d is out of range %d-%d; using %d",
+				c.BcryptCost, minBcryptCost, maxBcryptCost, defaultBcryptCost))
+		case c.BcryptCost < minSecureBcryptCost:
+			problems = append(problems, fmt.Sprintf("BCRYPT_COST %d is below the minimum of %d for production",
+				c.BcryptCost, minSecureBcryptCost))
+		}
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		problems = append(problems, "SHUTDOWN_TIMEOUT must be positive; in-flight requests will be cut off at shutdown")
+	}
+
+	if c.MaxBodyBytes <= 0 || c.MaxBulkBodyBytes <= 0 {
+		problems = append(problems, "MAX_BODY_BYTES and MAX_BULK_BODY_BYTES must be positive; every request body will be rejected")
+	}
+
+	for _, url := range c.requiredServiceURLs() {
+		if url.value == "" || url.value == url.fallback {
+			problems = append(problems, url.env+" is not set")
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil, nil
+	}
+	if c.IsProduction() {
+		return nil, fmt.Errorf("invalid production configuration: %s", strings.Join(problems, "; "))
+	}
+	return problems, nil
+}
+
+// serviceURL is a backend URL setting with its development default
+type serviceURL struct {
+	env      string
+	value    string
+	fallback string
+}
+
+// requiredServiceURLs lists the backend URLs this service calls
+func (c *Config) requiredServiceURLs() []serviceURL {
+	user := serviceURL{"USER_SERVICE_URL", c.UserServiceURL, defaultUserServiceURL}
+	product := serviceURL{"PRODUCT_SERVICE_URL", c.ProductServiceURL, defaultProductServiceURL}
+	order := serviceURL{"ORDER_SERVICE_URL", c.OrderServiceURL, defaultOrderServiceURL}
+	notification := serviceURL{"NOTIFICATION_SERVICE_URL", c.NotificationServiceURL, defaultNotificationServiceURL}
+
+	switch c.ServiceName {
+	case "api-gateway":
+		return []serviceURL{user, product, order, notification}
+	case "order-service":
+		return []serviceURL{user, product}
+	case "notification-service":
+		return []serviceURL{user}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// indexCheckTimeout bounds the startup index self-check
+const indexCheckTimeout = 5 * time.Second
+
+// MissingIndexes returns the names in expected that don't exist in the
+// current schema, in the order given
+func MissingIndexes(ctx context.Context, db *sql.DB, expected []string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT indexname FROM pg_indexes WHERE schemaname = current_schema()`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list indexes: %w", err)
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan index: %w", err)
+		}
+		existing[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list indexes: %w", err)
+	}
+
+	var missing []string
+	for _, name := range expected {
+		if !existing[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
+// WarnMissingIndexes logs a warning for each expected index missing from the
+// schema. It is a startup diagnostic and never fails startup.
+func WarnMissingIndexes(db *sql.DB, expected []string, log *zap.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), indexCheckTimeout)
+	defer cancel()
+
+	missing, err := MissingIndexes(ctx, db, expected)
+	if err != nil {
+		log.Warn("Index self-check failed", zap.Error(err))
+		return
+	}
+	for _, name := range missing {
+		log.Warn("Expected index is missing", zap.String("index", name))
+	}
+}
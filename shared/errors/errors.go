@@ -0,0 +1,125 @@
+// Package errors defines the typed errors services return to their handlers
+// Each error has a kind, which picks the HTTP status; a code, which clients can
+// match on; and a message, which is safe to show them. Import it as apperrors
+// so it doesn't shadow the standard library package
+package errors
+
+import (
+	stderrors "errors"
+	"net/http"
+
+	"ecommerce/shared/models"
+)
+
+// Kind says what went wrong, independent of transport
+type Kind int
+
+const (
+	// KindInternal is an unexpected failure; its details stay in the logs
+	KindInternal Kind = iota
+	KindNotFound
+	KindUnauthorized
+	KindForbidden
+	KindConflict
+	KindValidation
+	// KindUnavailable is a dependency being down or a retryable race
+	KindUnavailable
+	// KindPaymentRequired is a payment the processor refused
+	KindPaymentRequired
+)
+
+// CodeInternal is the code reported for errors that aren't typed
+const CodeInternal = "internal_error"
+
+// Error is a typed error. Services declare them as sentinels and may wrap
+// them with fmt.Errorf("%w: ...") to add detail; errors.Is and errors.As
+// still find them
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// NotFound is an error for a resource that doesn't exist
+func NotFound(code, message string) *Error {
+	return &Error{Kind: KindNotFound, Code: code, Message: message}
+}
+
+// Unauthorized is an error for a caller that isn't authenticated
+func Unauthorized(code, message string) *Error {
+	return &Error{Kind: KindUnauthorized, Code: code, Message: message}
+}
+
+// Forbidden is an error for a caller that may not access a resource
+func Forbidden(code, message string) *Error {
+	return &Error{Kind: KindForbidden, Code: code, Message: message}
+}
+
+// Conflict is an error for a request that clashes with the resource's state
+func Conflict(code, message string) *Error {
+	return &Error{Kind: KindConflict, Code: code, Message: message}
+}
+
+// Validation is an error for invalid input
+func Validation(code, message string) *Error {
+	return &Error{Kind: KindValidation, Code: code, Message: message}
+}
+
+// Unavailable is an error for a dependency that is down; retrying may succeed
+func Unavailable(code, message string) *Error {
+	return &Error{Kind: KindUnavailable, Code: code, Message: message}
+}
+
+// PaymentRequired is an error for a payment that was refused
+func PaymentRequired(code, message string) *Error {
+	return &Error{Kind: KindPaymentRequired, Code: code, Message: message}
+}
+
+// statuses maps each kind to its HTTP status
+var statuses = map[Kind]int{
+	KindInternal:        http.StatusInternalServerError,
+	KindNotFound:        http.StatusNotFound,
+	KindUnauthorized:    http.StatusUnauthorized,
+	KindForbidden:       http.StatusForbidden,
+	KindConflict:        http.StatusConflict,
+	KindValidation:      http.StatusBadRequest,
+	KindUnavailable:     http.StatusServiceUnavailable,
+	KindPaymentRequired: http.StatusPaymentRequired,
+}
+
+// HTTPStatus returns the status for the first typed error in err's chain, or
+// 500 when there is none
+func HTTPStatus(err error) int {
+	var typed *Error
+	if !stderrors.As(err, &typed) {
+		return http.StatusInternalServerError
+	}
+	if status, ok := statuses[typed.Kind]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Response builds the status and body for a failed request. Typed errors
+// report their full message, including any wrapped detail; anything else is
+// reported as a bare internal error so database or driver details don't leak
+func Response(err error) (int, models.APIResponse) {
+	var typed *Error
+	if !stderrors.As(err, &typed) || typed.Kind == KindInternal {
+		return http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "internal server error",
+			Code:    CodeInternal,
+		}
+	}
+
+	return HTTPStatus(err), models.APIResponse{
+		Success: false,
+		Error:   err.Error(),
+		Code:    typed.Code,
+	}
+}
@@ -0,0 +1,90 @@
+package fields
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Allowed is the set of JSON field names a client may select
+type Allowed map[string]bool
+
+// Of returns the JSON field names of a struct, so the allowed set always
+// matches what the endpoint would return in full
+func Of(v interface{}) Allowed {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	allowed := make(Allowed, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		allowed[name] = true
+	}
+	return allowed
+}
+
+// Select applies the ?fields=id,name,price query parameter to data
+// Data is returned unchanged when no fields are requested; unknown fields are
+// rejected so typos don't silently produce empty objects
+func Select(c *gin.Context, data interface{}, allowed Allowed) (interface{}, error) {
+	param := strings.TrimSpace(c.Query("fields"))
+	if param == "" {
+		return data, nil
+	}
+
+	selected := make(map[string]bool)
+	for _, name := range strings.Split(param, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !allowed[name] {
+			return nil, fmt.Errorf("unknown field: %s", name)
+		}
+		selected[name] = true
+	}
+
+	return filter(data, selected)
+}
+
+// filter re-marshals data (an object or a list of objects) keeping only selected keys
+func filter(data interface{}, selected map[string]bool) (interface{}, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	if len(raw) > 0 && raw[0] == '[' {
+		var items []map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, fmt.Errorf("failed to filter fields: %w", err)
+		}
+		for _, item := range items {
+			keep(item, selected)
+		}
+		return items, nil
+	}
+
+	var item map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &item); err != nil {
+		return nil, fmt.Errorf("failed to filter fields: %w", err)
+	}
+	keep(item, selected)
+	return item, nil
+}
+
+func keep(item map[string]json.RawMessage, selected map[string]bool) {
+	for key := range item {
+		if !selected[key] {
+			delete(item, key)
+		}
+	}
+}
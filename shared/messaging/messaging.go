@@ -0,0 +1,64 @@
+package messaging
+
+// RabbitMQ topology shared by the publishers and their consumers
+const (
+	// OrdersExchange carries every order event, routed by event type (e.g.
+	// "order.confirmed") so each consumer binds only the events it handles
+	// It used to be a fanout exchange; RabbitMQ can't change an exchange's
+	// type, so when upgrading delete the old "orders" exchange before
+	// starting the new services. Durable queues keep their messages and are
+	// re-bound when the consumer starts
+	OrdersExchange     = "orders"
+	OrdersExchangeType = "topic"
+
+	// OrdersBindingAll is the binding key that receives every order event
+	OrdersBindingAll = "order.*"
+
+	// NotificationsQueue is the notification service's default queue
+	// (RABBITMQ_QUEUE); each consumer should bind its own durable queue
+	NotificationsQueue = "notifications"
+
+	// FulfillmentQueue is the notification service's queue for fulfillment hooks,
+	// kept apart so an email backlog can't delay them
+	FulfillmentQueue = "fulfillment"
+
+	// UsersExchange carries user-service account events, routed by event type
+	UsersExchange     = "users"
+	UsersExchangeType = "topic"
+	UsersBindingAll   = "user.*"
+
+	// UserNotificationsQueue is the notification service's queue for user events
+	// (RABBITMQ_USER_QUEUE)
+	UserNotificationsQueue = "user-notifications"
+
+	// DeadLetterExchange receives events a consumer rejected: malformed ones,
+	// and ones that kept failing; they collect in DeadLetterQueue for inspection
+	DeadLetterExchange     = "orders.dlx"
+	DeadLetterExchangeType = "fanout"
+	DeadLetterQueue        = "orders.dlq"
+
+	// ProductsExchange carries product-service events such as low stock alerts
+	ProductsExchange     = "products"
+	ProductsExchangeType = "fanout"
+	ProductsRoutingKey   = ""
+)
+
+// Order event types, carried in the event's "type" field
+const (
+	EventOrderConfirmed = "order.confirmed"
+	EventOrderCancelled = "order.cancelled"
+	EventOrderShipped   = "order.shipped"
+	EventOrderDelivered = "order.delivered"
+)
+
+// User event types
+const (
+	// EventUserPasswordReset carries a password reset link to email to the user
+	EventUserPasswordReset = "user.password_reset"
+)
+
+// Product event types
+const (
+	// EventProductLowStock fires when a stock change takes a product below its threshold
+	EventProductLowStock = "low_stock"
+)
@@ -0,0 +1,27 @@
+package messaging
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff between attempts to redial RabbitMQ after the connection drops
+const (
+	ReconnectBaseDelay = time.Second
+	ReconnectMaxDelay  = 30 * time.Second
+)
+
+// ReconnectDelay returns how long to wait before reconnect attempt n (from 0)
+// The delay doubles per attempt up to ReconnectMaxDelay, with jitter so the
+// services don't all redial a restarted broker at the same moment
+func ReconnectDelay(attempt int) time.Duration {
+	delay := ReconnectMaxDelay
+	if attempt < 10 {
+		delay = ReconnectBaseDelay << attempt
+	}
+	if delay > ReconnectMaxDelay {
+		delay = ReconnectMaxDelay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
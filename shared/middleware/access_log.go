@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"ecommerce/shared/auth"
+)
+
+// AccessLog replaces gin's text logger with one structured entry per request
+// Entries go through the service's zap logger, so its level and sampling
+// apply; 5xx responses log at error level and 4xx at warn
+// Use after RequestID so entries carry the request ID
+func AccessLog(log *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		// Set by auth middleware, or by the gateway for backends without it
+		userID := c.GetString(auth.ContextUserID)
+		if userID == "" {
+			userID = c.GetHeader("X-User-ID")
+		}
+
+		status := c.Writer.Status()
+		fields := []zap.Field{
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			zap.Int("status", status),
+			zap.Duration("duration", time.Since(start)),
+			zap.Int("bytes", c.Writer.Size()),
+			zap.String("request_id", c.GetString(ContextRequestID)),
+			zap.String("user_id", userID),
+			zap.String("remote_ip", c.ClientIP()),
+		}
+
+		switch {
+		case status >= 500:
+			log.Error("HTTP request", fields...)
+		case status >= 400:
+			log.Warn("HTTP request", fields...)
+		default:
+			log.Info("HTTP request", fields...)
+		}
+	}
+}
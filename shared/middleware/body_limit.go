@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"ecommerce/shared/models"
+)
+
+// BodyLimit caps request bodies at limit bytes (MAX_BODY_BYTES)
+// routeLimits overrides the cap for specific gin route paths, e.g. bulk
+// endpoints that legitimately send more. Bodies that declare a larger
+// Content-Length get a 413 straight away; chunked bodies fail when read past
+// the limit, which binding.BindJSON also reports as 413
+func BodyLimit(limit int64, routeLimits map[string]int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		max := limit
+		if routeLimit, ok := routeLimits[c.FullPath()]; ok {
+			max = routeLimit
+		}
+
+		if c.Request.ContentLength > max {
+			c.JSON(http.StatusRequestEntityTooLarge, models.APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("request body must not exceed %d bytes", max),
+			})
+			c.Abort()
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, max)
+		c.Next()
+	}
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+
+	"ecommerce/shared/binding"
+	"ecommerce/shared/cache"
+	"ecommerce/shared/models"
+)
+
+// maintenanceKey is the Redis key holding the maintenance flag ("1" or "0")
+const maintenanceKey = "maintenance_mode"
+
+// MaintenanceMode blocks mutating requests while enabled
+// The flag lives in Redis so every service sees a toggle without a redeploy;
+// the MAINTENANCE_MODE default applies when Redis has no value or is unreachable
+type MaintenanceMode struct {
+	redis          *redis.Client
+	key            string
+	defaultEnabled bool
+}
+
+// NewMaintenanceMode creates a maintenance mode switch backed by Redis
+func NewMaintenanceMode(redisClient *redis.Client, keys cache.Keyer, defaultEnabled bool) *MaintenanceMode {
+	return &MaintenanceMode{
+		redis:          redisClient,
+		key:            keys.Key(maintenanceKey),
+		defaultEnabled: defaultEnabled,
+	}
+}
+
+// Enabled reports whether maintenance mode is currently on
+func (m *MaintenanceMode) Enabled(ctx context.Context) bool {
+	value, err := m.redis.Get(ctx, m.key).Result()
+	if err != nil {
+		return m.defaultEnabled
+	}
+	return value == "1"
+}
+
+// SetEnabled turns maintenance mode on or off for all services sharing this Redis
+func (m *MaintenanceMode) SetEnabled(ctx context.Context, enabled bool) error {
+	value := "0"
+	if enabled {
+		value = "1"
+	}
+	return m.redis.Set(ctx, m.key, value, 0).Err()
+}
+
+// Middleware rejects mutating requests with 503 while maintenance mode is on
+// Reads always pass; exemptPaths (gin route paths) stay writable so the
+// endpoint that turns maintenance off keeps working
+func (m *MaintenanceMode) Middleware(exemptPaths ...string) gin.HandlerFunc {
+	exempt := make(map[string]bool, len(exemptPaths))
+	for _, path := range exemptPaths {
+		exempt[path] = true
+	}
+
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		if exempt[c.FullPath()] || !m.Enabled(c.Request.Context()) {
+			c.Next()
+			return
+		}
+
+		c.JSON(http.StatusServiceUnavailable, models.APIResponse{
+			Success: false,
+			Error:   "Service is in maintenance mode: write operations are temporarily disabled",
+		})
+		c.Abort()
+	}
+}
+
+// GetStatus reports the current maintenance mode
+// GET /api/v1/admin/maintenance
+func (m *MaintenanceMode) GetStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]bool{
+			"enabled": m.Enabled(c.Request.Context()),
+		},
+	})
+}
+
+// SetStatus toggles maintenance mode
+// PUT /api/v1/admin/maintenance
+func (m *MaintenanceMode) SetStatus(c *gin.Context) {
+	var req struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+
+	if !binding.BindJSON(c, &req) {
+		return
+	}
+
+	if err := m.SetEnabled(c.Request.Context(), *req.Enabled); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to update maintenance mode: " + err.Error(),
+		})
+		return
+	}
+
+	message := "Maintenance mode disabled"
+	if *req.Enabled {
+		message = "Maintenance mode enabled"
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: message,
+		Data: map[string]bool{
+			"enabled": *req.Enabled,
+		},
+	})
+}
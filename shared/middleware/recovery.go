@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	apperrors "ecommerce/shared/errors"
+	"ecommerce/shared/models"
+)
+
+// Recovery turns a panic in a handler into a 500 with the standard
+// APIResponse body, in place of gin.Recovery's empty one. The panic value and
+// stack are logged with the request ID but never sent to the client
+// Use after RequestID and AccessLog so the entry and the access log line
+// carry the request ID and the 500
+func Recovery(log *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			// net/http uses this panic to abort a response on purpose
+			// (e.g. a proxied body that failed mid-copy); let it through
+			if err, ok := recovered.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(recovered)
+			}
+
+			log.Error("Panic recovered",
+				zap.String("method", c.Request.Method),
+				zap.String("path", c.Request.URL.Path),
+				zap.String("request_id", c.GetString(ContextRequestID)),
+				zap.String("panic", fmt.Sprint(recovered)),
+				zap.Stack("stack"),
+			)
+
+			// Part of a response may already be out; all we can do is stop
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+
+			c.AbortWithStatusJSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Error:   "internal server error",
+				Code:    apperrors.CodeInternal,
+			})
+		}()
+
+		c.Next()
+	}
+}
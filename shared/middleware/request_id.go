@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the request ID between clients and services
+const RequestIDHeader = "X-Request-ID"
+
+// ContextRequestID is the gin context key holding the request ID
+const ContextRequestID = "request_id"
+
+// RequestID adds a unique ID to each request for tracing, keeping one sent by
+// the caller (e.g. the gateway) so a request can be followed across services
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" {
+			// Generate new ID if not provided
+			requestID = "req-" + uuid.New().String()
+			c.Request.Header.Set(RequestIDHeader, requestID) // Forwarded by the gateway proxy
+		}
+
+		// Add to response headers
+		c.Writer.Header().Set(RequestIDHeader, requestID)
+		c.Set(ContextRequestID, requestID)
+
+		c.Next()
+	}
+}
@@ -9,30 +9,141 @@ type Product struct {
 	Description string    `json:"description" db:"description"`
 	Price       float64   `json:"price" db:"price"`
 	Stock       int       `json:"stock" db:"stock"`
+	Reserved    int       `json:"reserved" db:"reserved"` // Held by unpaid orders; still counted in Stock
 	Category    string    `json:"category" db:"category"`
+	SKU         string    `json:"sku,omitempty" db:"sku"` // Unique when set
+	Currency    string    `json:"currency" db:"currency"` // ISO 4217 code, e.g. "USD"
+	Status      string    `json:"status" db:"status"`     // "draft", "published", "archived", "deleted"
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+
+	// LowStockThreshold overrides LOW_STOCK_THRESHOLD for this product when set
+	LowStockThreshold *int `json:"low_stock_threshold,omitempty" db:"low_stock_threshold"`
+
+	// Images is only populated when fetching a single product or a batch by ID
+	Images []ProductImage `json:"images,omitempty" db:"-"`
+}
+
+// Available is the stock not held by unpaid orders
+func (p *Product) Available() int {
+	return p.Stock - p.Reserved
+}
+
+// Category is a product category; products store its name. Categories nest
+// via ParentID
+type Category struct {
+	ID        string    `json:"id" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	Slug      string    `json:"slug" db:"slug"` // Unique, e.g. "home-garden"
+	ParentID  *string   `json:"parent_id,omitempty" db:"parent_id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// CategoryCount is a category products are filed under and how many there are
+type CategoryCount struct {
+	Category     string `json:"category"`
+	ProductCount int    `json:"product_count"`
 }
 
+// ProductImage is a product photo; lower positions are shown first
+type ProductImage struct {
+	ID        string    `json:"id" db:"id"`
+	ProductID string    `json:"product_id" db:"product_id"`
+	URL       string    `json:"url" db:"url"`
+	Position  int       `json:"position" db:"position"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Product lifecycle statuses - only published products can be ordered
+const (
+	ProductStatusDraft     = "draft"
+	ProductStatusPublished = "published"
+	ProductStatusArchived  = "archived"
+	ProductStatusDeleted   = "deleted" // Soft-deleted: hidden from the catalog, kept for order history
+)
+
+// StockMovement is one entry in a product's inventory audit trail
+type StockMovement struct {
+	ID             string    `json:"id" db:"id"`
+	ProductID      string    `json:"product_id" db:"product_id"`
+	Delta          int       `json:"delta" db:"delta"`
+	ReservedDelta  int       `json:"reserved_delta" db:"reserved_delta"` // Change in units held for unpaid orders
+	Reason         string    `json:"reason" db:"reason"`
+	ResultingStock int       `json:"resulting_stock" db:"resulting_stock"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// Stock movement reasons - order-service sends the order ones with its stock updates
+const (
+	StockReasonOrderReservation = "order_reservation"
+	StockReasonOrderRelease     = "order_release"
+	StockReasonOrderCommit      = "order_commit"       // A paid order's reservation leaving stock
+	StockReasonOrderHold        = "order_hold"         // Units reserved for an unpaid order
+	StockReasonOrderHoldRelease = "order_hold_release" // An unpaid order's reservation given back
+	StockReasonManual           = "manual"             // Admin adjustment; the default
+	StockReasonManualCorrection = "manual_correction"  // Stock set to an absolute count, e.g. after a stocktake
+)
+
 // User represents a system user
 type User struct {
 	ID           string    `json:"id" db:"id"`
 	Email        string    `json:"email" db:"email"`
 	PasswordHash string    `json:"-" db:"password_hash"` // "-" means never serialize to JSON
 	FullName     string    `json:"full_name" db:"full_name"`
-	Role         string    `json:"role" db:"role"` // "admin" or "customer"
+	Role         string    `json:"role" db:"role"`     // "admin", "warehouse" or "customer"
+	Status       string    `json:"status" db:"status"` // See UserStatus constants
 	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
 }
 
+// User account statuses - only active users can log in or use their tokens
+const (
+	UserStatusActive      = "active"
+	UserStatusDeactivated = "deactivated" // Suspended by an admin; can be reactivated
+	UserStatusDeleted     = "deleted"     // Soft-deleted: treated as not found, kept for order history
+)
+
 // Order represents a customer order
 type Order struct {
-	ID         string      `json:"id" db:"id"`
-	UserID     string      `json:"user_id" db:"user_id"`
-	Items      []OrderItem `json:"items"`
-	TotalPrice float64     `json:"total_price" db:"total_price"`
-	Status     string      `json:"status" db:"status"` // "pending", "completed", "cancelled"
-	CreatedAt  time.Time   `json:"created_at" db:"created_at"`
-	UpdatedAt  time.Time   `json:"updated_at" db:"updated_at"`
+	ID             string      `json:"id" db:"id"`
+	UserID         string      `json:"user_id" db:"user_id"`
+	Items          []OrderItem `json:"items"`
+	TotalPrice     float64     `json:"total_price" db:"total_price"`
+	Status         string      `json:"status" db:"status"` // "pending", "confirmed", "shipped", "delivered", "cancelled", "payment_failed"
+	TrackingNumber string      `json:"tracking_number,omitempty" db:"tracking_number"`
+	DeliveredAt    *time.Time  `json:"delivered_at,omitempty" db:"delivered_at"`
+	CreatedAt      time.Time   `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at" db:"updated_at"`
+
+	// ShippingAddress is stored as JSONB; orders placed before it was collected have none
+	ShippingAddress *Address `json:"shipping_address,omitempty" db:"shipping_address"`
+}
+
+// Address is where an order is delivered
+type Address struct {
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `json:"city"`
+	Region     string `json:"region,omitempty"` // State, province or county
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"` // ISO 3166-1 alpha-2 code, e.g. "US"
+}
+
+// OrderStatusChange is one entry in an order's status timeline
+type OrderStatusChange struct {
+	ID        string    `json:"id" db:"id"`
+	OrderID   string    `json:"order_id" db:"order_id"`
+	Status    string    `json:"status" db:"status"`
+	ChangedAt time.Time `json:"changed_at" db:"changed_at"`
+	ChangedBy string    `json:"changed_by" db:"changed_by"` // User ID, or "system" for automatic changes
+}
+
+// OrderStats summarizes a user's order history for the account page
+type OrderStats struct {
+	TotalOrders int        `json:"total_orders"`
+	TotalSpent  float64    `json:"total_spent"` // Excludes cancelled and payment_failed orders
+	LastOrderAt *time.Time `json:"last_order_at,omitempty"`
 }
 
 // OrderItem represents a product in an order
@@ -42,17 +153,69 @@ type OrderItem struct {
 	ProductID string  `json:"product_id" db:"product_id"`
 	Quantity  int     `json:"quantity" db:"quantity"`
 	Price     float64 `json:"price" db:"price"` // Price at time of order
+
+	// Product is only populated when the order is fetched with ?expand=products
+	Product *ProductSummary `json:"product,omitempty" db:"-"`
+}
+
+// ProductSummary is the product shown with an order item
+type ProductSummary struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Category string `json:"category,omitempty"`
+	SKU      string `json:"sku,omitempty"`
+	Status   string `json:"status"`              // "deleted" once it leaves the catalog
+	ImageURL string `json:"image_url,omitempty"` // The product's first image
 }
 
 // Notification represents a notification to be sent
 type Notification struct {
-	ID        string    `json:"id" db:"id"`
-	UserID    string    `json:"user_id" db:"user_id"`
-	Type      string    `json:"type" db:"type"` // "email", "sms"
-	Subject   string    `json:"subject" db:"subject"`
-	Message   string    `json:"message" db:"message"`
-	Status    string    `json:"status" db:"status"` // "pending", "sent", "failed"
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ID                string    `json:"id" db:"id"`
+	UserID            string    `json:"user_id" db:"user_id"`
+	Type              string    `json:"type" db:"type"`         // "email", "sms"
+	Category          string    `json:"category" db:"category"` // "transactional" or "promotional"
+	Subject           string    `json:"subject" db:"subject"`
+	Message           string    `json:"message" db:"message"`
+	Status            string    `json:"status" db:"status"`                                     // "pending", "sent", "failed", "suppressed", "read", "delivered", "bounced"
+	ProviderMessageID string    `json:"provider_message_id,omitempty" db:"provider_message_id"` // Set by the provider's delivery callback
+	Attempts          int       `json:"attempts" db:"attempts"`                                 // Send attempts so far; failed sends are retried up to a limit
+	CreatedAt         time.Time `json:"created_at" db:"created_at"`
+}
+
+// Notification categories - users can opt out of promotional ones separately
+const (
+	NotificationCategoryTransactional = "transactional" // About the user's own orders
+	NotificationCategoryPromotional   = "promotional"
+)
+
+// NotificationPreferences records which notifications a user receives
+// Users without a stored row get DefaultNotificationPreferences
+type NotificationPreferences struct {
+	UserID      string    `json:"user_id" db:"user_id"`
+	Email       bool      `json:"email" db:"email"`
+	SMS         bool      `json:"sms" db:"sms"`
+	Promotional bool      `json:"promotional" db:"promotional"` // Applies on top of the channel settings
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// DefaultNotificationPreferences opts a user in to every channel and category
+func DefaultNotificationPreferences(userID string) *NotificationPreferences {
+	return &NotificationPreferences{UserID: userID, Email: true, SMS: true, Promotional: true}
+}
+
+// NotificationTemplate is the editable copy for one notification, looked up
+// by event name, channel and locale; templates use Go text/template syntax
+type NotificationTemplate struct {
+	ID              string    `json:"id" db:"id"`
+	Name            string    `json:"name" db:"name"`       // Event name, e.g. "order.confirmed"
+	Channel         string    `json:"channel" db:"channel"` // "email", "sms"
+	Locale          string    `json:"locale" db:"locale"`   // e.g. "en"
+	SubjectTemplate string    `json:"subject_template" db:"subject_template"`
+	BodyTemplate    string    `json:"body_template" db:"body_template"`
+	Version         int       `json:"version" db:"version"` // Incremented on every edit
+	Active          bool      `json:"active" db:"active"`   // Inactive templates fall back to the built-in copy
+	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at" db:"updated_at"`
 }
 
 // APIResponse is the standard response structure for all APIs
@@ -61,11 +224,44 @@ type APIResponse struct {
 	Message string      `json:"message,omitempty"`
 	Data    interface{} `json:"data,omitempty"`
 	Error   string      `json:"error,omitempty"`
+	Code    string      `json:"code,omitempty"` // Machine-readable error code, e.g. "order_not_found"
+}
+
+// PaginatedResponse wraps one page of a list endpoint with its totals
+type PaginatedResponse struct {
+	Items      interface{} `json:"items"`
+	Total      int         `json:"total"`
+	Page       int         `json:"page"`
+	PageSize   int         `json:"page_size"`
+	TotalPages int         `json:"total_pages"`
+}
+
+// NewPaginatedResponse builds a PaginatedResponse, deriving TotalPages from total and pageSize
+func NewPaginatedResponse(items interface{}, total, page, pageSize int) PaginatedResponse {
+	totalPages := 0
+	if pageSize > 0 {
+		totalPages = (total + pageSize - 1) / pageSize
+	}
+	return PaginatedResponse{
+		Items:      items,
+		Total:      total,
+		Page:       page,
+		PageSize:   pageSize,
+		TotalPages: totalPages,
+	}
+}
+
+// CursorResponse wraps one page of a cursor-paginated list; NextCursor is
+// empty on the last page
+type CursorResponse struct {
+	Items      interface{} `json:"items"`
+	PageSize   int         `json:"page_size"`
+	NextCursor string      `json:"next_cursor,omitempty"`
 }
 
 // HealthCheckResponse for Kubernetes liveness/readiness probes
 type HealthCheckResponse struct {
-	Status    string            `json:"status"` // "healthy" or "unhealthy"
+	Status    string            `json:"status"` // "healthy", "degraded" or "unhealthy"
 	Service   string            `json:"service"`
 	Timestamp time.Time         `json:"timestamp"`
 	Checks    map[string]string `json:"checks"` // e.g., {"database": "connected", "redis": "connected"}
@@ -79,9 +275,10 @@ type LoginRequest struct {
 
 // LoginResponse contains JWT token
 type LoginResponse struct {
-	Token     string `json:"token"`
-	ExpiresAt int64  `json:"expires_at"`
-	User      User   `json:"user"`
+	Token        string `json:"token"`
+	ExpiresAt    int64  `json:"expires_at"`
+	RefreshToken string `json:"refresh_token"` // Single-use; exchange at /auth/refresh
+	User         User   `json:"user"`
 }
 
 // CreateOrderRequest for placing orders
@@ -90,4 +287,5 @@ type CreateOrderRequest struct {
 		ProductID string `json:"product_id" binding:"required"`
 		Quantity  int    `json:"quantity" binding:"required,min=1"`
 	} `json:"items" binding:"required,min=1"`
+	ShippingAddress *Address `json:"shipping_address"` // Validated by order-service
 }
@@ -0,0 +1,40 @@
+package pagination
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limits are a service's page size default and upper bound (DEFAULT_PAGE_SIZE, MAX_PAGE_SIZE)
+type Limits struct {
+	DefaultPageSize int
+	MaxPageSize     int
+}
+
+// Page is a validated page request
+type Page struct {
+	Number int
+	Size   int
+}
+
+// Offset returns the number of rows to skip for this page
+func (p Page) Offset() int {
+	return (p.Number - 1) * p.Size
+}
+
+// FromQuery reads ?page= and ?page_size=, falling back to the defaults when a
+// value is missing or out of range
+func (l Limits) FromQuery(c *gin.Context) Page {
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	size, err := strconv.Atoi(c.Query("page_size"))
+	if err != nil || size < 1 || size > l.MaxPageSize {
+		size = l.DefaultPageSize
+	}
+
+	return Page{Number: page, Size: size}
+}
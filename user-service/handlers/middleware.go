@@ -6,6 +6,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	"ecommerce/shared/auth"
 	"ecommerce/shared/models"
 )
 
@@ -38,7 +39,7 @@ func AuthMiddleware(handler *UserHandler) gin.HandlerFunc {
 		token := parts[1]
 
 		// Validate token
-		user, err := handler.service.ValidateToken(token)
+		user, err := handler.service.ValidateToken(c.Request.Context(), token)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, models.APIResponse{
 				Success: false,
@@ -50,36 +51,10 @@ func AuthMiddleware(handler *UserHandler) gin.HandlerFunc {
 
 		// Store user in context for downstream handlers
 		c.Set("user", user)
-		c.Next()
-	}
-}
-
-// AdminMiddleware checks if user has admin role
-// Must be used after AuthMiddleware
-func AdminMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Get user from context (set by AuthMiddleware)
-		user, exists := c.Get("user")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, models.APIResponse{
-				Success: false,
-				Error:   "Unauthorized",
-			})
-			c.Abort()
-			return
-		}
-
-		// Check if user is admin
-		currentUser := user.(*models.User)
-		if currentUser.Role != "admin" {
-			c.JSON(http.StatusForbidden, models.APIResponse{
-				Success: false,
-				Error:   "Access denied: admin role required",
-			})
-			c.Abort()
-			return
-		}
-
+		c.Set("token", token)
+		// Shared keys so auth.RequirePermission works here as in other services
+		c.Set(auth.ContextUserID, user.ID)
+		c.Set(auth.ContextRole, user.Role)
 		c.Next()
 	}
 }
@@ -102,28 +77,3 @@ func CORSMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
-
-// RequestIDMiddleware adds a unique ID to each request for tracing
-func RequestIDMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		requestID := c.GetHeader("X-Request-ID")
-		if requestID == "" {
-			// Generate new ID if not provided
-			requestID = generateRequestID()
-		}
-
-		// Add to response headers
-		c.Writer.Header().Set("X-Request-ID", requestID)
-		c.Set("request_id", requestID)
-
-		c.Next()
-	}
-}
-
-// generateRequestID creates a simple request ID
-// In production, use UUID or distributed tracing IDs (Jaeger, Zipkin)
-func generateRequestID() string {
-	// For simplicity, using timestamp
-	// In production, use github.com/google/uuid
-	return "req-" + string(rune(1000000))
-}
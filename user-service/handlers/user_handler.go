@@ -1,27 +1,33 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
+	"ecommerce/shared/auth"
+	"ecommerce/shared/binding"
+	apperrors "ecommerce/shared/errors"
 	"ecommerce/shared/models"
+	"ecommerce/shared/pagination"
 	"ecommerce/user-service/service"
 )
 
 // UserHandler handles HTTP requests for users
 type UserHandler struct {
 	service *service.UserService
+	paging  pagination.Limits
 	logger  *zap.Logger
 }
 
 // NewUserHandler creates a new user handler
-func NewUserHandler(service *service.UserService, logger *zap.Logger) *UserHandler {
+func NewUserHandler(service *service.UserService, paging pagination.Limits, logger *zap.Logger) *UserHandler {
 	return &UserHandler{
 		service: service,
+		paging:  paging,
 		logger:  logger,
 	}
 }
@@ -35,11 +41,7 @@ func (h *UserHandler) Register(c *gin.Context) {
 		FullName string `json:"full_name" binding:"required"`
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Error:   "Invalid request: " + err.Error(),
-		})
+	if !binding.BindJSON(c, &req) {
 		return
 	}
 
@@ -47,17 +49,7 @@ func (h *UserHandler) Register(c *gin.Context) {
 
 	user, err := h.service.Register(c.Request.Context(), req.Email, req.Password, req.FullName)
 	if err != nil {
-		h.logger.Error("Registration failed", zap.Error(err))
-
-		statusCode := http.StatusInternalServerError
-		if err == service.ErrEmailExists {
-			statusCode = http.StatusConflict
-		}
-
-		c.JSON(statusCode, models.APIResponse{
-			Success: false,
-			Error:   err.Error(),
-		})
+		h.respondError(c, err)
 		return
 	}
 
@@ -75,11 +67,7 @@ func (h *UserHandler) Register(c *gin.Context) {
 func (h *UserHandler) Login(c *gin.Context) {
 	var req models.LoginRequest
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Error:   "Invalid request: " + err.Error(),
-		})
+	if !binding.BindJSON(c, &req) {
 		return
 	}
 
@@ -88,16 +76,7 @@ func (h *UserHandler) Login(c *gin.Context) {
 	response, err := h.service.Login(c.Request.Context(), req.Email, req.Password)
 	if err != nil {
 		h.logger.Warn("Login failed", zap.String("email", req.Email), zap.Error(err))
-
-		statusCode := http.StatusUnauthorized
-		if err == service.ErrInvalidCredentials {
-			statusCode = http.StatusUnauthorized
-		}
-
-		c.JSON(statusCode, models.APIResponse{
-			Success: false,
-			Error:   err.Error(),
-		})
+		h.respondError(c, err)
 		return
 	}
 
@@ -110,6 +89,91 @@ func (h *UserHandler) Login(c *gin.Context) {
 	})
 }
 
+// RefreshToken exchanges a refresh token for new tokens
+// POST /api/v1/auth/refresh
+func (h *UserHandler) RefreshToken(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+
+	if !binding.BindJSON(c, &req) {
+		return
+	}
+
+	response, err := h.service.Refresh(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		h.logger.Warn("Token refresh failed", zap.Error(err))
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Token refreshed",
+		Data:    response,
+	})
+}
+
+// Logout revokes the caller's access token
+// POST /api/v1/auth/logout
+func (h *UserHandler) Logout(c *gin.Context) {
+	if err := h.service.Logout(c.Request.Context(), c.GetString("token")); err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Logged out successfully",
+	})
+}
+
+// ForgotPassword emails a password reset link
+// POST /api/v1/auth/forgot-password
+// The response is the same whether or not the email is registered
+func (h *UserHandler) ForgotPassword(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if !binding.BindJSON(c, &req) {
+		return
+	}
+
+	if err := h.service.RequestPasswordReset(c.Request.Context(), req.Email); err != nil {
+		// Still 200: an error only happens for registered emails
+		h.logger.Error("Password reset request failed", zap.Error(err))
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "If an account exists for this email, a reset link has been sent",
+	})
+}
+
+// ResetPassword sets a new password using an emailed reset token
+// POST /api/v1/auth/reset-password
+func (h *UserHandler) ResetPassword(c *gin.Context) {
+	var req struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+
+	if !binding.BindJSON(c, &req) {
+		return
+	}
+
+	if err := h.service.ResetPassword(c.Request.Context(), req.Token, req.Password); err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Password has been reset",
+	})
+}
+
 // GetCurrentUser returns the authenticated user's info
 // GET /api/v1/users/me
 func (h *UserHandler) GetCurrentUser(c *gin.Context) {
@@ -129,17 +193,37 @@ func (h *UserHandler) GetCurrentUser(c *gin.Context) {
 	})
 }
 
+// GetPermissions returns the authenticated user's role and what it allows
+// GET /api/v1/users/me/permissions
+func (h *UserHandler) GetPermissions(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+		return
+	}
+	currentUser := user.(*models.User)
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"role":        currentUser.Role,
+			"permissions": h.service.Permissions(currentUser.Role),
+		},
+	})
+}
+
 // GetUserByID retrieves a user by ID
 // GET /api/v1/users/:id
+// GET /api/v1/internal/users/:id (service-to-service only)
 func (h *UserHandler) GetUserByID(c *gin.Context) {
 	id := c.Param("id")
 
 	user, err := h.service.GetUserByID(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.APIResponse{
-			Success: false,
-			Error:   "User not found",
-		})
+		h.respondError(c, err)
 		return
 	}
 
@@ -160,11 +244,7 @@ func (h *UserHandler) UpdateProfile(c *gin.Context) {
 		FullName string `json:"full_name"`
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Error:   "Invalid request: " + err.Error(),
-		})
+	if !binding.BindJSON(c, &req) {
 		return
 	}
 
@@ -175,10 +255,7 @@ func (h *UserHandler) UpdateProfile(c *gin.Context) {
 		req.FullName,
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   err.Error(),
-		})
+		h.respondError(c, err)
 		return
 	}
 
@@ -190,30 +267,48 @@ func (h *UserHandler) UpdateProfile(c *gin.Context) {
 }
 
 // ListUsers returns all users (admin only)
-// GET /api/v1/admin/users?page=1&page_size=10
+// GET /api/v1/admin/users?page=1&page_size=10&sort=email_asc
+// sort: created_at_desc (default), created_at_asc, email_asc, email_desc,
+// name_asc, name_desc, role_asc, role_desc
 func (h *UserHandler) ListUsers(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+	page := h.paging.FromQuery(c)
+	sort := c.Query("sort")
 
-	if page < 1 {
-		page = 1
-	}
-	if pageSize < 1 || pageSize > 100 {
-		pageSize = 10
+	users, total, err := h.service.ListUsers(c.Request.Context(), page, sort)
+	if err != nil {
+		h.respondError(c, err)
+		return
 	}
 
-	users, err := h.service.ListUsers(c.Request.Context(), page, pageSize)
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    models.NewPaginatedResponse(users, total, page.Number, page.Size),
+	})
+}
+
+// SearchUsers finds users by partial email or name, or by email prefix (admin only)
+// GET /api/v1/admin/users/search?q=jane&page=1&page_size=10
+// GET /api/v1/admin/users/search?email=jane@&page=1&page_size=10
+// No matches is an empty page, not a 404
+func (h *UserHandler) SearchUsers(c *gin.Context) {
+	page := h.paging.FromQuery(c)
+
+	var users []*models.User
+	var total int
+	var err error
+	if email, ok := c.GetQuery("email"); ok {
+		users, total, err = h.service.SearchUsersByEmail(c.Request.Context(), email, page)
+	} else {
+		users, total, err = h.service.SearchUsers(c.Request.Context(), c.Query("q"), page)
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   err.Error(),
-		})
+		h.respondError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Data:    users,
+		Data:    models.NewPaginatedResponse(users, total, page.Number, page.Size),
 	})
 }
 
@@ -223,10 +318,7 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 	id := c.Param("id")
 
 	if err := h.service.DeleteUser(c.Request.Context(), id); err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   err.Error(),
-		})
+		h.respondError(c, err)
 		return
 	}
 
@@ -236,6 +328,82 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 	})
 }
 
+// UpdateUserRole changes a user's role (admin only)
+// PUT /api/v1/admin/users/:id/role
+func (h *UserHandler) UpdateUserRole(c *gin.Context) {
+	var req struct {
+		Role string `json:"role" binding:"required"`
+	}
+
+	if !binding.BindJSON(c, &req) {
+		return
+	}
+
+	user, err := h.service.UpdateRole(c.Request.Context(), c.Param("id"), req.Role)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	h.logger.Info("User role updated",
+		zap.String("user_id", user.ID),
+		zap.String("role", user.Role),
+		zap.String("by", c.GetString(auth.ContextUserID)),
+	)
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Role updated",
+		Data:    user,
+	})
+}
+
+// DeactivateUser suspends a user's account (admin only)
+// POST /api/v1/admin/users/:id/deactivate
+func (h *UserHandler) DeactivateUser(c *gin.Context) {
+	h.setStatus(c, h.service.DeactivateUser, "User deactivated")
+}
+
+// ReactivateUser restores a deactivated account (admin only)
+// POST /api/v1/admin/users/:id/reactivate
+func (h *UserHandler) ReactivateUser(c *gin.Context) {
+	h.setStatus(c, h.service.ReactivateUser, "User reactivated")
+}
+
+// setStatus applies a status change to the user in the path
+func (h *UserHandler) setStatus(c *gin.Context, change func(ctx context.Context, id string) (*models.User, error), message string) {
+	user, err := change(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	h.logger.Info(message,
+		zap.String("user_id", user.ID),
+		zap.String("by", c.GetString(auth.ContextUserID)),
+	)
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: message,
+		Data:    user,
+	})
+}
+
+// respondError writes err with the status its type maps to. Untyped errors
+// are unexpected, so they are logged here and reported without detail
+func (h *UserHandler) respondError(c *gin.Context, err error) {
+	status, response := apperrors.Response(err)
+	if status >= http.StatusInternalServerError {
+		h.logger.Error("Request failed",
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.FullPath()),
+			zap.Error(err),
+		)
+	}
+	c.JSON(status, response)
+}
+
 // HealthCheck returns service health status
 // GET /health
 func (h *UserHandler) HealthCheck(c *gin.Context) {
@@ -255,6 +423,16 @@ func (h *UserHandler) HealthCheck(c *gin.Context) {
 	}
 
 	response.Checks["database"] = "connected"
+
+	// Redis only backs caches and shared flags, so losing it degrades the
+	// service without taking it out of rotation
+	if err := h.service.CacheHealthCheck(c.Request.Context()); err != nil {
+		response.Status = "degraded"
+		response.Checks["redis"] = "disconnected"
+	} else {
+		response.Checks["redis"] = "connected"
+	}
+
 	c.JSON(http.StatusOK, response)
 }
 
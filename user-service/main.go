@@ -7,14 +7,19 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
+	"ecommerce/shared/auth"
+	"ecommerce/shared/cache"
 	"ecommerce/shared/config"
+	"ecommerce/shared/database"
 	"ecommerce/shared/logger"
+	"ecommerce/shared/middleware"
+	"ecommerce/shared/pagination"
 	"ecommerce/user-service/handlers"
+	"ecommerce/user-service/messaging"
 	"ecommerce/user-service/repository"
 	"ecommerce/user-service/service"
 )
@@ -30,13 +35,22 @@ func main() {
 	}
 	defer log.Sync()
 
+	// Refuse to start in production with insecure or missing settings
+	warnings, err := cfg.Validate()
+	if err != nil {
+		log.Fatal("Invalid configuration", zap.Error(err))
+	}
+	for _, warning := range warnings {
+		log.Warn("Configuration warning", zap.String("problem", warning))
+	}
+
 	log.Info("Starting User Service",
 		zap.String("environment", cfg.Environment),
 		zap.String("port", cfg.Port),
 	)
 
 	// 3. Initialize database connection
-	db, err := repository.NewPostgresDB(cfg.GetDatabaseURL())
+	db, err := repository.NewPostgresDB(cfg.GetDatabaseURL(), cfg.DBConnectTimeout)
 	if err != nil {
 		log.Fatal("Failed to connect to database", zap.Error(err))
 	}
@@ -49,27 +63,67 @@ func main() {
 		log.Fatal("Failed to run migrations", zap.Error(err))
 	}
 
+	if cfg.CheckIndexes {
+		database.WarnMissingIndexes(db, repository.ExpectedIndexes, log.Logger)
+	}
+
 	// 5. Initialize Redis for caching
-	redisClient := repository.NewRedisClient(cfg.GetRedisURL(), cfg.RedisPassword)
+	redisClient := repository.NewRedisClient(cfg.GetRedisURL(), cfg.RedisPassword, cfg.RedisDB)
 	defer redisClient.Close()
 
+	// The token denylist and maintenance flag live on REDIS_SHARED_DB so every
+	// service sees them, whatever its own REDIS_DB
+	sharedRedis := redisClient
+	if cfg.RedisSharedDB != cfg.RedisDB {
+		sharedRedis = repository.NewRedisClient(cfg.GetRedisURL(), cfg.RedisPassword, cfg.RedisSharedDB)
+		defer sharedRedis.Close()
+	}
+	keys := cache.NewKeyer(cfg.RedisKeyPrefix)
+
 	log.Info("Redis connection established")
 
-	// 6. Initialize layers: Repository -> Service -> Handler
-	userRepo := repository.NewUserRepository(db, redisClient)
-	userService := service.NewUserService(userRepo, cfg.JWTSecret)
-	userHandler := handlers.NewUserHandler(userService, log.Logger)
+	// 6. Initialize RabbitMQ publisher (password reset emails go through notification-service)
+	publisher, err := messaging.NewRabbitMQPublisher(cfg.RabbitMQURL, log.Logger)
+	if err != nil {
+		log.Fatal("Failed to connect to RabbitMQ", zap.Error(err))
+	}
+	defer publisher.Close()
+
+	// 7. Initialize layers: Repository -> Service -> Handler
+	userRepo := repository.NewUserRepository(db, redisClient, keys)
+	jwtKeys, err := auth.NewKeyring(cfg.JWTKeyID, cfg.JWTSecret, cfg.JWTPreviousSecrets)
+	if err != nil {
+		log.Fatal("Invalid JWT configuration", zap.Error(err))
+	}
+	userService, err := service.NewUserService(userRepo, jwtKeys, cfg.JWTAlgorithms, auth.NewDenylist(sharedRedis, keys),
+		cfg.BcryptCost, publisher, cfg.PasswordResetURL, cfg.PasswordResetTTL)
+	if err != nil {
+		log.Fatal("Invalid user service configuration", zap.Error(err))
+	}
+	paging := pagination.Limits{DefaultPageSize: cfg.DefaultPageSize, MaxPageSize: cfg.MaxPageSize}
+	userHandler := handlers.NewUserHandler(userService, paging, log.Logger)
 
-	// 7. Set up HTTP router (Gin framework)
+	// 8. Set up HTTP router (Gin framework)
 	if cfg.IsProduction() {
 		gin.SetMode(gin.ReleaseMode)
 	}
-	router := gin.Default()
+	// gin.New instead of gin.Default: requests are logged as structured JSON and
+	// panics answered with the usual APIResponse body
+	router := gin.New()
+	router.Use(middleware.RequestID(), middleware.AccessLog(log.Logger), middleware.Recovery(log.Logger))
 
-	// 8. Register routes
-	setupRoutes(router, userHandler)
+	// Cap request bodies so one oversized payload can't exhaust memory
+	router.Use(middleware.BodyLimit(int64(cfg.MaxBodyBytes), nil))
 
-	// 9. Start HTTP server with graceful shutdown
+	// Block writes while maintenance mode is on (toggled via the admin API)
+	maintenance := middleware.NewMaintenanceMode(sharedRedis, keys, cfg.MaintenanceMode)
+	// Login and refresh stay open so an admin can always get a token to turn it off
+	router.Use(maintenance.Middleware("/api/v1/admin/maintenance", "/api/v1/auth/login", "/api/v1/auth/refresh"))
+
+	// 9. Register routes
+	setupRoutes(router, userHandler, maintenance)
+
+	// 10. Start HTTP server with graceful shutdown
 	srv := &http.Server{
 		Addr:    ":" + cfg.Port,
 		Handler: router,
@@ -83,15 +137,15 @@ func main() {
 		}
 	}()
 
-	// 10. Wait for interrupt signal for graceful shutdown
+	// 11. Wait for interrupt signal for graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	log.Info("Shutting down server...")
 
-	// Give outstanding requests 5 seconds to complete
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// Give outstanding requests SHUTDOWN_TIMEOUT to complete
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
@@ -102,7 +156,7 @@ func main() {
 }
 
 // setupRoutes configures all HTTP endpoints
-func setupRoutes(router *gin.Engine, handler *handlers.UserHandler) {
+func setupRoutes(router *gin.Engine, handler *handlers.UserHandler, maintenance *middleware.MaintenanceMode) {
 	// Health check endpoint (Kubernetes uses this for liveness/readiness probes)
 	router.GET("/health", handler.HealthCheck)
 	router.GET("/ready", handler.ReadinessCheck)
@@ -111,10 +165,14 @@ func setupRoutes(router *gin.Engine, handler *handlers.UserHandler) {
 	v1 := router.Group("/api/v1")
 	{
 		// Public routes (no authentication required)
-		auth := v1.Group("/auth")
+		authRoutes := v1.Group("/auth")
 		{
-			auth.POST("/register", handler.Register)
-			auth.POST("/login", handler.Login)
+			authRoutes.POST("/register", handler.Register)
+			authRoutes.POST("/login", handler.Login)
+			authRoutes.POST("/refresh", handler.RefreshToken)
+			authRoutes.POST("/forgot-password", handler.ForgotPassword)
+			authRoutes.POST("/reset-password", handler.ResetPassword)
+			authRoutes.POST("/logout", handlers.AuthMiddleware(handler), handler.Logout)
 		}
 
 		// Protected routes (require JWT token)
@@ -123,15 +181,32 @@ func setupRoutes(router *gin.Engine, handler *handlers.UserHandler) {
 		{
 			users.GET("/me", handler.GetCurrentUser)
 			users.PUT("/me", handler.UpdateProfile)
+			users.GET("/me/permissions", handler.GetPermissions)
 			users.GET("/:id", handler.GetUserByID)
 		}
 
 		// Admin-only routes
 		admin := v1.Group("/admin")
-		admin.Use(handlers.AuthMiddleware(handler), handlers.AdminMiddleware())
+		admin.Use(handlers.AuthMiddleware(handler))
+		{
+			manageUsers := auth.RequirePermission(auth.PermUserManage)
+			admin.GET("/users", manageUsers, handler.ListUsers)
+			admin.GET("/users/search", manageUsers, handler.SearchUsers)
+			admin.DELETE("/users/:id", manageUsers, handler.DeleteUser)
+			admin.PUT("/users/:id/role", manageUsers, handler.UpdateUserRole)
+			admin.POST("/users/:id/deactivate", manageUsers, handler.DeactivateUser)
+			admin.POST("/users/:id/reactivate", manageUsers, handler.ReactivateUser)
+
+			// Maintenance mode applies to every service sharing Redis
+			manageMaintenance := auth.RequirePermission(auth.PermMaintenanceManage)
+			admin.GET("/maintenance", manageMaintenance, maintenance.GetStatus)
+			admin.PUT("/maintenance", manageMaintenance, maintenance.SetStatus)
+		}
+
+		// Internal routes for other services; not exposed through the gateway
+		internal := v1.Group("/internal")
 		{
-			admin.GET("/users", handler.ListUsers)
-			admin.DELETE("/users/:id", handler.DeleteUser)
+			internal.GET("/users/:id", handler.GetUserByID) // Recipient lookup (notification-service)
 		}
 	}
 }
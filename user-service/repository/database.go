@@ -8,10 +8,13 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	_ "github.com/lib/pq"
+
+	"ecommerce/shared/database"
 )
 
 // NewPostgresDB creates a new PostgreSQL connection pool
-func NewPostgresDB(connStr string) (*sql.DB, error) {
+// The initial ping is retried for up to connectTimeout (DB_CONNECT_TIMEOUT)
+func NewPostgresDB(connStr string, connectTimeout time.Duration) (*sql.DB, error) {
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -22,11 +25,9 @@ func NewPostgresDB(connStr string) (*sql.DB, error) {
 	db.SetMaxIdleConns(5)                  // Keep 5 connections ready
 	db.SetConnMaxLifetime(5 * time.Minute) // Recycle connections every 5 minutes
 
-	// Verify connection
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	if err := db.PingContext(ctx); err != nil {
+	// Verify connection, waiting for Postgres if it is still starting
+	if err := database.PingWithRetry(db, connectTimeout); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
@@ -34,11 +35,11 @@ func NewPostgresDB(connStr string) (*sql.DB, error) {
 }
 
 // NewRedisClient creates a new Redis client
-func NewRedisClient(addr, password string) *redis.Client {
+func NewRedisClient(addr, password string, db int) *redis.Client {
 	client := redis.NewClient(&redis.Options{
 		Addr:     addr,
 		Password: password,
-		DB:       0, // Use default database
+		DB:       db, // Logical database (REDIS_DB)
 
 		// Connection pool settings
 		PoolSize:     10,
@@ -65,6 +66,16 @@ func NewRedisClient(addr, password string) *redis.Client {
 
 // RunMigrations creates tables if they don't exist
 // In production, use a proper migration tool like golang-migrate or flyway
+// ExpectedIndexes are the indexes the queries rely on; keep in sync with the
+// CREATE INDEX migrations below (checked at startup when CHECK_INDEXES is set)
+var ExpectedIndexes = []string{
+	"idx_users_email",
+	"idx_users_role",
+	"idx_users_email_prefix",
+	"idx_refresh_tokens_user_id",
+	"idx_password_reset_tokens_user_id",
+}
+
 func RunMigrations(db *sql.DB) error {
 	migrations := []string{
 		// Users table
@@ -83,6 +94,34 @@ func RunMigrations(db *sql.DB) error {
 
 		// Create index on role for admin queries
 		`CREATE INDEX IF NOT EXISTS idx_users_role ON users(role)`,
+
+		// Case-insensitive email prefix search (text_pattern_ops lets LIKE 'x%' use it)
+		`CREATE INDEX IF NOT EXISTS idx_users_email_prefix ON users(LOWER(email) text_pattern_ops)`,
+
+		// Soft delete and deactivation
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active'`,
+
+		// Refresh tokens (only a SHA-256 hash of the token is stored)
+		`CREATE TABLE IF NOT EXISTS refresh_tokens (
+			id VARCHAR(36) PRIMARY KEY,
+			user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			revoked_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id)`,
+
+		// Password reset tokens (hashed like refresh tokens; each is single-use)
+		`CREATE TABLE IF NOT EXISTS password_reset_tokens (
+			id VARCHAR(36) PRIMARY KEY,
+			user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_user_id ON password_reset_tokens(user_id)`,
 	}
 
 	for i, migration := range migrations {
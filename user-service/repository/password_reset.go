@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrResetTokenNotFound is returned for a reset token that doesn't exist,
+// has expired or was already used
+var ErrResetTokenNotFound = errors.New("reset token not found, expired or used")
+
+// CreatePasswordResetToken stores the hash of a newly issued reset token
+func (r *UserRepository) CreatePasswordResetToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error {
+	query := `
+		INSERT INTO password_reset_tokens (id, user_id, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err := r.db.ExecContext(ctx, query, uuid.New().String(), userID, tokenHash, expiresAt, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to create reset token: %w", err)
+	}
+
+	return nil
+}
+
+// ResetPassword uses a live reset token to set its user's password, and
+// returns the user ID
+// In the same transaction the user's other reset tokens are spent and their
+// refresh tokens revoked, so sessions started before the reset end with it
+func (r *UserRepository) ResetPassword(ctx context.Context, tokenHash, passwordHash string) (string, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	// Marking the token used in the lookup makes it single-use under concurrency
+	var userID string
+	err = tx.QueryRowContext(ctx, `
+		UPDATE password_reset_tokens
+		SET used_at = $1
+		WHERE token_hash = $2 AND used_at IS NULL AND expires_at > $1
+		RETURNING user_id
+	`, now, tokenHash).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", ErrResetTokenNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to consume reset token: %w", err)
+	}
+
+	var email string
+	if err := tx.QueryRowContext(ctx,
+		`UPDATE users SET password_hash = $1, updated_at = $2 WHERE id = $3 RETURNING email`,
+		passwordHash, now, userID,
+	).Scan(&email); err != nil {
+		return "", fmt.Errorf("failed to update password: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE password_reset_tokens SET used_at = $1 WHERE user_id = $2 AND used_at IS NULL`,
+		now, userID,
+	); err != nil {
+		return "", fmt.Errorf("failed to expire reset tokens: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL`,
+		now, userID,
+	); err != nil {
+		return "", fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit password reset: %w", err)
+	}
+
+	// The email-keyed entry holds the old password hash
+	r.invalidate(ctx, userID, email)
+
+	return userID, nil
+}
@@ -4,27 +4,80 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	_ "github.com/lib/pq"
 
+	"ecommerce/shared/auth"
+	"ecommerce/shared/cache"
 	"ecommerce/shared/models"
 )
 
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrLastAdmin    = errors.New("cannot remove the last active admin")
+)
+
+// userSortOrders maps the sort values accepted by the admin API to safe ORDER BY clauses
+// Sort input is never interpolated directly into SQL; non-unique columns get an
+// id tiebreaker so LIMIT/OFFSET pages are stable
+var userSortOrders = map[string]string{
+	"created_at_desc": "created_at DESC, id ASC",
+	"created_at_asc":  "created_at ASC, id ASC",
+	"email_asc":       "email ASC",
+	"email_desc":      "email DESC",
+	"name_asc":        "full_name ASC, id ASC",
+	"name_desc":       "full_name DESC, id ASC",
+	"role_asc":        "role ASC, email ASC",
+	"role_desc":       "role DESC, email ASC",
+}
+
+// defaultUserSortOrder is used when no (or an unknown) sort is requested
+const defaultUserSortOrder = "created_at DESC, id ASC"
+
+// userColumns is the column list read by scanUser
+// updated_at is nullable in older rows, so it falls back to created_at
+const userColumns = `id, email, password_hash, full_name, role, status, created_at, COALESCE(updated_at, created_at)`
+
+// notDeleted restricts a query to users that haven't been soft-deleted
+const notDeleted = `status <> 'deleted'`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser scans a row selected with userColumns
+func scanUser(row rowScanner) (*models.User, error) {
+	var user models.User
+	err := row.Scan(
+		&user.ID, &user.Email, &user.PasswordHash,
+		&user.FullName, &user.Role, &user.Status, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 // UserRepository handles database operations for users
 type UserRepository struct {
 	db    *sql.DB
 	redis *redis.Client
+	keys  cache.Keyer
 }
 
 // NewUserRepository creates a new user repository
-func NewUserRepository(db *sql.DB, redisClient *redis.Client) *UserRepository {
+func NewUserRepository(db *sql.DB, redisClient *redis.Client, keys cache.Keyer) *UserRepository {
 	return &UserRepository{
 		db:    db,
 		redis: redisClient,
+		keys:  keys,
 	}
 }
 
@@ -32,14 +85,16 @@ func NewUserRepository(db *sql.DB, redisClient *redis.Client) *UserRepository {
 func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
 	user.ID = uuid.New().String()
 	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+	user.Status = models.UserStatusActive
 
 	query := `
-		INSERT INTO users (id, email, password_hash, full_name, role, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO users (id, email, password_hash, full_name, role, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 	`
 
 	_, err := r.db.ExecContext(ctx, query,
-		user.ID, user.Email, user.PasswordHash, user.FullName, user.Role, user.CreatedAt,
+		user.ID, user.Email, user.PasswordHash, user.FullName, user.Role, user.Status, user.CreatedAt, user.UpdatedAt,
 	)
 
 	if err != nil {
@@ -52,31 +107,25 @@ func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
 // GetByID retrieves a user by ID with Redis caching
 func (r *UserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
 	// Try cache first (reduces database load)
-	cacheKey := fmt.Sprintf("user:%s", id)
+	cacheKey := r.keys.Key("user:%s", id)
 	cached, err := r.redis.Get(ctx, cacheKey).Result()
 
 	if err == nil {
 		// Cache hit! Deserialize and return
+		// Entries cached before users had a status are treated as misses
 		var user models.User
-		if err := json.Unmarshal([]byte(cached), &user); err == nil {
+		if err := json.Unmarshal([]byte(cached), &user); err == nil && user.Status != "" {
 			return &user, nil
 		}
 	}
 
 	// Cache miss - query database
-	query := `
-		SELECT id, email, password_hash, full_name, role, created_at
-		FROM users WHERE id = $1
-	`
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND ` + notDeleted
 
-	var user models.User
-	err = r.db.QueryRowContext(ctx, query, id).Scan(
-		&user.ID, &user.Email, &user.PasswordHash,
-		&user.FullName, &user.Role, &user.CreatedAt,
-	)
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, id))
 
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("user not found")
+		return nil, ErrUserNotFound
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
@@ -87,63 +136,177 @@ func (r *UserRepository) GetByID(ctx context.Context, id string) (*models.User,
 		r.redis.Set(ctx, cacheKey, data, 10*time.Minute)
 	}
 
-	return &user, nil
+	return user, nil
+}
+
+// credentialsCacheEntry is a user as cached for login, which, unlike the
+// user's JSON, includes the password hash
+type credentialsCacheEntry struct {
+	models.User
+	PasswordHash string `json:"password_hash"`
 }
 
-// GetByEmail retrieves a user by email (for login)
+// GetByEmail retrieves a user by email (for login) with Redis caching
 func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
-	query := `
-		SELECT id, email, password_hash, full_name, role, created_at
-		FROM users WHERE email = $1
-	`
+	cacheKey := r.keys.Key("user:email:%s", email)
+	if cached, err := r.redis.Get(ctx, cacheKey).Result(); err == nil {
+		var entry credentialsCacheEntry
+		if err := json.Unmarshal([]byte(cached), &entry); err == nil && entry.Status != "" {
+			user := entry.User
+			user.PasswordHash = entry.PasswordHash
+			return &user, nil
+		}
+	}
 
-	var user models.User
-	err := r.db.QueryRowContext(ctx, query, email).Scan(
-		&user.ID, &user.Email, &user.PasswordHash,
-		&user.FullName, &user.Role, &user.CreatedAt,
-	)
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = $1 AND ` + notDeleted
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, email))
 
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("user not found")
+		return nil, ErrUserNotFound
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
-	return &user, nil
+	// Store in cache for 10 minutes
+	if data, err := json.Marshal(credentialsCacheEntry{User: *user, PasswordHash: user.PasswordHash}); err == nil {
+		r.redis.Set(ctx, cacheKey, data, 10*time.Minute)
+	}
+
+	return user, nil
 }
 
 // Update modifies user information
 func (r *UserRepository) Update(ctx context.Context, user *models.User) error {
+	user.UpdatedAt = time.Now()
+
+	// Returns the email from before the update, whose cache entry is now stale
 	query := `
-		UPDATE users 
-		SET email = $1, full_name = $2
-		WHERE id = $3
+		UPDATE users
+		SET email = $1, full_name = $2, updated_at = $3
+		FROM (SELECT email FROM users WHERE id = $4 AND ` + notDeleted + ` FOR UPDATE) AS previous
+		WHERE users.id = $4
+		RETURNING previous.email
 	`
 
-	result, err := r.db.ExecContext(ctx, query, user.Email, user.FullName, user.ID)
+	var previousEmail string
+	err := r.db.QueryRowContext(ctx, query, user.Email, user.FullName, user.UpdatedAt, user.ID).Scan(&previousEmail)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
 	if err != nil {
 		return fmt.Errorf("failed to update user: %w", err)
 	}
 
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("user not found")
+	r.invalidate(ctx, user.ID, previousEmail, user.Email)
+
+	return nil
+}
+
+// invalidate drops a user's cache entries: by ID, and by each of emails
+// (both the old and new address, when the email changed)
+func (r *UserRepository) invalidate(ctx context.Context, id string, emails ...string) {
+	keys := []string{r.keys.Key("user:%s", id)}
+	for _, email := range emails {
+		keys = append(keys, r.keys.Key("user:email:%s", email))
+	}
+	r.redis.Del(ctx, keys...)
+}
+
+// UpdateRole sets a user's role
+// Fails with ErrLastAdmin rather than demote the only active admin
+func (r *UserRepository) UpdateRole(ctx context.Context, id, role string) error {
+	return r.changeAccount(ctx, id, "role", role, role == auth.RoleAdmin)
+}
+
+// SetStatus sets a user's status, revoking the refresh and reset tokens of a
+// user who is no longer active
+// Fails with ErrLastAdmin rather than deactivate or delete the only active admin
+func (r *UserRepository) SetStatus(ctx context.Context, id, status string) error {
+	return r.changeAccount(ctx, id, "status", status, status == models.UserStatusActive)
+}
+
+// changeAccount sets column (role or status) of a user that isn't deleted
+// keepsAdmin reports whether the change leaves an active admin an active
+// admin; if not and they are the last one, ErrLastAdmin is returned.
+// Active admin rows are locked first, so two admins demoting each other at
+// the same time can't leave the system without one
+func (r *UserRepository) changeAccount(ctx context.Context, id, column, value string, keepsAdmin bool) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var admins int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM (SELECT id FROM users WHERE role = $1 AND status = $2 FOR UPDATE) AS admins
+	`, auth.RoleAdmin, models.UserStatusActive).Scan(&admins)
+	if err != nil {
+		return fmt.Errorf("failed to count admins: %w", err)
+	}
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND ` + notDeleted + ` FOR UPDATE`
+	current, err := scanUser(tx.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	activeAdmin := current.Role == auth.RoleAdmin && current.Status == models.UserStatusActive
+	if activeAdmin && !keepsAdmin && admins <= 1 {
+		return ErrLastAdmin
+	}
+
+	// column is "role" or "status", never user input
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `UPDATE users SET `+column+` = $1, updated_at = $2 WHERE id = $3`, value, now, id)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", column, err)
+	}
+
+	if column == "status" && value != models.UserStatusActive {
+		_, err = tx.ExecContext(ctx,
+			`UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL`,
+			now, id,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+		}
+		_, err = tx.ExecContext(ctx,
+			`UPDATE password_reset_tokens SET used_at = $1 WHERE user_id = $2 AND used_at IS NULL`,
+			now, id,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to expire reset tokens: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit %s change: %w", column, err)
 	}
 
-	// Invalidate cache
-	cacheKey := fmt.Sprintf("user:%s", user.ID)
-	r.redis.Del(ctx, cacheKey)
+	r.invalidate(ctx, id, current.Email)
 
 	return nil
 }
 
-// List retrieves all users (with pagination)
-func (r *UserRepository) List(ctx context.Context, limit, offset int) ([]*models.User, error) {
+// List retrieves all users (with pagination and sorting)
+// Unknown sort values fall back to newest first
+func (r *UserRepository) List(ctx context.Context, limit, offset int, sort string) ([]*models.User, error) {
+	orderBy, ok := userSortOrders[sort]
+	if !ok {
+		orderBy = defaultUserSortOrder
+	}
+
 	query := `
-		SELECT id, email, password_hash, full_name, role, created_at
+		SELECT ` + userColumns + `
 		FROM users
-		ORDER BY created_at DESC
+		WHERE ` + notDeleted + `
+		ORDER BY ` + orderBy + `
 		LIMIT $1 OFFSET $2
 	`
 
@@ -155,42 +318,123 @@ func (r *UserRepository) List(ctx context.Context, limit, offset int) ([]*models
 
 	var users []*models.User
 	for rows.Next() {
-		var user models.User
-		err := rows.Scan(
-			&user.ID, &user.Email, &user.PasswordHash,
-			&user.FullName, &user.Role, &user.CreatedAt,
-		)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan user: %w", err)
 		}
-		users = append(users, &user)
+		users = append(users, user)
 	}
 
 	return users, nil
 }
 
-// Delete removes a user from the database
-func (r *UserRepository) Delete(ctx context.Context, id string) error {
-	query := `DELETE FROM users WHERE id = $1`
+// Count returns the total number of users
+func (r *UserRepository) Count(ctx context.Context) (int, error) {
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE `+notDeleted).Scan(&total); err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return total, nil
+}
+
+// Search finds users whose email or full name contains the term (case-insensitive)
+func (r *UserRepository) Search(ctx context.Context, term string, limit, offset int) ([]*models.User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE (email ILIKE $1 ESCAPE '\' OR full_name ILIKE $1 ESCAPE '\') AND ` + notDeleted + `
+		ORDER BY email ASC
+		LIMIT $2 OFFSET $3
+	`
+
+	pattern := "%" + escapeLike(term) + "%"
 
-	result, err := r.db.ExecContext(ctx, query, id)
+	rows, err := r.db.QueryContext(ctx, query, pattern, limit, offset)
 	if err != nil {
-		return fmt.Errorf("failed to delete user: %w", err)
+		return nil, fmt.Errorf("failed to search users: %w", err)
 	}
+	defer rows.Close()
 
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("user not found")
+	var users []*models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
 	}
 
-	// Invalidate cache
-	cacheKey := fmt.Sprintf("user:%s", id)
-	r.redis.Del(ctx, cacheKey)
+	return users, nil
+}
 
-	return nil
+// CountSearch returns how many users match a Search term
+func (r *UserRepository) CountSearch(ctx context.Context, term string) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM users
+		WHERE (email ILIKE $1 ESCAPE '\' OR full_name ILIKE $1 ESCAPE '\') AND ` + notDeleted + `
+	`
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, query, "%"+escapeLike(term)+"%").Scan(&total); err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return total, nil
+}
+
+// SearchByEmail finds users whose email starts with prefix (case-insensitive)
+// An exact match sorts first
+func (r *UserRepository) SearchByEmail(ctx context.Context, prefix string, limit, offset int) ([]*models.User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE LOWER(email) LIKE $1 ESCAPE '\' AND ` + notDeleted + `
+		ORDER BY LOWER(email) = $2 DESC, email ASC
+		LIMIT $3 OFFSET $4
+	`
+
+	prefix = strings.ToLower(prefix)
+
+	rows, err := r.db.QueryContext(ctx, query, escapeLike(prefix)+"%", prefix, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// CountSearchByEmail returns how many users match a SearchByEmail prefix
+func (r *UserRepository) CountSearchByEmail(ctx context.Context, prefix string) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM users
+		WHERE LOWER(email) LIKE $1 ESCAPE '\' AND ` + notDeleted
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, query, escapeLike(strings.ToLower(prefix))+"%").Scan(&total); err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return total, nil
+}
+
+// Delete soft-deletes a user: the row stays so their orders keep a valid
+// user ID, but from then on they are treated as not found
+func (r *UserRepository) Delete(ctx context.Context, id string) error {
+	return r.SetStatus(ctx, id, models.UserStatusDeleted)
 }
 
 // EmailExists checks if an email is already registered
+// Deleted users still hold their email, as it is unique across all rows
 func (r *UserRepository) EmailExists(ctx context.Context, email string) (bool, error) {
 	query := `SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)`
 
@@ -203,7 +447,54 @@ func (r *UserRepository) EmailExists(ctx context.Context, email string) (bool, e
 	return exists, nil
 }
 
+// CreateRefreshToken stores the hash of a newly issued refresh token
+func (r *UserRepository) CreateRefreshToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error {
+	query := `
+		INSERT INTO refresh_tokens (id, user_id, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err := r.db.ExecContext(ctx, query, uuid.New().String(), userID, tokenHash, expiresAt, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeRefreshToken revokes a live refresh token and returns its user ID
+// Revocation and lookup happen in one statement, so a token can only be used once
+func (r *UserRepository) ConsumeRefreshToken(ctx context.Context, tokenHash string) (string, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = $1
+		WHERE token_hash = $2 AND revoked_at IS NULL AND expires_at > $1
+		RETURNING user_id
+	`
+
+	var userID string
+	err := r.db.QueryRowContext(ctx, query, time.Now(), tokenHash).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("refresh token not found, expired or revoked")
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to consume refresh token: %w", err)
+	}
+
+	return userID, nil
+}
+
+// escapeLike escapes LIKE wildcards so user input is matched literally
+func escapeLike(term string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(term)
+}
+
 // HealthCheck verifies database connectivity
 func (r *UserRepository) HealthCheck(ctx context.Context) error {
 	return r.db.PingContext(ctx)
 }
+
+// CacheHealthCheck pings Redis
+func (r *UserRepository) CacheHealthCheck(ctx context.Context) error {
+	return r.redis.Ping(ctx).Err()
+}
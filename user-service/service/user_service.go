@@ -2,46 +2,111 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 
+	"ecommerce/shared/auth"
+	apperrors "ecommerce/shared/errors"
+	sharedmessaging "ecommerce/shared/messaging"
 	"ecommerce/shared/models"
+	"ecommerce/shared/pagination"
+	"ecommerce/user-service/messaging"
 	"ecommerce/user-service/repository"
 )
 
 var (
-	ErrInvalidCredentials = errors.New("invalid email or password")
-	ErrEmailExists        = errors.New("email already registered")
-	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidCredentials = apperrors.Unauthorized("invalid_credentials", "invalid email or password")
+	ErrEmailExists        = apperrors.Conflict("email_exists", "email already registered")
+	ErrFieldsRequired     = apperrors.Validation("fields_required", "all fields are required")
+	ErrUserNotFound       = apperrors.NotFound("user_not_found", "user not found")
+	ErrSearchTermRequired = apperrors.Validation("search_term_required", "search term is required")
+	ErrInvalidRefresh     = apperrors.Unauthorized("invalid_refresh_token", "invalid or expired refresh token")
+	ErrTokenRevoked       = apperrors.Unauthorized("token_revoked", "token has been revoked")
+	ErrInvalidToken       = apperrors.Unauthorized("invalid_token", "invalid token")
+	ErrTokenNotRevocable  = apperrors.Validation("token_not_revocable", "token has no jti and cannot be revoked")
+	ErrInvalidResetToken  = apperrors.Validation("invalid_reset_token", "invalid or expired reset token")
+	ErrInvalidRole        = apperrors.Validation("invalid_role", "invalid role")
+	ErrLastAdmin          = apperrors.Conflict("last_admin", "cannot remove the last active admin")
+	ErrAccountInactive    = apperrors.Forbidden("account_inactive", "account is not active")
+	ErrPasswordTooShort   = apperrors.Validation("password_too_short", fmt.Sprintf("password must be at least %d characters", minPasswordLength))
 )
 
+// refreshTokenTTL is how long a refresh token can be exchanged for a new access token
+const refreshTokenTTL = 30 * 24 * time.Hour
+
+// minPasswordLength is the shortest password accepted at registration or reset
+const minPasswordLength = 6
+
 // UserService handles business logic for users
 type UserService struct {
-	repo      *repository.UserRepository
-	jwtSecret []byte
+	repo          *repository.UserRepository
+	jwtKeys       *auth.Keyring
+	jwtAlgorithms []string
+	signingMethod jwt.SigningMethod
+	denylist      *auth.Denylist
+	bcryptCost    int
+	publisher     *messaging.RabbitMQPublisher
+	resetURL      *url.URL      // Page a password reset link opens
+	resetTTL      time.Duration // How long a reset link works
 }
 
 // NewUserService creates a new user service
-func NewUserService(repo *repository.UserRepository, jwtSecret string) *UserService {
-	return &UserService{
-		repo:      repo,
-		jwtSecret: []byte(jwtSecret),
+// Tokens are signed with the first of jwtAlgorithms and the primary key of jwtKeys,
+// and verified against all of them. Passwords are hashed with bcryptCost,
+// or bcrypt's default if it is out of range (config.Validate warns about it).
+// Password reset links point at resetURL and are sent through publisher
+func NewUserService(repo *repository.UserRepository, jwtKeys *auth.Keyring, jwtAlgorithms []string, denylist *auth.Denylist, bcryptCost int, publisher *messaging.RabbitMQPublisher, resetURL string, resetTTL time.Duration) (*UserService, error) {
+	signingMethod, err := auth.SigningMethod(jwtAlgorithms)
+	if err != nil {
+		return nil, err
+	}
+
+	if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+		bcryptCost = bcrypt.DefaultCost
+	}
+
+	parsedResetURL, err := url.Parse(resetURL)
+	if err != nil || parsedResetURL.Scheme == "" || parsedResetURL.Host == "" {
+		return nil, fmt.Errorf("invalid password reset URL %q", resetURL)
 	}
+
+	return &UserService{
+		repo:          repo,
+		jwtKeys:       jwtKeys,
+		jwtAlgorithms: jwtAlgorithms,
+		signingMethod: signingMethod,
+		denylist:      denylist,
+		bcryptCost:    bcryptCost,
+		publisher:     publisher,
+		resetURL:      parsedResetURL,
+		resetTTL:      resetTTL,
+	}, nil
+}
+
+// Permissions returns what the given role may do; unknown roles get none
+func (s *UserService) Permissions(role string) []string {
+	return auth.PermissionsForRole(role)
 }
 
 // Register creates a new user account
 func (s *UserService) Register(ctx context.Context, email, password, fullName string) (*models.User, error) {
 	// Validate input
 	if email == "" || password == "" || fullName == "" {
-		return nil, errors.New("all fields are required")
+		return nil, ErrFieldsRequired
 	}
 
-	if len(password) < 6 {
-		return nil, errors.New("password must be at least 6 characters")
+	if len(password) < minPasswordLength {
+		return nil, ErrPasswordTooShort
 	}
 
 	// Check if email already exists
@@ -64,7 +129,7 @@ func (s *UserService) Register(ctx context.Context, email, password, fullName st
 		Email:        email,
 		PasswordHash: passwordHash,
 		FullName:     fullName,
-		Role:         "customer", // Default role
+		Role:         auth.RoleCustomer, // Default role
 	}
 
 	if err := s.repo.Create(ctx, user); err != nil {
@@ -87,33 +152,132 @@ func (s *UserService) Login(ctx context.Context, email, password string) (*model
 		return nil, ErrInvalidCredentials
 	}
 
-	// Generate JWT token
-	token, expiresAt, err := s.generateToken(user)
+	// Only reported after the password matches, so it doesn't reveal accounts
+	if user.Status != models.UserStatusActive {
+		return nil, ErrAccountInactive
+	}
+
+	return s.issueTokens(ctx, user)
+}
+
+// Refresh exchanges a refresh token for a new access token and refresh token
+// The presented refresh token is revoked (rotation), so replaying it fails
+func (s *UserService) Refresh(ctx context.Context, refreshToken string) (*models.LoginResponse, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidRefresh
+	}
+
+	userID, err := s.repo.ConsumeRefreshToken(ctx, hashToken(refreshToken))
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate token: %w", err)
+		return nil, ErrInvalidRefresh
 	}
 
-	return &models.LoginResponse{
-		Token:     token,
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil || user.Status != models.UserStatusActive {
+		return nil, ErrInvalidRefresh
+	}
+
+	return s.issueTokens(ctx, user)
+}
+
+// Logout revokes an access token until it would have expired anyway
+func (s *UserService) Logout(ctx context.Context, tokenString string) error {
+	claims, err := auth.ParseToken(tokenString, s.jwtKeys, s.jwtAlgorithms)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.ID == "" {
+		return ErrTokenNotRevocable
+	}
+
+	if err := s.denylist.Deny(ctx, claims.ID, claims.ExpiresAt); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	return nil
+}
+
+// RequestPasswordReset emails a single-use reset link to the account with
+// this email, if there is one
+// Unknown emails are not an error, so callers can't use this to find out
+// which emails are registered
+func (s *UserService) RequestPasswordReset(ctx context.Context, email string) error {
+	user, err := s.repo.GetByEmail(ctx, email)
+	if err != nil || user.Status != models.UserStatusActive {
+		return nil
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate reset token: %w", err)
+	}
+	expiresAt := time.Now().Add(s.resetTTL)
+	if err := s.repo.CreatePasswordResetToken(ctx, user.ID, hashToken(token), expiresAt); err != nil {
+		return err
+	}
+
+	link := *s.resetURL
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	err = s.publisher.PublishUserEvent(messaging.UserEvent{
+		Type:      sharedmessaging.EventUserPasswordReset,
+		UserID:    user.ID,
+		Email:     user.Email,
+		ResetURL:  link.String(),
 		ExpiresAt: expiresAt,
-		User:      *user,
-	}, nil
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send reset link: %w", err)
+	}
+
+	return nil
+}
+
+// ResetPassword sets a new password using a token from RequestPasswordReset
+// The user's other reset links and refresh tokens stop working
+func (s *UserService) ResetPassword(ctx context.Context, token, password string) error {
+	if len(password) < minPasswordLength {
+		return ErrPasswordTooShort
+	}
+	if token == "" {
+		return ErrInvalidResetToken
+	}
+
+	passwordHash, err := s.hashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	if _, err := s.repo.ResetPassword(ctx, hashToken(token), passwordHash); err != nil {
+		if errors.Is(err, repository.ErrResetTokenNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+
+	return nil
 }
 
 // GetUserByID retrieves a user by ID
 func (s *UserService) GetUserByID(ctx context.Context, id string) (*models.User, error) {
 	user, err := s.repo.GetByID(ctx, id)
-	if err != nil {
+	if errors.Is(err, repository.ErrUserNotFound) {
 		return nil, ErrUserNotFound
 	}
+	if err != nil {
+		return nil, err
+	}
 	return user, nil
 }
 
 // UpdateProfile updates user information
 func (s *UserService) UpdateProfile(ctx context.Context, userID string, email, fullName string) (*models.User, error) {
-	user, err := s.repo.GetByID(ctx, userID)
+	user, err := s.GetUserByID(ctx, userID)
 	if err != nil {
-		return nil, ErrUserNotFound
+		return nil, err
 	}
 
 	// Update fields
@@ -125,52 +289,153 @@ func (s *UserService) UpdateProfile(ctx context.Context, userID string, email, f
 	}
 
 	if err := s.repo.Update(ctx, user); err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, ErrUserNotFound
+		}
 		return nil, fmt.Errorf("failed to update user: %w", err)
 	}
 
 	return user, nil
 }
 
-// ListUsers returns all users (admin only)
-func (s *UserService) ListUsers(ctx context.Context, page, pageSize int) ([]*models.User, error) {
-	offset := (page - 1) * pageSize
-	return s.repo.List(ctx, pageSize, offset)
+// ListUsers returns a page of users and the total user count (admin only)
+func (s *UserService) ListUsers(ctx context.Context, page pagination.Page, sort string) ([]*models.User, int, error) {
+	users, err := s.repo.List(ctx, page.Size, page.Offset(), sort)
+	if err != nil {
+		return nil, 0, err
+	}
+	if users == nil {
+		users = []*models.User{} // Serialize as [] rather than null
+	}
+
+	total, err := s.repo.Count(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
 }
 
-// DeleteUser removes a user (admin only)
-func (s *UserService) DeleteUser(ctx context.Context, id string) error {
-	return s.repo.Delete(ctx, id)
+// SearchUsers finds users by partial email or name (admin only)
+func (s *UserService) SearchUsers(ctx context.Context, term string, page pagination.Page) ([]*models.User, int, error) {
+	term = strings.TrimSpace(term)
+	if term == "" {
+		return nil, 0, ErrSearchTermRequired
+	}
+
+	users, err := s.repo.Search(ctx, term, page.Size, page.Offset())
+	if err != nil {
+		return nil, 0, err
+	}
+	if users == nil {
+		users = []*models.User{}
+	}
+
+	total, err := s.repo.CountSearch(ctx, term)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
 }
 
-// ValidateToken verifies a JWT token and returns the user
-func (s *UserService) ValidateToken(tokenString string) (*models.User, error) {
-	// Parse token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Verify signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return s.jwtSecret, nil
-	})
+// UpdateRole changes a user's role (admin only)
+// Tokens already issued keep the old role until they expire
+func (s *UserService) UpdateRole(ctx context.Context, id, role string) (*models.User, error) {
+	if !auth.IsRole(role) {
+		return nil, ErrInvalidRole
+	}
+
+	if err := s.repo.UpdateRole(ctx, id, role); err != nil {
+		return nil, accountError(err)
+	}
+
+	return s.GetUserByID(ctx, id)
+}
+
+// DeactivateUser suspends a user (admin only): they can't log in, and their
+// tokens stop working in user-service and can't be refreshed
+func (s *UserService) DeactivateUser(ctx context.Context, id string) (*models.User, error) {
+	if err := s.repo.SetStatus(ctx, id, models.UserStatusDeactivated); err != nil {
+		return nil, accountError(err)
+	}
+	return s.GetUserByID(ctx, id)
+}
 
+// ReactivateUser lifts a deactivation (admin only)
+func (s *UserService) ReactivateUser(ctx context.Context, id string) (*models.User, error) {
+	if err := s.repo.SetStatus(ctx, id, models.UserStatusActive); err != nil {
+		return nil, accountError(err)
+	}
+	return s.GetUserByID(ctx, id)
+}
+
+// SearchUsersByEmail finds users whose email starts with the given text,
+// ignoring case; an exact match comes first (admin only)
+func (s *UserService) SearchUsersByEmail(ctx context.Context, email string, page pagination.Page) ([]*models.User, int, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return nil, 0, ErrSearchTermRequired
+	}
+
+	users, err := s.repo.SearchByEmail(ctx, email, page.Size, page.Offset())
+	if err != nil {
+		return nil, 0, err
+	}
+	if users == nil {
+		users = []*models.User{}
+	}
+
+	total, err := s.repo.CountSearchByEmail(ctx, email)
 	if err != nil {
-		return nil, fmt.Errorf("invalid token: %w", err)
+		return nil, 0, err
 	}
 
-	// Extract claims
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return nil, errors.New("invalid token claims")
+	return users, total, nil
+}
+
+// DeleteUser soft-deletes a user (admin only); their orders are kept
+func (s *UserService) DeleteUser(ctx context.Context, id string) error {
+	return accountError(s.repo.Delete(ctx, id))
+}
+
+// accountError maps repository errors from role and status changes to the
+// service's errors
+func accountError(err error) error {
+	switch {
+	case errors.Is(err, repository.ErrUserNotFound):
+		return ErrUserNotFound
+	case errors.Is(err, repository.ErrLastAdmin):
+		return ErrLastAdmin
+	default:
+		return err
+	}
+}
+
+// ValidateToken verifies a JWT token and returns the user
+func (s *UserService) ValidateToken(ctx context.Context, tokenString string) (*models.User, error) {
+	claims, err := auth.ParseToken(tokenString, s.jwtKeys, s.jwtAlgorithms)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
 	}
 
-	// Get user ID from claims
-	userID, ok := claims["user_id"].(string)
-	if !ok {
-		return nil, errors.New("invalid user_id in token")
+	// Reject tokens revoked by logout
+	if s.denylist.IsDenied(ctx, claims.ID) {
+		return nil, ErrTokenRevoked
 	}
 
-	// Retrieve user
-	return s.repo.GetByID(context.Background(), userID)
+	// Retrieve user; deactivated users' tokens are refused, deleted users aren't found
+	user, err := s.repo.GetByID(ctx, claims.UserID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Status != models.UserStatusActive {
+		return nil, ErrAccountInactive
+	}
+	return user, nil
 }
 
 // HealthCheck verifies service health
@@ -178,12 +443,16 @@ func (s *UserService) HealthCheck(ctx context.Context) error {
 	return s.repo.HealthCheck(ctx)
 }
 
+// CacheHealthCheck verifies Redis; the service keeps working without it
+func (s *UserService) CacheHealthCheck(ctx context.Context) error {
+	return s.repo.CacheHealthCheck(ctx)
+}
+
 // --- Private helper methods ---
 
 // hashPassword creates a bcrypt hash of the password
 func (s *UserService) hashPassword(password string) (string, error) {
-	// Cost 10 is a good balance between security and performance
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.bcryptCost)
 	if err != nil {
 		return "", err
 	}
@@ -196,6 +465,55 @@ func (s *UserService) comparePassword(hash, password string) bool {
 	return err == nil
 }
 
+// issueTokens creates an access token and a stored refresh token for a user
+func (s *UserService) issueTokens(ctx context.Context, user *models.User) (*models.LoginResponse, error) {
+	token, expiresAt, err := s.generateToken(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	refreshToken, err := s.generateRefreshToken(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	return &models.LoginResponse{
+		Token:        token,
+		ExpiresAt:    expiresAt,
+		RefreshToken: refreshToken,
+		User:         *user,
+	}, nil
+}
+
+// generateRefreshToken creates an opaque random token and stores its hash
+func (s *UserService) generateRefreshToken(ctx context.Context, userID string) (string, error) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.repo.CreateRefreshToken(ctx, userID, hashToken(token), time.Now().Add(refreshTokenTTL)); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// generateOpaqueToken returns 32 random bytes, hex-encoded
+func generateOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// hashToken returns the SHA-256 hex digest stored in place of a refresh or reset token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 // generateToken creates a JWT token for a user
 func (s *UserService) generateToken(user *models.User) (string, int64, error) {
 	// Token expires in 24 hours
@@ -203,6 +521,7 @@ func (s *UserService) generateToken(user *models.User) (string, int64, error) {
 
 	// Create claims
 	claims := jwt.MapClaims{
+		"jti":     uuid.New().String(), // Token ID, so logout can revoke this token alone
 		"user_id": user.ID,
 		"email":   user.Email,
 		"role":    user.Role,
@@ -211,10 +530,13 @@ func (s *UserService) generateToken(user *models.User) (string, int64, error) {
 	}
 
 	// Create token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token := jwt.NewWithClaims(s.signingMethod, claims)
+	if kid := s.jwtKeys.PrimaryID(); kid != "" {
+		token.Header["kid"] = kid // Tells verifiers which secret signed it
+	}
 
 	// Sign token
-	tokenString, err := token.SignedString(s.jwtSecret)
+	tokenString, err := token.SignedString(s.jwtKeys.Primary())
 	if err != nil {
 		return "", 0, err
 	}